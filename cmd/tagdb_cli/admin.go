@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/tagdb"
+)
+
+type adminSnapshotInvoker struct{}
+
+func (i *adminSnapshotInvoker) Invoke() int {
+	if _, err := newClient().do(http.MethodPost, "/api/admin/snapshot", nil); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot snapshot because %s\n", err)
+		return 1
+	}
+
+	fmt.Println("snapshot written")
+	return 0
+}
+
+type adminStatsInvoker struct{}
+
+func (i *adminStatsInvoker) Invoke() int {
+	data, err := newClient().do(http.MethodGet, "/api/admin/stats", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot get stats because %s\n", err)
+		return 1
+	}
+
+	var stats tagdb.Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read stats because %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("records:              %d\n", stats.Records)
+	fmt.Printf("wal files:            %d (%d compressed)\n", stats.WalFiles, stats.CompressedWalFiles)
+	fmt.Printf("wal bytes:            %d\n", stats.WalBytes)
+	fmt.Printf("saved by compression: %d\n", stats.WalBytesSaved)
+	return 0
+}
+
+type adminBackupInvoker struct {
+	File string `arg:"0:<file>" help:"Path of the backup archive to write, such as tagdb.tar.gz."`
+}
+
+func (i *adminBackupInvoker) Invoke() int {
+	if err := newClient().download("/api/admin/backup", i.File); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot backup because %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("backup written to %s\n", i.File)
+	return 0
+}
+
+// Restores locally, as the database must not be running on the target root.
+type adminRestoreInvoker struct {
+	File string `arg:"0:<file>" help:"Path of the backup archive to restore."`
+	Root string `arg:"1:<root>" help:"Empty storage root to restore into."`
+}
+
+func (i *adminRestoreInvoker) Invoke() int {
+	file, err := os.Open(i.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open backup because %s\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	keyId, keys, err := readEncryptionKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read encryption keys because %s\n", err)
+		return 1
+	}
+
+	if err := tagdb.Restore(file, i.Root, tagdb.WithEncryptionKeys(keyId, keys...)); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot restore because %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("backup restored to %s\n", i.Root)
+	return 0
+}
+
+// Re-encrypts locally, as the database must not be running on the root.
+type adminReencryptInvoker struct {
+	Root string `arg:"0:<root>" help:"Storage root to re-encrypt."`
+}
+
+func (i *adminReencryptInvoker) Invoke() int {
+	keyId, keys, err := readEncryptionKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read encryption keys because %s\n", err)
+		return 1
+	}
+
+	count, err := tagdb.Reencrypt(i.Root, tagdb.WithEncryptionKeys(keyId, keys...))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot re-encrypt because %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("re-encrypted %d file(s) in %s\n", count, i.Root)
+	return 0
+}
+
+// Reads the storage encryption keys from the same environment variables as the web server.
+func readEncryptionKeys() (string, []tagdb.EncryptionKey, error) {
+	keyId := os.Getenv("TAGDB_STORAGE_ENCRYPTION_KEY_ID")
+	if value := os.Getenv("TAGDB_STORAGE_ENCRYPTION_KEYS"); value != "" {
+		keys, err := tagdb.ParseEncryptionKeys(value)
+		return keyId, keys, err
+	}
+
+	if keysFile := os.Getenv("TAGDB_STORAGE_ENCRYPTION_KEYS_FILE"); keysFile != "" {
+		keys, err := tagdb.ReadEncryptionKeysFile(keysFile)
+		return keyId, keys, err
+	}
+
+	return keyId, nil, nil
+}
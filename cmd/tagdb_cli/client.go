@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTagDbUrl = "http://localhost:8080"
+	requestTimeout  = 10 * time.Second
+
+	// Error responses are read up to this size.
+	maxErrorBodyBytes = 64 * 1024
+)
+
+// A minimal client for the tagdb web API.
+// The server is read from the TAGDB_URL environment variable.
+type client struct {
+	baseUrl string
+	http    *http.Client
+
+	// Used for imports, exports and backups, which can run for longer than requestTimeout.
+	// Only connecting is bounded.
+	streaming *http.Client
+}
+
+func newClient() *client {
+	baseUrl := os.Getenv("TAGDB_URL")
+	if baseUrl == "" {
+		baseUrl = defaultTagDbUrl
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: requestTimeout}).DialContext
+
+	return &client{
+		baseUrl:   strings.TrimSuffix(baseUrl, "/"),
+		http:      &http.Client{Timeout: requestTimeout},
+		streaming: &http.Client{Transport: transport},
+	}
+}
+
+// Sends a request, with an optional JSON body, and returns the response body.
+// Responses outside the 2xx range are returned as errors.
+func (c *client) do(method, path string, body any) ([]byte, error) {
+	var reader io.Reader
+	var contentType string
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode request because %w", err)
+		}
+
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	resp, err := c.open(c.http, method, path, contentType, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response because %w", err)
+	}
+
+	return data, nil
+}
+
+// Sends a request with a raw body, such as an import, and returns the response body.
+// There is no overall timeout, so large bodies can be uploaded.
+// Responses outside the 2xx range are returned as errors.
+func (c *client) send(method, path, contentType string, body io.Reader) ([]byte, error) {
+	resp, err := c.open(c.streaming, method, path, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response because %w", err)
+	}
+
+	return data, nil
+}
+
+// Sends a GET request, and copies the response body to w as it arrives.
+// There is no overall timeout, so large exports and backups can be downloaded.
+func (c *client) copyTo(path string, w io.Writer) error {
+	resp, err := c.open(c.streaming, http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("cannot read response because %w", err)
+	}
+
+	return nil
+}
+
+// Downloads a response body to a file, readable by the owner only.
+// The file is removed when the download fails, so a partial download is not left behind.
+func (c *client) download(path, fileName string) error {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// An existing file keeps its permissions when opened.
+	err = file.Chmod(0600)
+	if err == nil {
+		err = c.copyTo(path, file)
+	}
+
+	if err = errors.Join(err, file.Close()); err != nil {
+		os.Remove(fileName)
+		return err
+	}
+
+	return nil
+}
+
+// Sends a request, and returns the response for the caller to read and close.
+// Responses outside the 2xx range are returned as errors.
+func (c *client) open(httpClient *http.Client, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return nil, fmt.Errorf("%s %s failed with %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/tagdb"
+)
+
+// Mirrors the tagdb_ws KeyValue request body.
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TtlMs int64  `json:"ttlMs,omitempty"`
+}
+
+// Mirrors the tagdb ImportResult response body.
+type importResult struct {
+	Rows     int  `json:"rows"`
+	Imported int  `json:"imported"`
+	DryRun   bool `json:"dryRun"`
+	Errors   []struct {
+		Row     int    `json:"row"`
+		Key     string `json:"key"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Mirrors the tagdb HistoryEntry response body.
+type historyEntry struct {
+	TransactionId string    `json:"transactionId"`
+	Committed     time.Time `json:"committed"`
+	Op            string    `json:"op"`
+	Value         string    `json:"value"`
+	Tag           string    `json:"tag"`
+}
+
+type keysSetInvoker struct {
+	Key   string `arg:"0:<key>" help:"Record key."`
+	Value string `arg:"1:<value>" help:"Record value."`
+	TTL   string `option:"--ttl" help:"Expire the record after a duration, such as 30m or 24h."`
+}
+
+func (i *keysSetInvoker) Invoke() int {
+	body := keyValue{Key: i.Key, Value: i.Value}
+
+	if i.TTL != "" {
+		ttl, err := time.ParseDuration(i.TTL)
+		if err != nil || ttl <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid ttl `%s`, expected a positive duration such as 30m\n", i.TTL)
+			return 1
+		}
+
+		// The api accepts whole milliseconds, and a zero ttl means never expire.
+		if ttl < time.Millisecond {
+			fmt.Fprintf(os.Stderr, "invalid ttl `%s`, expected at least 1ms\n", i.TTL)
+			return 1
+		}
+		body.TtlMs = ttl.Milliseconds()
+	}
+
+	if _, err := newClient().do(http.MethodPost, "/api/keys", body); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set key because %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+type keysHistoryInvoker struct {
+	Key string `arg:"0:<key>" help:"Record key."`
+}
+
+func (i *keysHistoryInvoker) Invoke() int {
+	data, err := newClient().do(http.MethodGet, "/api/keys/"+url.PathEscape(i.Key)+"/history", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot get history because %s\n", err)
+		return 1
+	}
+
+	var entries []historyEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read history because %s\n", err)
+		return 1
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "COMMITTED\tOP\tTAG\tVALUE\tTRANSACTION")
+	for _, entry := range entries {
+		committed := "-"
+		if !entry.Committed.IsZero() {
+			committed = entry.Committed.Local().Format(time.DateTime)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%q\t%s\n", committed, entry.Op, entry.Tag, entry.Value, entry.TransactionId)
+	}
+
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write history because %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+type keysExportInvoker struct {
+	Format string `option:"--format" help:"Output format, jsonl or csv.  Defaults to jsonl."`
+	Tags   string `option:"--tags" help:"Only export records with all of these comma separated tags."`
+	Out    string `option:"--out" help:"Path of the file to write.  Defaults to stdout."`
+}
+
+func (i *keysExportInvoker) Invoke() int {
+	query := url.Values{}
+	if i.Format != "" {
+		query.Set("format", i.Format)
+	}
+
+	if i.Tags != "" {
+		query.Set("tags", i.Tags)
+	}
+
+	path := "/api/export?" + query.Encode()
+	if i.Out == "" {
+		if err := newClient().copyTo(path, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot export because %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := newClient().download(path, i.Out); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot export because %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("export written to %s\n", i.Out)
+	return 0
+}
+
+type keysImportInvoker struct {
+	File   string `arg:"0:<file>" help:"Path of the JSON Lines or CSV file to import."`
+	Format string `option:"--format" help:"Input format, jsonl or csv.  Defaults to the file extension."`
+	DryRun bool   `option:"--dry-run" help:"Validate every row without importing."`
+}
+
+func (i *keysImportInvoker) Invoke() int {
+	format := i.Format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(i.File), ".")
+	}
+
+	file, err := os.Open(i.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open import because %s\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	query := url.Values{}
+	query.Set("format", format)
+	if i.DryRun {
+		query.Set("dryRun", "true")
+	}
+
+	contentType := "application/x-ndjson"
+	if format == "csv" {
+		contentType = "text/csv"
+	}
+
+	data, err := newClient().send(http.MethodPost, "/api/import?"+query.Encode(), contentType, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot import because %s\n", err)
+		return 1
+	}
+
+	var result importResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read import result because %s\n", err)
+		return 1
+	}
+
+	for _, rowErr := range result.Errors {
+		fmt.Fprintf(os.Stderr, "row %d %q: %s\n", rowErr.Row, rowErr.Key, rowErr.Message)
+	}
+
+	verb := "imported"
+	if result.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d of %d row(s), with %d error(s)\n", verb, result.Imported, result.Rows, len(result.Errors))
+
+	if len(result.Errors) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+type keysBulkInvoker struct {
+	Action string `arg:"0:<action>" help:"Action to apply, tag, untag or delete."`
+	Tag    string `option:"--tag" help:"Tag to add or remove."`
+	Query  string `option:"--query" help:"Select records by tag query, such as '2025 AND done'."`
+	Prefix string `option:"--prefix" help:"Select records by key prefix."`
+	DryRun bool   `option:"--dry-run" help:"List the affected records without changing them."`
+}
+
+func (i *keysBulkInvoker) Invoke() int {
+	body := map[string]any{
+		"action": i.Action,
+		"tag":    i.Tag,
+		"query":  i.Query,
+		"prefix": i.Prefix,
+		"dryRun": i.DryRun,
+	}
+
+	data, err := newClient().do(http.MethodPost, "/api/bulk", body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot apply bulk %s because %s\n", i.Action, err)
+		return 1
+	}
+
+	var result tagdb.BulkResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read bulk result because %s\n", err)
+		return 1
+	}
+
+	for _, key := range result.Keys {
+		fmt.Println(key)
+	}
+
+	verb := "affected"
+	if result.DryRun {
+		verb = "would affect"
+	}
+	fmt.Printf("%s %d of %d matched record(s)\n", verb, result.Affected, result.Matched)
+	return 0
+}
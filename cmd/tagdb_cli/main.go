@@ -5,18 +5,95 @@ import (
 	"os"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/cli"
+	_ "dev.azure.com/trayport/Hackathon/_git/Q/internal/dotenv"
 )
 
 func main() {
-	fmt.Println("cli parsing test")
-	fmt.Println("----------------")
-	fmt.Println()
-
 	builder := cli.Builder{}
 	builder.Name("tagdb-cli")
 	builder.Version("0.1.0-test")
 	builder.Description("A CLI for TagDB")
 
+	keys, err := builder.AddBranch("keys", "manage records")
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = keys.AddCommand("set", "create or update a record", &keysSetInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = keys.AddCommand("history", "show the committed changes to a record", &keysHistoryInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = keys.AddCommand("export", "export records as JSON Lines or CSV", &keysExportInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = keys.AddCommand("import", "import records from JSON Lines or CSV", &keysImportInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = keys.AddCommand("bulk", "tag, untag or delete every record matching a query or prefix", &keysBulkInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	tags, err := builder.AddBranch("tags", "manage the tag vocabulary")
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tags.AddCommand("list", "list tags with their record counts", &tagsListInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tags.AddCommand("rename", "rename a tag on every record", &tagsRenameInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tags.AddCommand("merge", "merge tags into a target tag on every record", &tagsMergeInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	admin, err := builder.AddBranch("admin", "database administration")
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = admin.AddCommand("snapshot", "write a snapshot and compact the wal", &adminSnapshotInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = admin.AddCommand("stats", "show storage statistics", &adminStatsInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = admin.AddCommand("backup", "download a consistent backup", &adminBackupInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = admin.AddCommand("restore", "restore a backup into an empty storage root", &adminRestoreInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = admin.AddCommand("reencrypt", "rewrite storage files with the active encryption key", &adminReencryptInvoker{})
+	if err != nil {
+		panic(err)
+	}
+
 	branch, err := builder.AddBranch("wip", "testing api structure")
 	if err != nil {
 		panic(err)
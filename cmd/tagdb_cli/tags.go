@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/tagdb"
+)
+
+// Mirrors the tagdb_ws TagChangeResult response body.
+type tagChangeResult struct {
+	Records int `json:"records"`
+}
+
+type tagsListInvoker struct {
+	Prefix string `option:"--prefix" help:"Only list tags starting with the prefix."`
+}
+
+func (i *tagsListInvoker) Invoke() int {
+	data, err := newClient().do(http.MethodGet, "/api/tags?prefix="+url.QueryEscape(i.Prefix), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot list tags because %s\n", err)
+		return 1
+	}
+
+	var tags []tagdb.TagInfo
+	if err := json.Unmarshal(data, &tags); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read tags because %s\n", err)
+		return 1
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TAG\tRECORDS\tCOLOUR\tDESCRIPTION")
+	for _, info := range tags {
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\n", info.Tag, info.Count, info.Colour, info.Description)
+	}
+
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write tags because %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+type tagsRenameInvoker struct {
+	Old string `arg:"0:<old>" help:"Tag to rename."`
+	New string `arg:"1:<new>" help:"New name of the tag."`
+}
+
+func (i *tagsRenameInvoker) Invoke() int {
+	body := map[string]string{"tag": i.New}
+	data, err := newClient().do(http.MethodPost, "/api/tags/"+url.PathEscape(i.Old)+"/rename", body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot rename tag because %s\n", err)
+		return 1
+	}
+
+	return printTagChangeResult(data)
+}
+
+type tagsMergeInvoker struct {
+	Target  string   `arg:"0:<target>" help:"Tag to merge into.  Created when not in use."`
+	Sources []string `arg:"1:<sources>" help:"Tags to merge, and remove."`
+}
+
+func (i *tagsMergeInvoker) Invoke() int {
+	body := map[string]any{"sources": i.Sources, "target": i.Target}
+	data, err := newClient().do(http.MethodPost, "/api/tags/merge", body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot merge tags because %s\n", err)
+		return 1
+	}
+
+	return printTagChangeResult(data)
+}
+
+func printTagChangeResult(data []byte) int {
+	var result tagChangeResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read result because %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("%d record(s) changed\n", result.Records)
+	return 0
+}
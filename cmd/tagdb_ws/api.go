@@ -3,16 +3,28 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/tagdb"
 )
 
+const (
+	// Comments are sent on idle watch streams, so proxies do not close them.
+	watchKeepAliveInterval = 30 * time.Second
+)
+
 type KeyValue struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+
+	// Optional time to live in milliseconds.  The record expires after this duration.
+	TtlMs int64 `json:"ttlMs,omitempty"`
 }
 
 type TagKey struct {
@@ -20,12 +32,41 @@ type TagKey struct {
 	Key string `json:"key"`
 }
 
+// The new name of a renamed tag.
+type TagRename struct {
+	Tag string `json:"tag"`
+}
+
+// The tags to merge, and the tag to merge them into.
+type TagMerge struct {
+	Sources []string `json:"sources"`
+	Target  string   `json:"target"`
+}
+
+// Selects records by tag query, key prefix, or both, and the action to apply to them.
+// Action is one of tag, untag or delete.  Tag is required to tag or untag.
+type BulkRequest struct {
+	Action string `json:"action"`
+	Tag    string `json:"tag,omitempty"`
+	Query  string `json:"query,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+
+	// Reports the affected records without committing.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// The number of records changed by a rename or merge.
+type TagChangeResult struct {
+	Records int `json:"records"`
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Add("Vary", "Origin")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, Accept, token")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, Accept, token, If-Match")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS, POST, PUT")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, ETag")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -47,17 +88,94 @@ func getKeysHandler(w http.ResponseWriter, r *http.Request) {
 		tags = strings.Split(queryString.Get("tags"), ",")
 	}
 
+	if err := tagdb.ValidateTags(tags); err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options, err := readOptions(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asOf, err := readAsOf(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if rawLimit := queryString.Get("limit"); rawLimit != "" {
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 0 {
+			msg := fmt.Sprintf("invalid limit `%s`", rawLimit)
+			logger.Info(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Key ranges are returned in key order, via a scan.
+	prefix := queryString.Get("prefix")
+	start := queryString.Get("start")
+	end := queryString.Get("end")
+	isScan := prefix != "" || start != "" || end != ""
+
+	// Lists are sorted and paged.
+	if !isScan {
+		orderOptions, err := sortOptions(queryString.Get("sort"))
+		if err != nil {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		options = append(options, orderOptions...)
+		options = append(options, tagdb.WithLimit(limit), tagdb.WithCursor(queryString.Get("cursor")))
+	}
+
 	// Connect to database.
-	conn, err := tagdb.Connect()
+	conn, err := connectReader(asOf)
 	if err != nil {
+		if errors.Is(err, tagdb.ErrRecoveryPointNotFound) {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
 		err = logger.Errorf("cannot connected to database because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Get result.
-	items, err := conn.List(tags)
+	var items []tagdb.TaggedKV
+	if isScan {
+		// Scans have no tags parameter, so every tag must match alongside the caller's `q`.
+		options = append(options, tagdb.WithTags(tags...))
+		items, err = conn.Scan(prefix, start, end, limit, options...)
+	} else {
+		var page tagdb.Page
+		page, err = conn.ListPage(tags, options...)
+		items = page.Items
+
+		if page.Next != "" {
+			w.Header().Set("X-Next-Cursor", page.Next)
+		}
+	}
+
 	if err != nil {
+		var syntaxErr *tagdb.QuerySyntaxError
+		if errors.As(err, &syntaxErr) || errors.Is(err, tagdb.ErrInvalidCursor) {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		err = logger.Errorf("cannot list tags `%v` because %s", tags, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -75,6 +193,163 @@ func getKeysHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read query string.
+	queryString := r.URL.Query()
+	text := queryString.Get("q")
+	if strings.TrimSpace(text) == "" {
+		msg := "cannot complete request because search text not provided"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var tags []string
+	if rawTags := queryString.Get("tags"); rawTags != "" {
+		tags = strings.Split(rawTags, ",")
+	}
+
+	if err := tagdb.ValidateTags(tags); err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asOf, err := readAsOf(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var options []tagdb.ReadConfigurer
+	if rawLimit := queryString.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 0 {
+			msg := fmt.Sprintf("invalid limit `%s`", rawLimit)
+			logger.Info(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		options = append(options, tagdb.WithLimit(limit))
+	}
+
+	// Connect to database.
+	conn, err := connectReader(asOf)
+	if err != nil {
+		if errors.Is(err, tagdb.ErrRecoveryPointNotFound) {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Get result.
+	items, err := conn.Search(text, tags, options...)
+	if err != nil {
+		err = logger.Errorf("cannot search for `%s` because %s", text, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Serialize.
+	data, err := json.Marshal(&items)
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Streams committed changes as server-sent events, until the client disconnects.
+// Optionally filtered by `prefix` and `tag`.  When the client falls behind the stream ends with an
+// `error` event, and the client should reload its data before watching again.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := logger.Error("cannot watch because streaming is not supported")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Read query string.
+	var options []tagdb.WatchConfigurer
+	queryString := r.URL.Query()
+	if prefix := queryString.Get("prefix"); prefix != "" {
+		options = append(options, tagdb.WithKeyPrefix(prefix))
+	}
+
+	if tag := queryString.Get("tag"); tag != "" {
+		options = append(options, tagdb.WithTag(tag))
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe.
+	subscription, err := conn.Watch(options...)
+	if err != nil {
+		err = logger.Errorf("cannot watch database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case change, open := <-subscription.Changes():
+			if !open {
+				if err := subscription.Err(); err != nil {
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+					flusher.Flush()
+				}
+				return
+			}
+
+			data, err := json.Marshal(&change)
+			if err != nil {
+				logger.Errorf("cannot serialize change because %s", err)
+				return
+			}
+
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			logger.Info("watch client disconnected")
+			return
+		}
+	}
+}
+
 func setKeyHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Infof("%s %s", r.Method, r.URL.String())
 
@@ -86,6 +361,27 @@ func setKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if kv.TtlMs < 0 {
+		msg := "cannot complete request because ttlMs cannot be negative"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, hasPrecondition, err := readIfMatch(r)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if hasPrecondition && kv.TtlMs > 0 {
+		msg := "cannot complete request because ttlMs cannot be combined with If-Match"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
 	// Connect to db.
 	conn, err := tagdb.Connect()
 	if err != nil {
@@ -95,11 +391,32 @@ func setKeyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create or update.
-	if err := conn.Set(kv.Key, kv.Value); err != nil {
+	version, err := conn.UpdateWithVersion(func(tx tagdb.Tx) error {
+		switch {
+		case hasPrecondition:
+			return tx.CompareAndSet(kv.Key, kv.Value, expectedVersion)
+		case kv.TtlMs > 0:
+			return tx.SetWithTTL(kv.Key, kv.Value, time.Duration(kv.TtlMs)*time.Millisecond)
+		default:
+			return tx.Set(kv.Key, kv.Value)
+		}
+	})
+
+	if err != nil {
+		var conflict *tagdb.VersionConflictError
+		if errors.As(err, &conflict) {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+
 		err = logger.Errorf("cannot connected to database because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	// Return the committed version.  Reading the record back could return another writer's version.
+	w.Header().Set("ETag", formatETag(version))
 }
 
 func getKeyHandler(w http.ResponseWriter, r *http.Request) {
@@ -114,16 +431,37 @@ func getKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	queryString := r.URL.Query()
+	options, err := readOptions(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asOf, err := readAsOf(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Connect to db.
-	conn, err := tagdb.Connect()
+	conn, err := connectReader(asOf)
 	if err != nil {
+		if errors.Is(err, tagdb.ErrRecoveryPointNotFound) {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
 		err = logger.Errorf("cannot connected to database because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Get.
-	item, found, err := conn.Get(key)
+	item, found, err := conn.Get(key, options...)
 	if err != nil {
 		err = logger.Errorf("cannot get from database because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -145,10 +483,12 @@ func getKeyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(item.Version))
 	w.Write(data)
 }
 
-func deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+// Returns the committed changes to a record, oldest first.
+func getKeyHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Infof("%s %s", r.Method, r.URL.String())
 
 	// Read params.
@@ -168,63 +508,84 @@ func deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set.
-	if err := conn.Delete(key); err != nil {
-		err = logger.Errorf("cannot delete from database because %s", err)
+	// Get history.
+	entries, err := conn.History(key)
+	if err != nil {
+		err = logger.Errorf("cannot get history from database because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-}
 
-func postTagHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Infof("%s %s", r.Method, r.URL.String())
-
-	// Read item.
-	var tk TagKey
-	if err := json.NewDecoder(r.Body).Decode(&tk); err != nil {
-		logger.Infof("cannot read body because %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if len(entries) == 0 {
+		logger.Infof("cannot find history for key %s", key)
+		http.Error(w, "Resource not found", http.StatusNotFound)
 		return
 	}
 
-	// Connect to db.
-	conn, err := tagdb.Connect()
+	// Serialise.
+	data, err := json.Marshal(&entries)
 	if err != nil {
-		err = logger.Errorf("cannot connected to database because %s", err)
+		err = logger.Errorf("cannot serialize result because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Add tag.
-	if err := conn.Tag(tk.Key, tk.Tag); err != nil {
-		err = logger.Errorf("cannot add tag to database because %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
-func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+func deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Infof("%s %s", r.Method, r.URL.String())
 
 	// Read params.
-	var err error
 	key := r.PathValue("key")
 	if key == "" {
-		err = errors.Join(err, errors.New("key is required"))
+		msg := "cannot complete request because key not provided"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
 	}
 
-	tag := r.PathValue("tag")
-	if tag == "" {
-		err = errors.Join(err, errors.New("tag is required"))
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	expectedVersion, hasPrecondition, err := readIfMatch(r)
 	if err != nil {
 		logger.Info(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Connect to db.
+	// Delete.
+	if hasPrecondition {
+		err = conn.CompareAndDelete(key, expectedVersion)
+	} else {
+		err = conn.Delete(key)
+	}
+
+	if err != nil {
+		var conflict *tagdb.VersionConflictError
+		if errors.As(err, &conflict) {
+			logger.Info(err)
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+
+		err = logger.Errorf("cannot delete from database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Connect to database.
 	conn, err := tagdb.Connect()
 	if err != nil {
 		err = logger.Errorf("cannot connected to database because %s", err)
@@ -232,10 +593,664 @@ func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Remove tag.
-	if err := conn.Untag(key, tag); err != nil {
-		err = logger.Errorf("cannot remove tag from database because %s", err)
+	// Get result.
+	items, err := conn.List([]string{}, tagdb.OnlyDeleted())
+	if err != nil {
+		err = logger.Errorf("cannot list trash because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Serialize.
+	data, err := json.Marshal(&items)
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func restoreKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read params.
+	key := r.PathValue("key")
+	if key == "" {
+		msg := "cannot complete request because key not provided"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Restore.
+	if err := conn.Restore(key); err != nil {
+		err = logger.Errorf("cannot restore from trash because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func purgeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read params.
+	key := r.PathValue("key")
+	if key == "" {
+		msg := "cannot complete request because key not provided"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Purge.
+	if err := conn.Purge(key); err != nil {
+		err = logger.Errorf("cannot purge from trash because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func postTagHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read item.
+	var tk TagKey
+	if err := json.NewDecoder(r.Body).Decode(&tk); err != nil {
+		logger.Infof("cannot read body because %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Add tag.
+	if err := conn.Tag(tk.Key, tk.Tag); err != nil {
+		err = logger.Errorf("cannot add tag to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Lists tags with their record counts and metadata.
+// Accepts an optional `prefix`, for autocomplete, and the read options `q` and `deleted`.
+func getTagsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read query string.
+	queryString := r.URL.Query()
+	options, err := readOptions(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// List.
+	tags, err := conn.Tags(queryString.Get("prefix"), options...)
+	if err != nil {
+		err = logger.Errorf("cannot list tags because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Serialise.
+	data, err := json.Marshal(&tags)
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Sets the description and colour of a tag.  An empty body removes them.
+func putTagHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read params.
+	tag := r.PathValue("tag")
+	if tag == "" {
+		msg := "tag is required"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var metadata tagdb.TagMetadata
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		logger.Infof("cannot read body because %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Set metadata.
+	if err := conn.SetTagMetadata(tag, metadata); err != nil {
+		err = logger.Errorf("cannot set tag metadata because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Renames a tag on every record.
+func renameTagHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read params.
+	tag := r.PathValue("tag")
+	if tag == "" {
+		msg := "tag is required"
+		logger.Info(msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var rename TagRename
+	if err := json.NewDecoder(r.Body).Decode(&rename); err != nil {
+		logger.Infof("cannot read body because %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Rename.
+	count, err := conn.RenameTag(tag, rename.Tag)
+	writeTagChangeResult(w, count, err)
+}
+
+// Merges tags into a target tag on every record.
+func mergeTagsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read body.
+	var merge TagMerge
+	if err := json.NewDecoder(r.Body).Decode(&merge); err != nil {
+		logger.Infof("cannot read body because %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Merge.
+	count, err := conn.MergeTags(merge.Sources, merge.Target)
+	writeTagChangeResult(w, count, err)
+}
+
+// Writes the result of a rename or merge, mapping unknown and existing tags to 404 and 409.
+func writeTagChangeResult(w http.ResponseWriter, count int, err error) {
+	switch {
+	case errors.Is(err, tagdb.ErrTagNotFound):
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+
+	case errors.Is(err, tagdb.ErrTagExists):
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+
+	case err != nil:
+		err = logger.Errorf("cannot change tags because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Serialise.
+	data, err := json.Marshal(&TagChangeResult{Records: count})
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read params.
+	var err error
+	key := r.PathValue("key")
+	if key == "" {
+		err = errors.Join(err, errors.New("key is required"))
+	}
+
+	tag := r.PathValue("tag")
+	if tag == "" {
+		err = errors.Join(err, errors.New("tag is required"))
+	}
+
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Remove tag.
+	if err := conn.Untag(key, tag); err != nil {
+		err = logger.Errorf("cannot remove tag from database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Writes a snapshot, and compacts the wal files it covers.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Snapshot.
+	if err := conn.Snapshot(); err != nil {
+		err = logger.Errorf("cannot snapshot database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Streams records as JSON Lines or CSV.
+// Accepts `format` (jsonl or csv, default jsonl), `tags`, `q` and `deleted`.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read query string.
+	queryString := r.URL.Query()
+	format, err := readDataFormat(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var tags []string
+	if rawTags := queryString.Get("tags"); rawTags != "" {
+		tags = strings.Split(rawTags, ",")
+	}
+
+	options, err := readOptions(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Export.
+	// Headers are sent with the first write, so later errors can only end the stream early.
+	contentType := "application/x-ndjson"
+	if format == tagdb.FormatCSV {
+		contentType = "text/csv"
+	}
+
+	fileName := fmt.Sprintf("tagdb-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	if err := conn.Export(w, format, tags, options...); err != nil {
+		logger.Errorf("cannot export records because %s", err)
+	}
+}
+
+// Imports records from a JSON Lines or CSV request body.
+// Accepts `format` (jsonl or csv, default jsonl), and `dryRun=true` to validate without committing.
+// Returns the import result, including per-row errors.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read query string.
+	queryString := r.URL.Query()
+	format, err := readDataFormat(queryString)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var options []tagdb.ImportConfigurer
+	if rawDryRun := queryString.Get("dryRun"); rawDryRun != "" {
+		dryRun, err := strconv.ParseBool(rawDryRun)
+		if err != nil {
+			msg := fmt.Sprintf("invalid dryRun `%s`", rawDryRun)
+			logger.Info(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if dryRun {
+			options = append(options, tagdb.WithDryRun())
+		}
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Import.
+	result, err := conn.Import(r.Body, format, options...)
+	if err != nil {
+		err = logger.Errorf("cannot import records because %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Serialize.
+	data, err := json.Marshal(&result)
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Tags, untags or deletes every record matching a query and/or prefix, in one transaction.
+func bulkHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Read body.
+	var request BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.Infof("cannot read body because %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action, err := tagdb.ParseBulkAction(request.Action)
+	if err != nil {
+		logger.Info(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var options []tagdb.BulkConfigurer
+	if request.DryRun {
+		options = append(options, tagdb.WithBulkDryRun())
+	}
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Apply.
+	op := tagdb.BulkOperation{Action: action, Tag: request.Tag, Query: request.Query, Prefix: request.Prefix}
+	result, err := conn.Bulk(op, options...)
+	if err != nil {
+		err = logger.Errorf("cannot apply bulk operation because %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Serialize.
+	data, err := json.Marshal(&result)
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Reads the optional `format` query parameter.  Defaults to JSON Lines.
+func readDataFormat(queryString url.Values) (tagdb.DataFormat, error) {
+	rawFormat := queryString.Get("format")
+	if rawFormat == "" {
+		return tagdb.FormatJSONLines, nil
+	}
+
+	return tagdb.ParseDataFormat(rawFormat)
+}
+
+// Streams a consistent backup of the database, as a gzipped tar archive.
+// Restore it with tagdb.Restore, or `tagdb-cli admin restore`.
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Backup.
+	// Headers are sent with the first write, so later errors can only end the stream early.
+	fileName := fmt.Sprintf("tagdb-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	if err := conn.Backup(w); err != nil {
+		logger.Errorf("cannot backup database because %s", err)
+	}
+}
+
+// Returns storage statistics, including the disk space saved by wal compression.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infof("%s %s", r.Method, r.URL.String())
+
+	// Connect to db.
+	conn, err := tagdb.Connect()
+	if err != nil {
+		err = logger.Errorf("cannot connected to database because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Stats.
+	stats, err := conn.Stats()
+	if err != nil {
+		err = logger.Errorf("cannot get stats because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Serialise.
+	data, err := json.Marshal(&stats)
+	if err != nil {
+		err = logger.Errorf("cannot serialize result because %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Reads optional read options from the query string.
+//
+//	| Parameter | Values                                    |
+//	| --------- | ----------------------------------------- |
+//	| deleted   | exclude (default), include, only          |
+//	| q         | Tag query, e.g. `work AND NOT archived`.  |
+func readOptions(queryString url.Values) ([]tagdb.ReadConfigurer, error) {
+	var options []tagdb.ReadConfigurer
+
+	if query := queryString.Get("q"); query != "" {
+		options = append(options, tagdb.WithQuery(query))
+	}
+
+	switch deleted := queryString.Get("deleted"); deleted {
+	case "", "exclude":
+		// Default.
+	case "include":
+		options = append(options, tagdb.WithDeleted())
+	case "only":
+		options = append(options, tagdb.OnlyDeleted())
+	default:
+		return nil, fmt.Errorf("unsupported deleted value `%s`, expected exclude, include or only", deleted)
+	}
+
+	return options, nil
+}
+
+// Reads records, from the live database or from a point in its history.
+type recordReader interface {
+	ListPage(tags []string, options ...tagdb.ReadConfigurer) (tagdb.Page, error)
+	Scan(prefix, start, end string, limit int, options ...tagdb.ReadConfigurer) ([]tagdb.TaggedKV, error)
+	Search(text string, tags []string, options ...tagdb.ReadConfigurer) ([]tagdb.TaggedKV, error)
+	Get(key string, options ...tagdb.ReadConfigurer) (tagdb.TaggedKV, bool, error)
+}
+
+// Reads the optional `asOf` recovery point, a transaction id or RFC3339 timestamp.
+// Returns nil when reading the live database.
+func readAsOf(queryString url.Values) (*tagdb.RecoveryPoint, error) {
+	rawAsOf := queryString.Get("asOf")
+	if rawAsOf == "" {
+		return nil, nil
+	}
+
+	point, err := tagdb.ParseRecoveryPoint(rawAsOf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &point, nil
+}
+
+// Connects to the live database, or opens a read-only copy as it was at the recovery point.
+func connectReader(asOf *tagdb.RecoveryPoint) (recordReader, error) {
+	conn, err := tagdb.Connect()
+	if err != nil || asOf == nil {
+		return conn, err
+	}
+
+	return conn.OpenAt(*asOf)
+}
+
+// Formats a record version as a strong entity tag, such as `"3"`.
+func formatETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// Reads the optional If-Match header, which holds the expected record version.
+// `*` matches any version, so is treated as no precondition.
+func readIfMatch(r *http.Request) (version uint64, found bool, err error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, false, nil
+	}
+
+	unquoted, err := strconv.Unquote(value)
+	if err == nil {
+		version, err = strconv.ParseUint(unquoted, 10, 64)
+	}
+
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match `%s`, expected a quoted version such as \"3\"", value)
+	}
+
+	return version, true, nil
+}
+
+// Reads the optional sort order.
+// One of key (default), created or updated.  Prefix with `-` to sort in descending order.
+func sortOptions(sort string) ([]tagdb.ReadConfigurer, error) {
+	var options []tagdb.ReadConfigurer
+
+	if descending, found := strings.CutPrefix(sort, "-"); found {
+		options = append(options, tagdb.WithDescending())
+		sort = descending
+	}
+
+	switch sort {
+	case "", "key":
+		options = append(options, tagdb.WithOrder(tagdb.OrderByKey))
+	case "created":
+		options = append(options, tagdb.WithOrder(tagdb.OrderByCreated))
+	case "updated":
+		options = append(options, tagdb.WithOrder(tagdb.OrderByUpdated))
+	default:
+		return nil, fmt.Errorf("unsupported sort `%s`, expected key, created or updated", sort)
+	}
+
+	return options, nil
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/tagdb"
 )
@@ -25,13 +31,543 @@ func Test_getKeysHandler(t *testing.T) {
 	}
 }
 
+func Test_getKeysHandler_ReturnsBadRequest_OnInvalidQuery(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("GET", "/api/keys?q=work+AND", nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeysHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func Test_getKeysHandler_ScansByPrefix(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	for _, key := range []string{"proj/beta/notes", "proj/alpha/todo", "other", "proj/alpha/notes"} {
+		if err := conn.Set(key, "value"); err != nil {
+			t.Fatalf("unexpected error setting key: %v", err)
+		}
+	}
+
+	request := httptest.NewRequest("GET", "/api/keys?prefix=proj/alpha/", nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeysHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var items []tagdb.TaggedKV
+	if err := json.Unmarshal(response.Body.Bytes(), &items); err != nil {
+		t.Fatalf("cannot read response body: %v", err)
+	}
+
+	if len(items) != 2 || items[0].Key != "proj/alpha/notes" || items[1].Key != "proj/alpha/todo" {
+		t.Errorf("handler returned unexpected items: %+v", items)
+	}
+}
+
+func Test_getKeysHandler_ScansByPrefix_CombinesTagsAndQuery(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	records := map[string][]string{
+		"proj/a": {"work", "urgent"},
+		"proj/b": {"work"},
+		"proj/c": {"work", "later"},
+		"proj/d": {"urgent"},
+	}
+	for key, tags := range records {
+		if err := conn.Set(key, "value"); err != nil {
+			t.Fatalf("unexpected error setting key: %v", err)
+		}
+
+		for _, tag := range tags {
+			if err := conn.Tag(key, tag); err != nil {
+				t.Fatalf("unexpected error tagging key: %v", err)
+			}
+		}
+	}
+
+	request := httptest.NewRequest("GET", "/api/keys?prefix=proj/&tags=work&q="+url.QueryEscape("urgent OR later"), nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeysHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var items []tagdb.TaggedKV
+	if err := json.Unmarshal(response.Body.Bytes(), &items); err != nil {
+		t.Fatalf("cannot read response body: %v", err)
+	}
+
+	if len(items) != 2 || items[0].Key != "proj/a" || items[1].Key != "proj/c" {
+		t.Errorf("handler returned unexpected items: %+v", items)
+	}
+}
+
+func Test_getKeysHandler_ReturnsBadRequest_OnInvalidScanTag(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("GET", "/api/keys?prefix=proj/&tags="+url.QueryEscape("work OR later"), nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeysHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func Test_getKeysHandler_PagesWithCursor(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	for _, key := range []string{"key-c", "key-a", "key-b"} {
+		if err := conn.Set(key, "value"); err != nil {
+			t.Fatalf("unexpected error setting key: %v", err)
+		}
+	}
+	handler := http.HandlerFunc(getKeysHandler)
+
+	// Act
+	var keys []string
+	url := "/api/keys?limit=2"
+	for range 3 {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+		if status := response.Code; status != http.StatusOK {
+			t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var items []tagdb.TaggedKV
+		if err := json.Unmarshal(response.Body.Bytes(), &items); err != nil {
+			t.Fatalf("cannot read response body: %v", err)
+		}
+
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+
+		cursor := response.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+		url = "/api/keys?limit=2&cursor=" + cursor
+	}
+
+	// Assert
+	if !slices.Equal(keys, []string{"key-a", "key-b", "key-c"}) {
+		t.Errorf("handler returned unexpected keys: %v", keys)
+	}
+}
+
+func Test_setKeyHandler_SetsTTL(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	body := strings.NewReader(`{"key":"session","value":"link","ttlMs":60000}`)
+	request := httptest.NewRequest("POST", "/api/keys", body)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(setKeyHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	conn, _ := tagdb.Connect()
+	taggedKV, found, _ := conn.Get("session")
+	if !found || taggedKV.Expires.IsZero() {
+		t.Errorf("expected record with expiry: %+v", taggedKV)
+	}
+}
+
+func Test_setKeyHandler_ReturnsPreconditionFailed_OnStaleIfMatch(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	handler := http.HandlerFunc(setKeyHandler)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"key":"note","value":"v1"}`)))
+	etag := response.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag after set")
+	}
+
+	response = httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"key":"note","value":"v2"}`))
+	request.Header.Set("If-Match", etag)
+	handler.ServeHTTP(response, request)
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Act
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"key":"note","value":"v3"}`))
+	request.Header.Set("If-Match", etag)
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusPreconditionFailed)
+	}
+}
+
+func Test_getKeyHandler_ReadsAsOfTimestamp(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	if err := conn.Set("note", "v1"); err != nil {
+		t.Fatalf("unexpected error setting key: %v", err)
+	}
+
+	asOf := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(time.Millisecond)
+	if err := conn.Set("note", "v2"); err != nil {
+		t.Fatalf("unexpected error setting key: %v", err)
+	}
+
+	request := httptest.NewRequest("GET", "/api/keys/note?asOf="+url.QueryEscape(asOf), nil)
+	request.SetPathValue("key", "note")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeyHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var item tagdb.TaggedKV
+	if err := json.Unmarshal(response.Body.Bytes(), &item); err != nil || item.Value != "v1" {
+		t.Errorf("expected the value as of %s, found %+v", asOf, item)
+	}
+}
+
+func Test_getKeyHandler_ReturnsBadRequest_OnInvalidAsOf(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("GET", "/api/keys/note?asOf=yesterday", nil)
+	request.SetPathValue("key", "note")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeyHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func Test_getKeyHistoryHandler_ReturnsChanges(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note", "v1")
+	conn.Set("note", "v2")
+
+	request := httptest.NewRequest("GET", "/api/keys/note/history", nil)
+	request.SetPathValue("key", "note")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeyHistoryHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var entries []tagdb.HistoryEntry
+	if err := json.Unmarshal(response.Body.Bytes(), &entries); err != nil || len(entries) != 2 || entries[1].Value != "v2" {
+		t.Errorf("expected 2 set entries, found %+v", entries)
+	}
+}
+
+func Test_getKeyHistoryHandler_ReturnsNotFound_OnUnknownKey(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("GET", "/api/keys/missing/history", nil)
+	request.SetPathValue("key", "missing")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getKeyHistoryHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func Test_searchHandler_ReturnsBadRequest_OnInvalidTag(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("GET", "/api/search?q=note&tags=work,Not-A-Tag", nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(searchHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func Test_backupHandler_StreamsRestorableArchive(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note", "v1")
+
+	request := httptest.NewRequest("GET", "/api/admin/backup", nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(backupHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if err := tagdb.Restore(response.Body, t.TempDir()); err != nil {
+		t.Errorf("expected backup to restore, but got %v", err)
+	}
+}
+
+func Test_statsHandler_ReturnsRecordCount(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note", "v1")
+
+	request := httptest.NewRequest("GET", "/api/admin/stats", nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(statsHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var stats tagdb.Stats
+	if err := json.Unmarshal(response.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("cannot read stats because %v", err)
+	}
+
+	if stats.Records != 1 || stats.WalFiles == 0 {
+		t.Errorf("expected 1 record and a wal file, but got %+v", stats)
+	}
+}
+
+func Test_getTagsHandler_ReturnsCountsAndMetadata(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note-1", "v1")
+	conn.Tag("note-1", "work")
+	conn.Set("note-2", "v2")
+	conn.Tag("note-2", "home")
+
+	body := strings.NewReader(`{"description":"Work items","colour":"#1e90ff"}`)
+	putRequest := httptest.NewRequest("PUT", "/api/tags/work", body)
+	putRequest.SetPathValue("tag", "work")
+	http.HandlerFunc(putTagHandler).ServeHTTP(httptest.NewRecorder(), putRequest)
+
+	request := httptest.NewRequest("GET", "/api/tags?prefix=wo", nil)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(getTagsHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var tags []tagdb.TagInfo
+	if err := json.Unmarshal(response.Body.Bytes(), &tags); err != nil {
+		t.Fatalf("cannot read tags because %v", err)
+	}
+
+	expected := tagdb.TagInfo{Tag: "work", Count: 1, TagMetadata: tagdb.TagMetadata{Description: "Work items", Colour: "#1e90ff"}}
+	if len(tags) != 1 || tags[0] != expected {
+		t.Errorf("expected %+v, but got %+v", expected, tags)
+	}
+}
+
+func Test_putTagHandler_ReturnsBadRequest_OnInvalidBody(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("PUT", "/api/tags/work", strings.NewReader("not json"))
+	request.SetPathValue("tag", "work")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(putTagHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func Test_renameTagHandler_RenamesTag(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note", "v1")
+	conn.Tag("note", "wrok")
+
+	request := httptest.NewRequest("POST", "/api/tags/wrok/rename", strings.NewReader(`{"tag":"work"}`))
+	request.SetPathValue("tag", "wrok")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(renameTagHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var result TagChangeResult
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil || result.Records != 1 {
+		t.Errorf("expected 1 record to change, but got %+v and %v", result, err)
+	}
+
+	if taggedKV, _, _ := conn.Get("note"); !slices.Equal(taggedKV.Tags, []string{"work"}) {
+		t.Errorf("expected record to be tagged `work`, but got %v", taggedKV.Tags)
+	}
+}
+
+func Test_renameTagHandler_ReturnsConflict_WhenTagExists(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note", "v1")
+	conn.Tag("note", "wrok")
+	conn.Tag("note", "work")
+
+	request := httptest.NewRequest("POST", "/api/tags/wrok/rename", strings.NewReader(`{"tag":"work"}`))
+	request.SetPathValue("tag", "wrok")
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(renameTagHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusConflict {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func Test_mergeTagsHandler_ReturnsNotFound_OnUnknownSource(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	body := strings.NewReader(`{"sources":["missing"],"target":"work"}`)
+	request := httptest.NewRequest("POST", "/api/tags/merge", body)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(mergeTagsHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func Test_watchHandler_StreamsChanges(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	server := httptest.NewServer(http.HandlerFunc(watchHandler))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/api/watch?prefix=watched/")
+	if err != nil {
+		t.Fatalf("cannot watch: %v", err)
+	}
+	defer response.Body.Close()
+
+	// Act
+	conn, _ := tagdb.Connect()
+	conn.Set("ignored", "value")
+	conn.Set("watched/key", "value")
+
+	// Assert
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data: ")
+		if !found {
+			continue
+		}
+
+		var change tagdb.Change
+		if err := json.Unmarshal([]byte(data), &change); err != nil {
+			t.Fatalf("cannot read change: %v", err)
+		}
+
+		if change.Key != "watched/key" || change.Op != "SET" {
+			t.Errorf("handler streamed unexpected change: %+v", change)
+		}
+		return
+	}
+
+	t.Fatalf("stream ended without a change: %v", scanner.Err())
+}
+
 func configTestEnvironment(t *testing.T) {
 	testDir := t.TempDir()
 
 	t.Setenv("TAGDB_PORT", "11981")
+	t.Setenv("TAGDB_WEB_ROOT", testDir)
 	t.Setenv("TAGDB_STORAGE_ROOT", testDir)
 	t.Setenv("TAGDB_STORAGE_WAL_ROLL_AFTER_BYTES", "1024")
 	t.Setenv("TAGDB_STORAGE_BACKGROUND_TASK_INTERVAL_MS", "0")
+	t.Setenv("TAGDB_STORAGE_IN_MEMORY", "true")
 
 	config := getConfig()
 
@@ -43,3 +579,128 @@ func configTestEnvironment(t *testing.T) {
 		tagdb.Stop()
 	})
 }
+
+func Test_importHandler_ImportsExport(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note", "v1")
+	conn.Tag("note", "work")
+
+	exportRequest := httptest.NewRequest("GET", "/api/export?format=csv&tags=work", nil)
+	exportResponse := httptest.NewRecorder()
+	http.HandlerFunc(exportHandler).ServeHTTP(exportResponse, exportRequest)
+	conn.Set("note", "v2")
+
+	request := httptest.NewRequest("POST", "/api/import?format=csv", exportResponse.Body)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(importHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var result tagdb.ImportResult
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil || result.Imported != 1 {
+		t.Errorf("expected 1 row imported, but got %+v (%v)", result, err)
+	}
+
+	if item, _, _ := conn.Get("note"); item.Value != "v1" {
+		t.Errorf("expected the exported value to be restored, but got %+v", item)
+	}
+}
+
+func Test_importHandler_ReturnsBadRequest_OnInvalidFormat(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	request := httptest.NewRequest("POST", "/api/import?format=xml", strings.NewReader("<records/>"))
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(importHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func Test_bulkHandler_TagsRecordsMatchingQuery(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note-1", "v1")
+	conn.Tag("note-1", "2025")
+	conn.Tag("note-1", "done")
+	conn.Set("note-2", "v2")
+	conn.Tag("note-2", "2025")
+
+	body := strings.NewReader(`{"action":"tag","tag":"archived","query":"2025 AND done"}`)
+	request := httptest.NewRequest("POST", "/api/bulk", body)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(bulkHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusOK {
+		t.Fatalf("handler returned unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var result tagdb.BulkResult
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil || result.Affected != 1 {
+		t.Errorf("expected 1 affected record, but got %+v and %v", result, err)
+	}
+
+	if taggedKV, _, _ := conn.Get("note-1"); !slices.Contains(taggedKV.Tags, "archived") {
+		t.Errorf("expected note-1 to be tagged `archived`, but got %v", taggedKV.Tags)
+	}
+}
+
+func Test_bulkHandler_ShouldNotCommit_OnDryRun(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	conn, _ := tagdb.Connect()
+	conn.Set("note-1", "v1")
+
+	body := strings.NewReader(`{"action":"delete","prefix":"note-","dryRun":true}`)
+	request := httptest.NewRequest("POST", "/api/bulk", body)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(bulkHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	var result tagdb.BulkResult
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil || !result.DryRun || result.Affected != 1 {
+		t.Errorf("expected a dry run with 1 affected record, but got %+v and %v", result, err)
+	}
+
+	if _, found, _ := conn.Get("note-1"); !found {
+		t.Errorf("expected note-1 to not be deleted")
+	}
+}
+
+func Test_bulkHandler_ReturnsBadRequest_OnInvalidAction(t *testing.T) {
+	// Arrange
+	configTestEnvironment(t)
+	body := strings.NewReader(`{"action":"explode","query":"done"}`)
+	request := httptest.NewRequest("POST", "/api/bulk", body)
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(bulkHandler)
+
+	// Act
+	handler.ServeHTTP(response, request)
+
+	// Assert
+	if status := response.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned unexpected status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
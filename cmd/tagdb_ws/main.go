@@ -20,6 +20,16 @@ type config struct {
 	storageRoot                     string
 	storageWalRollAfterBytes        int64
 	storageBackgroundTaskIntervalMs int
+	storagePurgeDeletedAfterMs      int64
+	storageSnapshotIntervalMs       int64
+	storageWalArchive               bool
+	storageWalCompression           bool
+	storageDurability               tagdb.Durability
+	storageSyncIntervalMs           int64
+	storageHistoryRetentionMs       int64
+	storageInMemory                 bool
+	storageEncryptionKeyId          string
+	storageEncryptionKeys           []tagdb.EncryptionKey
 }
 
 func main() {
@@ -59,7 +69,16 @@ func startDatabase(config config, ctx context.Context) {
 		config.storageRoot,
 		ctx,
 		tagdb.WithRollAfterBytes(config.storageWalRollAfterBytes),
-		tagdb.WithBackgroundTaskIntervalMs(config.storageBackgroundTaskIntervalMs))
+		tagdb.WithBackgroundTaskIntervalMs(config.storageBackgroundTaskIntervalMs),
+		tagdb.WithPurgeDeletedAfterMs(config.storagePurgeDeletedAfterMs),
+		tagdb.WithSnapshotIntervalMs(config.storageSnapshotIntervalMs),
+		tagdb.WithWalArchive(config.storageWalArchive),
+		tagdb.WithWalCompression(config.storageWalCompression),
+		tagdb.WithDurability(config.storageDurability),
+		tagdb.WithSyncIntervalMs(config.storageSyncIntervalMs),
+		tagdb.WithHistoryRetentionMs(config.storageHistoryRetentionMs),
+		tagdb.WithInMemoryStorage(config.storageInMemory),
+		tagdb.WithEncryptionKeys(config.storageEncryptionKeyId, config.storageEncryptionKeys...))
 }
 
 // Adds handlers for API endpoints.
@@ -70,8 +89,24 @@ func addApiEndpoints() {
 	http.HandleFunc("POST /api/keys", setKeyHandler)
 	http.HandleFunc("GET /api/keys/{key}", getKeyHandler)
 	http.HandleFunc("DELETE /api/keys/{key}", deleteKeyHandler)
+	http.HandleFunc("GET /api/keys/{key}/history", getKeyHistoryHandler)
+	http.HandleFunc("GET /api/search", searchHandler)
+	http.HandleFunc("GET /api/watch", watchHandler)
+	http.HandleFunc("GET /api/trash", getTrashHandler)
+	http.HandleFunc("POST /api/trash/{key}/restore", restoreKeyHandler)
+	http.HandleFunc("DELETE /api/trash/{key}", purgeKeyHandler)
+	http.HandleFunc("GET /api/tags", getTagsHandler)
 	http.HandleFunc("POST /api/tags", postTagHandler)
+	http.HandleFunc("PUT /api/tags/{tag}", putTagHandler)
+	http.HandleFunc("POST /api/tags/{tag}/rename", renameTagHandler)
+	http.HandleFunc("POST /api/tags/merge", mergeTagsHandler)
 	http.HandleFunc("DELETE /api/tags/{tag}/{key}", deleteTagHandler)
+	http.HandleFunc("GET /api/export", exportHandler)
+	http.HandleFunc("POST /api/import", importHandler)
+	http.HandleFunc("POST /api/bulk", bulkHandler)
+	http.HandleFunc("POST /api/admin/snapshot", snapshotHandler)
+	http.HandleFunc("GET /api/admin/backup", backupHandler)
+	http.HandleFunc("GET /api/admin/stats", statsHandler)
 }
 
 // Adds a handler for static site content.
@@ -139,19 +174,121 @@ func getConfig() config {
 		logger.Panicf("invalid TAGDB_STORAGE_BACKGROUND_TASK_INTERVAL_MS value `%s`", backgroundTaskIntervalMsStr)
 	}
 
+	// Purge deleted after ms.
+	// Optional, defaults to 30 days.
+	purgeDeletedAfterMs := int64(30 * 24 * 60 * 60 * 1_000)
+	if purgeDeletedAfterMsStr := os.Getenv("TAGDB_STORAGE_PURGE_DELETED_AFTER_MS"); purgeDeletedAfterMsStr != "" {
+		purgeDeletedAfterMs, err = strconv.ParseInt(purgeDeletedAfterMsStr, 10, 64)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_PURGE_DELETED_AFTER_MS value `%s`", purgeDeletedAfterMsStr)
+		}
+	}
+
+	// Snapshot interval ms.
+	// Optional, defaults to 1 hour.
+	snapshotIntervalMs := int64(60 * 60 * 1_000)
+	if snapshotIntervalMsStr := os.Getenv("TAGDB_STORAGE_SNAPSHOT_INTERVAL_MS"); snapshotIntervalMsStr != "" {
+		snapshotIntervalMs, err = strconv.ParseInt(snapshotIntervalMsStr, 10, 64)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_SNAPSHOT_INTERVAL_MS value `%s`", snapshotIntervalMsStr)
+		}
+	}
+
+	// Archive wal files covered by a snapshot, so asOf reads can reach points before it.
+	// Optional, defaults to true.
+	walArchive := true
+	if walArchiveStr := os.Getenv("TAGDB_STORAGE_WAL_ARCHIVE"); walArchiveStr != "" {
+		walArchive, err = strconv.ParseBool(walArchiveStr)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_WAL_ARCHIVE value `%s`", walArchiveStr)
+		}
+	}
+
+	// Compress wal files once the wal rolls past them.
+	// Optional, defaults to true.
+	walCompression := true
+	if walCompressionStr := os.Getenv("TAGDB_STORAGE_WAL_COMPRESSION"); walCompressionStr != "" {
+		walCompression, err = strconv.ParseBool(walCompressionStr)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_WAL_COMPRESSION value `%s`", walCompressionStr)
+		}
+	}
+
+	// When commits are synced to disk.
+	// Optional, defaults to always.
+	durability := tagdb.DurabilityAlways
+	if durabilityStr := os.Getenv("TAGDB_STORAGE_DURABILITY"); durabilityStr != "" {
+		durability, err = tagdb.ParseDurability(durabilityStr)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_DURABILITY value `%s`", durabilityStr)
+		}
+	}
+
+	// Sync interval ms, used by the interval durability mode.
+	// Optional, defaults to 1 second.
+	syncIntervalMs := int64(1_000)
+	if syncIntervalMsStr := os.Getenv("TAGDB_STORAGE_SYNC_INTERVAL_MS"); syncIntervalMsStr != "" {
+		syncIntervalMs, err = strconv.ParseInt(syncIntervalMsStr, 10, 64)
+		if err != nil || syncIntervalMs <= 0 {
+			logger.Panicf("invalid TAGDB_STORAGE_SYNC_INTERVAL_MS value `%s`", syncIntervalMsStr)
+		}
+	}
+
+	// History retention ms.
+	// Optional, defaults to 30 days.  Zero keeps the full history.
+	historyRetentionMs := int64(30 * 24 * 60 * 60 * 1_000)
+	if historyRetentionMsStr := os.Getenv("TAGDB_STORAGE_HISTORY_RETENTION_MS"); historyRetentionMsStr != "" {
+		historyRetentionMs, err = strconv.ParseInt(historyRetentionMsStr, 10, 64)
+		if err != nil || historyRetentionMs < 0 {
+			logger.Panicf("invalid TAGDB_STORAGE_HISTORY_RETENTION_MS value `%s`", historyRetentionMsStr)
+		}
+	}
+
+	// Keep storage in memory, so nothing is persisted.
+	// Optional, defaults to false.
+	var inMemory bool
+	if inMemoryStr := os.Getenv("TAGDB_STORAGE_IN_MEMORY"); inMemoryStr != "" {
+		inMemory, err = strconv.ParseBool(inMemoryStr)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_IN_MEMORY value `%s`", inMemoryStr)
+		}
+	}
+
+	// Encryption keys, as id:base64 pairs, either inline or in a file.
+	// Optional, defaults to no encryption.
+	var encryptionKeys []tagdb.EncryptionKey
+	if encryptionKeysStr := os.Getenv("TAGDB_STORAGE_ENCRYPTION_KEYS"); encryptionKeysStr != "" {
+		encryptionKeys, err = tagdb.ParseEncryptionKeys(encryptionKeysStr)
+		if err != nil {
+			logger.Panicf("invalid TAGDB_STORAGE_ENCRYPTION_KEYS value because %s", err)
+		}
+	} else if encryptionKeysFile := os.Getenv("TAGDB_STORAGE_ENCRYPTION_KEYS_FILE"); encryptionKeysFile != "" {
+		encryptionKeys, err = tagdb.ReadEncryptionKeysFile(encryptionKeysFile)
+		if err != nil {
+			logger.Panicf("cannot read TAGDB_STORAGE_ENCRYPTION_KEYS_FILE `%s` because %s", encryptionKeysFile, err)
+		}
+	}
+
+	// Id of the key used to encrypt new files.
+	// Optional, defaults to the first key.
+	encryptionKeyId := os.Getenv("TAGDB_STORAGE_ENCRYPTION_KEY_ID")
+
 	// Get storage root.
 	storageRoot := os.Getenv("TAGDB_STORAGE_ROOT")
 	if storageRoot == "" {
 		logger.Panicf("cannot start tagDb because TAGDB_STORAGE_ROOT is required")
 	}
 
-	info, err := os.Stat(storageRoot)
-	if err != nil {
-		logger.Panicf("cannot validate TAGDB_STORAGE_ROOT `%s` because %s", storageRoot, err)
-	}
+	// In-memory storage roots do not exist on disk.
+	if !inMemory {
+		info, err := os.Stat(storageRoot)
+		if err != nil {
+			logger.Panicf("cannot validate TAGDB_STORAGE_ROOT `%s` because %s", storageRoot, err)
+		}
 
-	if !info.IsDir() {
-		logger.Panicf("cannot start tagDb because TAGDB_STORAGE_ROOT `%s` is not a directory", storageRoot)
+		if !info.IsDir() {
+			logger.Panicf("cannot start tagDb because TAGDB_STORAGE_ROOT `%s` is not a directory", storageRoot)
+		}
 	}
 
 	// Success.
@@ -161,5 +298,15 @@ func getConfig() config {
 		storageRoot:                     storageRoot,
 		storageWalRollAfterBytes:        walRollAfterBytes,
 		storageBackgroundTaskIntervalMs: int(backgroundTaskIntervalMs),
+		storagePurgeDeletedAfterMs:      purgeDeletedAfterMs,
+		storageSnapshotIntervalMs:       snapshotIntervalMs,
+		storageWalArchive:               walArchive,
+		storageWalCompression:           walCompression,
+		storageDurability:               durability,
+		storageSyncIntervalMs:           syncIntervalMs,
+		storageHistoryRetentionMs:       historyRetentionMs,
+		storageInMemory:                 inMemory,
+		storageEncryptionKeyId:          encryptionKeyId,
+		storageEncryptionKeys:           encryptionKeys,
 	}
 }
@@ -5,6 +5,8 @@ Bimap allows you to look up values by keys, and keys by values.
 */
 package bimap
 
+import "iter"
+
 // A bidirectional map.
 type BiMap[T comparable] struct {
 	keys   map[T]map[T]bool
@@ -73,3 +75,14 @@ func (bm *BiMap[T]) GetKeys(value T) []T {
 	}
 	return result
 }
+
+// Iterates over all values, in no particular order.
+func (bm *BiMap[T]) Values() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for value := range bm.values {
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
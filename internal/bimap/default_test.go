@@ -52,3 +52,19 @@ func Test_BiMap_ShouldNotReturnDeletedKeysValuePairs(t *testing.T) {
 		t.Errorf("expected %v, got %v", expectedValues, values)
 	}
 }
+
+func Test_BiMap_ShouldIterateValues(t *testing.T) {
+	bm := &bimap.BiMap[string]{}
+	bm.Add("key1", "value1")
+	bm.Add("key2", "value1")
+	bm.Add("key2", "value2")
+	bm.Remove("key1", "value1")
+	bm.Remove("key2", "value2")
+
+	values := slices.Sorted(bm.Values())
+	expectedValues := []string{"value1"}
+
+	if slices.Compare(values, expectedValues) != 0 {
+		t.Errorf("expected %v, got %v", expectedValues, values)
+	}
+}
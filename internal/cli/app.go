@@ -47,16 +47,17 @@ func (a *app) Run(args []string) {
 		return
 	}
 
-	// Parse args.
-	commandArgs := argsQueue.toSlice()
-	err := unmarshalArgs(commandArgs, candidateCommand)
-	if err != nil {
-		fmt.Printf("cannot read args because %s\n", err)
+	// Parse args into the handler.  Branch handlers do not accept args.
+	if _, isFunc := candidateCommand.handler.(invokeFunc); !isFunc {
+		commandArgs := argsQueue.toSlice()
+		if err := unmarshalArgs(commandArgs, candidateCommand.handler); err != nil {
+			fmt.Printf("cannot read args because %s\n", err)
+			a.exit(1)
+			return
+		}
 	}
 
 	// Execute command.
-	fmt.Printf("executing `%s` with args `%s`\n", candidateCommand.name, argsQueue)
-	fmt.Printf("`%s`\n\n", candidateCommand.description)
 	result := candidateCommand.handler.Invoke()
 	a.exit(result)
 }
@@ -84,6 +84,76 @@ func Test_app_Run_Exits1_WhenInvalidNumberOfArgsProvided(t *testing.T) {
 	}
 }
 
+func Test_app_Run_ParsesArgsIntoHandler(t *testing.T) {
+	// Arrange
+	builder := Builder{}
+	branch, err := builder.AddBranch("test", "test commands")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handler := &invokeWithArgs{}
+	_, err = branch.AddCommand("foo", "foo command", handler)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	app := builder.Build()
+	actual := -1
+	app.exit = func(code int) {
+		actual = code
+	}
+
+	// Act
+	app.Run([]string{"my_app", "test", "foo", "bob", "--age", "3"})
+
+	// Assert
+	if actual != 0 {
+		t.Fatalf("expected exit code 0, got %d", actual)
+	}
+
+	if handler.Name != "bob" || handler.Age != 3 {
+		t.Fatalf("expected args to be parsed into handler, got %+v", handler)
+	}
+}
+
+func Test_app_Run_Exits1_WhenArgsInvalid(t *testing.T) {
+	// Arrange
+	builder := Builder{}
+	branch, err := builder.AddBranch("test", "test commands")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = branch.AddCommand("foo", "foo command", &invokeWithArgs{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	app := builder.Build()
+	actual := 0
+	app.exit = func(code int) {
+		actual = code
+	}
+
+	// Act
+	app.Run([]string{"my_app", "test", "foo", "bob", "--age", "old"})
+
+	// Assert
+	if actual != 1 {
+		t.Fatalf("expected exit code 1, got %d", actual)
+	}
+}
+
+type invokeWithArgs struct {
+	Name string `arg:"0:<name>" help:"Name."`
+	Age  int    `option:"--age" help:"Age."`
+}
+
+func (ie *invokeWithArgs) Invoke() int {
+	return 0
+}
+
 type invokeExit42 struct{}
 
 func (ie *invokeExit42) Invoke() int {
@@ -0,0 +1,151 @@
+/*
+A sorted set data structure.
+
+Items are unique and kept in ascending order, allowing ordered and range iteration.
+Backed by a skip list, so lookups, inserts and removals are O(log n) on average.  Building a set
+of n items, such as when replaying a WAL, is O(n log n).
+*/
+package sortedset
+
+import (
+	"cmp"
+	"iter"
+	"math/rand/v2"
+)
+
+const (
+	// Supports around 2^32 items before lookups degrade.
+	maxLevel = 32
+
+	// The chance a node is promoted to the next level.
+	promoteChance = 0.25
+)
+
+// A set of ordered items.  The zero value is an empty set, ready to use.
+type SortedSet[T cmp.Ordered] struct {
+	head   node[T]
+	level  int
+	length int
+}
+
+type node[T cmp.Ordered] struct {
+	item T
+	next []*node[T]
+}
+
+// Adds an item to the set.  Returns false if the item already exists.
+func (s *SortedSet[T]) Add(item T) bool {
+	var update [maxLevel]*node[T]
+	current := s.seek(item, &update)
+
+	if next := current.nextAt(0); next != nil && next.item == item {
+		return false
+	}
+
+	level := randomLevel()
+	for l := s.level; l < level; l++ {
+		update[l] = &s.head
+	}
+	s.level = max(s.level, level)
+
+	if len(s.head.next) < s.level {
+		s.head.next = append(s.head.next, make([]*node[T], s.level-len(s.head.next))...)
+	}
+
+	added := &node[T]{item: item, next: make([]*node[T], level)}
+	for l := range level {
+		added.next[l] = update[l].next[l]
+		update[l].next[l] = added
+	}
+
+	s.length++
+	return true
+}
+
+// Removes an item from the set.  Returns false if the item does not exist.
+func (s *SortedSet[T]) Remove(item T) bool {
+	var update [maxLevel]*node[T]
+	current := s.seek(item, &update)
+
+	removed := current.nextAt(0)
+	if removed == nil || removed.item != item {
+		return false
+	}
+
+	// Removed nodes keep their next pointers, so in-flight iterators can continue past them.
+	for l := range len(removed.next) {
+		update[l].next[l] = removed.next[l]
+	}
+
+	for s.level > 0 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+
+	s.length--
+	return true
+}
+
+// Tests if an item exists in the set.
+func (s *SortedSet[T]) Contains(item T) bool {
+	next := s.seek(item, nil).nextAt(0)
+	return next != nil && next.item == item
+}
+
+// Returns the number of items in the set.
+func (s *SortedSet[T]) Len() int {
+	return s.length
+}
+
+// Iterates over all items in ascending order.
+func (s *SortedSet[T]) All() iter.Seq[T] {
+	return s.from(s.head.nextAt(0))
+}
+
+// Iterates over items greater than or equal to from, in ascending order.
+func (s *SortedSet[T]) Ascend(from T) iter.Seq[T] {
+	return s.from(s.seek(from, nil).nextAt(0))
+}
+
+func (s *SortedSet[T]) from(start *node[T]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for current := start; current != nil; current = current.nextAt(0) {
+			if !yield(current.item) {
+				return
+			}
+		}
+	}
+}
+
+// Returns the last node before item.  When update is not nil, it is populated with the last node
+// before item on every level.
+func (s *SortedSet[T]) seek(item T, update *[maxLevel]*node[T]) *node[T] {
+	current := &s.head
+	for l := s.level - 1; l >= 0; l-- {
+		for next := current.next[l]; next != nil && next.item < item; next = current.next[l] {
+			current = next
+		}
+
+		if update != nil {
+			update[l] = current
+		}
+	}
+
+	return current
+}
+
+func (n *node[T]) nextAt(level int) *node[T] {
+	if level >= len(n.next) {
+		return nil
+	}
+
+	return n.next[level]
+}
+
+func randomLevel() int {
+	level := 1
+	for level < maxLevel && rand.Float64() < promoteChance {
+		level++
+	}
+
+	return level
+}
@@ -0,0 +1,107 @@
+package sortedset_test
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/sortedset"
+)
+
+func Test_SortedSet_ShouldIterateInOrder(t *testing.T) {
+	ss := &sortedset.SortedSet[string]{}
+	ss.Add("c")
+	ss.Add("a")
+	ss.Add("b")
+	ss.Add("a")
+
+	actual := slices.Collect(ss.All())
+	expected := []string{"a", "b", "c"}
+
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func Test_SortedSet_ShouldRemoveItems(t *testing.T) {
+	ss := &sortedset.SortedSet[int]{}
+	ss.Add(1)
+	ss.Add(2)
+	ss.Add(3)
+
+	if !ss.Remove(2) {
+		t.Errorf("expected to remove existing item")
+	}
+
+	if ss.Remove(4) {
+		t.Errorf("expected not to remove missing item")
+	}
+
+	if ss.Contains(2) || ss.Len() != 2 {
+		t.Errorf("expected item to be removed, got %v", slices.Collect(ss.All()))
+	}
+}
+
+func Test_SortedSet_ShouldAscendFromItem(t *testing.T) {
+	ss := &sortedset.SortedSet[string]{}
+	for _, item := range []string{"proj/alpha/notes", "proj/beta", "proj/alpha", "other", "proj/alpha/todo"} {
+		ss.Add(item)
+	}
+
+	actual := slices.Collect(ss.Ascend("proj/alpha/"))
+	expected := []string{"proj/alpha/notes", "proj/alpha/todo", "proj/beta"}
+
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func Test_SortedSet_ShouldMatchSortedSlice_AfterRandomAddsAndRemoves(t *testing.T) {
+	ss := &sortedset.SortedSet[int]{}
+	expected := map[int]bool{}
+	random := rand.New(rand.NewPCG(1, 2))
+
+	for range 10_000 {
+		item := random.IntN(1_000)
+		if random.IntN(3) == 0 {
+			if ss.Remove(item) != expected[item] {
+				t.Fatalf("unexpected remove result for %d", item)
+			}
+			delete(expected, item)
+			continue
+		}
+
+		if ss.Add(item) == expected[item] {
+			t.Fatalf("unexpected add result for %d", item)
+		}
+		expected[item] = true
+	}
+
+	actual := slices.Collect(ss.All())
+	if !slices.Equal(actual, slices.Sorted(maps.Keys(expected))) || ss.Len() != len(expected) {
+		t.Errorf("expected %d sorted items, got %d", len(expected), len(actual))
+	}
+}
+
+func Test_SortedSet_ShouldContinueAscending_WhenItemsRemovedDuringIteration(t *testing.T) {
+	ss := &sortedset.SortedSet[int]{}
+	for i := range 10 {
+		ss.Add(i)
+	}
+
+	var actual []int
+	for item := range ss.Ascend(3) {
+		actual = append(actual, item)
+		ss.Remove(item)
+	}
+
+	expected := []int{3, 4, 5, 6, 7, 8, 9}
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if ss.Len() != 3 {
+		t.Errorf("expected 3 items to remain, got %d", ss.Len())
+	}
+}
@@ -1,5 +1,7 @@
 package tagdb
 
+import "time"
+
 // A key-value pair with tags.
 type TaggedKV struct {
 	// Primary key.  Must be <= 50 characters.
@@ -12,4 +14,68 @@ type TaggedKV struct {
 	// Tags can only contain lowercase letters, numbers and hyphens.
 	// Tags must be between 1 and 20 characters long.
 	Tags []string `json:"tags"`
+
+	// When the record was created.  Read from the `.created` system tag.
+	Created time.Time `json:"created,omitzero"`
+
+	// When the record was last updated.  Read from the `.updated` system tag.
+	Updated time.Time `json:"updated,omitzero"`
+
+	// When the record was moved to the trash.  Read from the `.deleted` system tag.
+	// Zero for records that have not been deleted.
+	Deleted time.Time `json:"deleted,omitzero"`
+
+	// Increases every time a committed transaction changes the record.
+	// Versions are assigned in WAL commit order, so they survive a restart.
+	Version uint64 `json:"version,omitempty"`
+
+	// When the record expires.  Expired records are hidden, and later removed.
+	// Zero for records that do not expire.
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// A read-only view of the database, used within db.View.
+type ReadTx interface {
+	// List records by tags.
+	// Tags are optional.  When not provided, all records are returned.
+	// When provided, only records matching all tags are returned.
+	List(tags []string, options ...ReadConfigurer) ([]TaggedKV, error)
+
+	// Retrieves a record by its key.
+	Get(key string, options ...ReadConfigurer) (taggedKV TaggedKV, found bool, err error)
+}
+
+// A read-write view of the database, used within db.Update.
+// Reads include the effect of earlier writes in the same transaction.
+type Tx interface {
+	ReadTx
+
+	// Creates or updates a record.
+	Set(key, value string) error
+
+	// Creates or updates a record, which expires after the ttl.
+	SetWithTTL(key, value string, ttl time.Duration) error
+
+	// Creates or updates a record, if its version matches the expected version.
+	// An expected version of zero requires that the record does not exist.
+	// Returns a *VersionConflictError when the versions differ.
+	CompareAndSet(key, value string, expectedVersion uint64) error
+
+	// Moves a record to the trash.
+	Delete(key string) error
+
+	// Adds a tag to a record.
+	Tag(key, tag string) error
+
+	// Removes a tag from a record.
+	Untag(key, tag string) error
+}
+
+// A page of records, returned by db.ListPage.
+type Page struct {
+	Items []TaggedKV `json:"items"`
+
+	// Opaque cursor used to request the next page, via WithCursor.
+	// Empty when there are no more records.
+	Next string `json:"next,omitempty"`
 }
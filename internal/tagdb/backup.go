@@ -0,0 +1,215 @@
+package tagdb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+const (
+	backupFormatVersion = 1
+	backupManifestName  = "manifest.json"
+	backupMaxEntrySize  = 4 * 1024 * 1024 * 1024
+)
+
+/*
+Describes a backup archive.
+
+Backups are gzipped tar archives, containing the manifest followed by a single snapshot.
+
+	| Entry                        | Comments                                  |
+	| ---------------------------- | ----------------------------------------- |
+	| manifest.json                | This manifest.                            |
+	| snapshots/<walId>.snapshot   | The state of the store, see snapshot.     |
+
+The snapshot covers every transaction committed to wal files before WalId.  A restored database
+starts writing to wal file WalId.  Like snapshot files, the snapshot is encrypted with the active key
+when encryption is configured.  The manifest is never encrypted.
+*/
+type backupManifest struct {
+	Format   int    `json:"format"`
+	Created  string `json:"created"`
+	WalId    int64  `json:"walId"`
+	Sequence uint64 `json:"sequence"`
+	Records  int    `json:"records"`
+}
+
+// Writes a consistent backup of the store.
+// The storage lock is only held while the store is copied, not while the backup is written.
+func (s *storage) backup(w io.Writer) error {
+	s.mu.RLock()
+	snap := s.inMemStore.snapshot(s.walManager.currentId + 1)
+	s.mu.RUnlock()
+
+	logger.Infof("writing backup of %d record(s) at wal boundary %d", len(snap.Records), snap.WalId)
+	return writeBackup(w, s.keys, snap)
+}
+
+func writeBackup(w io.Writer, keys *keyring, snap *snapshot) error {
+	snapshotData, err := encodeSnapshot(keys.activeCipher(), snap)
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := json.Marshal(backupManifest{
+		Format:   backupFormatVersion,
+		Created:  snap.Created,
+		WalId:    snap.WalId,
+		Sequence: snap.Sequence,
+		Records:  len(snap.Records),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot serialize backup manifest because %w", err)
+	}
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	modTime := time.Now()
+
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{backupManifestName, manifestData},
+		{backupSnapshotName(snap.WalId), snapshotData},
+	}
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    0644,
+			Size:    int64(len(entry.data)),
+			ModTime: modTime,
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err := tarWriter.Write(entry.data); err != nil {
+			return err
+		}
+	}
+
+	return errors.Join(tarWriter.Close(), gzipWriter.Close())
+}
+
+/*
+Restores a backup archive, written by db.Backup, into a new storage root.
+
+The root must not exist, or be empty, so a running database can never be overwritten.  Start the
+database on the root once the restore succeeds.  Encrypted backups require the key they were written
+with.  The snapshot is encrypted with the active key when WithEncryptionKeys is given.
+*/
+func Restore(archive io.Reader, root string, configOptions ...dbConfigurer) error {
+	config := newDbConfig(configOptions...)
+	return restore(config.fsys, config.keys, archive, root)
+}
+
+func restore(fsys fileSystem, keys *keyring, archive io.Reader, root string) error {
+	logger.Infof("restoring backup to `%s`", root)
+
+	// Validation.
+	if entries, err := fsys.ReadDir(root); err == nil && len(entries) > 0 {
+		return logger.Errorf("cannot restore backup because `%s` is not empty", root)
+	}
+
+	manifest, snap, err := readBackup(keys, archive)
+	if err != nil {
+		return logger.Errorf("cannot read backup because %s", err)
+	}
+
+	// Write the snapshot.  Start-up creates the first wal file from the snapshot wal id.
+	snapshotDir := path.Join(root, "snapshots")
+	if err := createDirIfNotExists(fsys, snapshotDir); err != nil {
+		return logger.Errorf("cannot create snapshot directory because %s", err)
+	}
+
+	if err := writeSnapshot(fsys, keys, snapshotDir, snap); err != nil {
+		return logger.Errorf("cannot write snapshot because %s", err)
+	}
+
+	logger.Infof("restored %d record(s) from backup created %s", manifest.Records, manifest.Created)
+	return nil
+}
+
+// Reads and validates a backup archive.  Encrypted snapshots are decrypted with the keys.
+func readBackup(keys *keyring, archive io.Reader) (*backupManifest, *snapshot, error) {
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gzipReader.Close()
+
+	var manifest *backupManifest
+	var snap *snapshot
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if header.Size > backupMaxEntrySize {
+			return nil, nil, fmt.Errorf("backup entry `%s` is too large", header.Name)
+		}
+
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case header.Name == backupManifestName:
+			manifest = &backupManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid manifest because %w", err)
+			}
+
+			if manifest.Format != backupFormatVersion {
+				return nil, nil, fmt.Errorf("unsupported backup format %d", manifest.Format)
+			}
+
+		case manifest != nil && header.Name == backupSnapshotName(manifest.WalId):
+			_, data, err := decryptSnapshot(keys, data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot decrypt snapshot because %w", err)
+			}
+
+			snap = &snapshot{}
+			if err := json.Unmarshal(data, snap); err != nil {
+				return nil, nil, fmt.Errorf("invalid snapshot because %w", err)
+			}
+
+		default:
+			return nil, nil, fmt.Errorf("unexpected backup entry `%s`", header.Name)
+		}
+	}
+
+	if manifest == nil || snap == nil {
+		return nil, nil, fmt.Errorf("backup is incomplete")
+	}
+
+	if snap.Format != snapshotFormatVersion || snap.WalId != manifest.WalId || len(snap.Records) != manifest.Records {
+		return nil, nil, fmt.Errorf("snapshot does not match the backup manifest")
+	}
+
+	return manifest, snap, nil
+}
+
+func backupSnapshotName(walId int64) string {
+	return path.Join("snapshots", strconv.FormatInt(walId, 10)+snapshotFileExtension)
+}
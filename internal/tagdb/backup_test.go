@@ -0,0 +1,110 @@
+package tagdb
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+func Test_storage_backup_RestoresToNewRoot(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value one")
+	store.tag("key-1", "tag-1")
+	store.set("key-2", "value-2")
+	store.delete("key-2")
+	before, _ := store.list([]string{}, WithDeleted())
+
+	var archive bytes.Buffer
+	if err := store.backup(&archive); err != nil {
+		t.Fatalf("backup returned error: %v", err)
+	}
+
+	// Act.
+	restoreRoot := path.Join(t.TempDir(), "restored")
+	if err := Restore(&archive, restoreRoot); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+
+	// Assert.
+	restored, err := openStorage(osFS{}, nil, restoreRoot)
+	if err != nil {
+		t.Fatalf("Failed to open restored storage: %v", err)
+	}
+	defer restored.close()
+
+	after, _ := restored.list([]string{}, WithDeleted())
+	if len(after) != len(before) {
+		t.Fatalf("Expected %d items after restore, but found %+v", len(before), after)
+	}
+
+	for i, expected := range before {
+		actual := after[i]
+		if actual.Key != expected.Key || actual.Value != expected.Value || actual.Version != expected.Version ||
+			!slices.Equal(actual.Tags, expected.Tags) || !actual.Deleted.Equal(expected.Deleted) {
+			t.Fatalf("Item mismatch after restore:\nexpected %+v\nactual   %+v", expected, actual)
+		}
+	}
+
+	// Restored databases continue writing after the backup boundary.
+	if _, err := restored.set("key-3", "value-3"); err != nil {
+		t.Fatalf("set after restore returned error: %v", err)
+	}
+
+	if restored.walManager.currentId != store.walManager.currentId+1 {
+		t.Errorf("Expected restored wal to start at %d, found %d", store.walManager.currentId+1, restored.walManager.currentId)
+	}
+}
+
+func Test_Restore_ShouldError_WhenRootIsNotEmpty(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	var archive bytes.Buffer
+	store.backup(&archive)
+
+	root := t.TempDir()
+	os.WriteFile(path.Join(root, "existing"), []byte("data"), 0644)
+
+	// Act.
+	err = Restore(&archive, root)
+
+	// Assert.
+	if err == nil {
+		t.Errorf("Expected error restoring into a non-empty root, but got none")
+	}
+}
+
+func Test_Restore_ShouldError_OnTruncatedArchive(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+
+	var archive bytes.Buffer
+	store.backup(&archive)
+	truncated := bytes.NewReader(archive.Bytes()[:archive.Len()/2])
+
+	// Act.
+	err = Restore(truncated, t.TempDir())
+
+	// Assert.
+	if err == nil {
+		t.Errorf("Expected error restoring a truncated archive, but got none")
+	}
+}
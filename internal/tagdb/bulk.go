@@ -0,0 +1,233 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+// Returned by a dry run to roll back its transaction.  Never returned to callers.
+var errBulkDryRun = errors.New("bulk dry run")
+
+// The change a bulk operation applies to each selected record.
+type BulkAction int
+
+const (
+	// Adds a tag to records that do not have it.
+	BulkTag BulkAction = iota
+
+	// Removes a tag from records that have it.
+	BulkUntag
+
+	// Moves records to the trash.
+	BulkDelete
+)
+
+func (action BulkAction) String() string {
+	switch action {
+	case BulkTag:
+		return "tag"
+	case BulkUntag:
+		return "untag"
+	case BulkDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(action))
+	}
+}
+
+// Parses a bulk action, such as `tag`, `untag` or `delete`.
+func ParseBulkAction(value string) (BulkAction, error) {
+	for _, action := range []BulkAction{BulkTag, BulkUntag, BulkDelete} {
+		if value == action.String() {
+			return action, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported action `%s`, expected tag, untag or delete", value)
+}
+
+// Selects records, and the change to apply to them.
+// Records are selected by tag query, key prefix, or both.  Deleted and expired records are never
+// selected.
+type BulkOperation struct {
+	Action BulkAction
+
+	// The tag to add or remove.  Not used by BulkDelete.
+	Tag string
+
+	// Optional boolean tag query, such as `2025 AND done`.  See tagQuery for the full syntax.
+	Query string
+
+	// Optional key prefix.
+	Prefix string
+}
+
+// The outcome of a bulk operation.
+type BulkResult struct {
+	// The number of records selected.
+	Matched int `json:"matched"`
+
+	// The number of records changed, or that would be changed by a dry run.  Records that already
+	// have, or do not have, the tag are not changed.
+	Affected int `json:"affected"`
+
+	// The keys of the affected records, sorted.
+	Keys []string `json:"keys"`
+
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Configures a bulk operation.
+type bulkConfig struct {
+	// Changes are reported, but not committed.
+	dryRun bool
+}
+
+// Configures how bulk operations are applied.
+type BulkConfigurer func(bulkConfig *bulkConfig) *bulkConfig
+
+func newBulkConfig(options ...BulkConfigurer) *bulkConfig {
+	config := &bulkConfig{}
+	for _, option := range options {
+		config = option(config)
+	}
+
+	return config
+}
+
+// Reports what a bulk operation would change, without committing anything.
+func WithBulkDryRun() BulkConfigurer {
+	return func(bulkConfig *bulkConfig) *bulkConfig {
+		bulkConfig.dryRun = true
+		return bulkConfig
+	}
+}
+
+// Validates a bulk operation.  At least one of query and prefix is required, so an empty request
+// cannot change every record.
+func validateBulkOperation(op BulkOperation) error {
+	var err error
+
+	switch op.Action {
+	case BulkTag, BulkUntag:
+		if tagErr := validateTag(op.Tag); tagErr != nil {
+			err = errors.Join(err, tagErr)
+		}
+
+	case BulkDelete:
+
+	default:
+		err = errors.Join(err, fmt.Errorf("unsupported bulk action %s", op.Action))
+	}
+
+	if op.Query == "" && op.Prefix == "" {
+		err = errors.Join(err, errors.New("bulk operations require a query, a prefix, or both"))
+	}
+
+	return err
+}
+
+// Returns the live records matching the query and prefix, sorted by key.
+// Tag queries are resolved through the tag index.
+func (u *updateTx) selectRecords(query *tagQuery, prefix string) []TaggedKV {
+	var candidates map[string]bool
+	if query != nil {
+		candidates = query.eval(u.tx.store)
+	} else {
+		candidates = u.tx.store.allKeys()
+	}
+
+	var result []TaggedKV
+	for _, key := range slices.Sorted(maps.Keys(candidates)) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if taggedKV, err := u.getLive(key); err == nil {
+			result = append(result, taggedKV)
+		}
+	}
+
+	return result
+}
+
+// Applies a bulk operation to every selected record.  Inputs must already be validated.
+func (u *updateTx) bulk(op BulkOperation, query *tagQuery) (BulkResult, error) {
+	result := BulkResult{Keys: []string{}}
+	if err := u.validateOpen(); err != nil {
+		return result, err
+	}
+
+	taggedKVs := u.selectRecords(query, op.Prefix)
+	result.Matched = len(taggedKVs)
+	for _, taggedKV := range taggedKVs {
+		key := taggedKV.Key
+		switch op.Action {
+		case BulkTag:
+			if slices.Contains(taggedKV.Tags, op.Tag) {
+				continue
+			}
+			u.tx.tag(key, op.Tag)
+
+		case BulkUntag:
+			if !slices.Contains(taggedKV.Tags, op.Tag) {
+				continue
+			}
+			u.tx.untag(key, op.Tag)
+
+		case BulkDelete:
+			if err := u.delete(key); err != nil {
+				return result, err
+			}
+		}
+
+		result.Keys = append(result.Keys, key)
+	}
+
+	result.Affected = len(result.Keys)
+	return result, nil
+}
+
+// Applies a bulk operation in a single transaction.  A dry run applies the operation, then rolls
+// back, so it reports exactly what would be committed.
+func (s *storage) bulk(op BulkOperation, config *bulkConfig) (BulkResult, error) {
+	var query *tagQuery
+	if op.Query != "" {
+		parsed, err := parseQuery(op.Query)
+		if err != nil {
+			return BulkResult{Keys: []string{}}, err
+		}
+		query = parsed
+	}
+
+	var result BulkResult
+	err := s.update(func(tx *updateTx) error {
+		var err error
+		if result, err = tx.bulk(op, query); err != nil {
+			return err
+		}
+
+		if config.dryRun {
+			return errBulkDryRun
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errBulkDryRun) {
+		result.DryRun = true
+		err = nil
+	}
+
+	if err != nil {
+		return BulkResult{Keys: []string{}}, err
+	}
+
+	logger.Infof("bulk %s affected %d of %d record(s)", op.Action, result.Affected, result.Matched)
+	return result, nil
+}
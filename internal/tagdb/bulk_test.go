@@ -0,0 +1,177 @@
+package tagdb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// Opens storage with records tagged by year and status, and a deleted record.
+func openBulkStorage(t *testing.T) *storage {
+	t.Helper()
+
+	store, err := openStorage(newMemFS(), nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	records := map[string][]string{
+		"note-1": {"2025", "done"},
+		"note-2": {"2025", "done", "archived"},
+		"note-3": {"2025"},
+		"task-1": {"2024", "done"},
+		"task-2": {"2025", "done"},
+	}
+	for key, tags := range records {
+		store.set(key, "value")
+		for _, tag := range tags {
+			store.tag(key, tag)
+		}
+	}
+	store.delete("task-2")
+
+	return store
+}
+
+func Test_storage_bulk_TagsRecordsMatchingQuery(t *testing.T) {
+	// Arrange.
+	store := openBulkStorage(t)
+	defer store.close()
+	op := BulkOperation{Action: BulkTag, Tag: "archived", Query: "2025 AND done"}
+
+	// Act.
+	result, err := store.bulk(op, newBulkConfig())
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to apply bulk operation: %v", err)
+	}
+
+	if result.Matched != 2 || result.Affected != 1 || !slices.Equal(result.Keys, []string{"note-1"}) {
+		t.Errorf("Expected 2 matched and note-1 affected, found %+v", result)
+	}
+
+	if taggedKV, _, _ := store.get("note-1"); !slices.Contains(taggedKV.Tags, "archived") {
+		t.Errorf("Expected note-1 to be tagged `archived`, found %v", taggedKV.Tags)
+	}
+
+	if taggedKV, _, _ := store.get("task-2", WithDeleted()); slices.Contains(taggedKV.Tags, "archived") {
+		t.Errorf("Expected deleted records to not be tagged")
+	}
+}
+
+func Test_storage_bulk_UntagsRecordsMatchingPrefixAndQuery(t *testing.T) {
+	// Arrange.
+	store := openBulkStorage(t)
+	defer store.close()
+	op := BulkOperation{Action: BulkUntag, Tag: "done", Query: "done", Prefix: "note-"}
+
+	// Act.
+	result, err := store.bulk(op, newBulkConfig())
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to apply bulk operation: %v", err)
+	}
+
+	if !slices.Equal(result.Keys, []string{"note-1", "note-2"}) {
+		t.Errorf("Expected note-1 and note-2 affected, found %+v", result)
+	}
+
+	if taggedKV, _, _ := store.get("task-1"); !slices.Contains(taggedKV.Tags, "done") {
+		t.Errorf("Expected task-1 to keep `done`, found %v", taggedKV.Tags)
+	}
+}
+
+func Test_storage_bulk_DeletesRecordsInOneTransaction(t *testing.T) {
+	// Arrange.
+	store := openBulkStorage(t)
+	defer store.close()
+	op := BulkOperation{Action: BulkDelete, Prefix: "note-"}
+
+	// Act.
+	result, err := store.bulk(op, newBulkConfig())
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to apply bulk operation: %v", err)
+	}
+
+	if result.Affected != 3 {
+		t.Errorf("Expected 3 records deleted, found %+v", result)
+	}
+
+	var transactionIds []string
+	for _, key := range result.Keys {
+		history := store.history(key)
+		transactionIds = append(transactionIds, history[len(history)-1].TransactionId)
+	}
+
+	if len(slices.Compact(transactionIds)) != 1 {
+		t.Errorf("Expected records to be deleted in one transaction, found %v", transactionIds)
+	}
+
+	if remaining, _ := store.list(nil); len(remaining) != 1 || remaining[0].Key != "task-1" {
+		t.Errorf("Expected only task-1 to remain, found %v", remaining)
+	}
+}
+
+func Test_storage_bulk_ShouldNotCommit_OnDryRun(t *testing.T) {
+	// Arrange.
+	store := openBulkStorage(t)
+	defer store.close()
+	op := BulkOperation{Action: BulkDelete, Query: "done"}
+
+	// Act.
+	result, err := store.bulk(op, newBulkConfig(WithBulkDryRun()))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to apply bulk operation: %v", err)
+	}
+
+	if !result.DryRun || !slices.Equal(result.Keys, []string{"note-1", "note-2", "task-1"}) {
+		t.Errorf("Expected a dry run affecting note-1, note-2 and task-1, found %+v", result)
+	}
+
+	if remaining, _ := store.list(nil); len(remaining) != 4 {
+		t.Errorf("Expected no records to be deleted, found %d remaining", len(remaining))
+	}
+}
+
+func Test_storage_bulk_ShouldError_OnInvalidQuery(t *testing.T) {
+	// Arrange.
+	store := openBulkStorage(t)
+	defer store.close()
+	op := BulkOperation{Action: BulkTag, Tag: "archived", Query: "2025 AND"}
+
+	// Act.
+	_, err := store.bulk(op, newBulkConfig())
+
+	// Assert.
+	var syntaxErr *QuerySyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("Expected a QuerySyntaxError, found %v", err)
+	}
+}
+
+func Test_validateBulkOperation_ShouldError_OnInvalidOperation(t *testing.T) {
+	cases := map[string]BulkOperation{
+		"no selection":   {Action: BulkDelete},
+		"missing tag":    {Action: BulkTag, Query: "done"},
+		"system tag":     {Action: BulkUntag, Tag: ".created", Query: "done"},
+		"unknown action": {Action: BulkAction(42), Query: "done"},
+	}
+
+	for name, op := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Act.
+			err := validateBulkOperation(op)
+
+			// Assert.
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package tagdb
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
 )
 
 const (
-	defaultRollWalAfterBytes        = 10 * 1024 * 1024 // 10 MiB.
-	defaultBackgroundTaskIntervalMs = 1_000            // 1 second.
+	defaultRollWalAfterBytes        = 10 * 1024 * 1024          // 10 MiB.
+	defaultBackgroundTaskIntervalMs = 1_000                     // 1 second.
+	defaultPurgeDeletedAfterMs      = 30 * 24 * 60 * 60 * 1_000 // 30 days.
+	defaultSnapshotIntervalMs       = 60 * 60 * 1_000           // 1 hour.
+	defaultSyncIntervalMs           = 1_000                     // 1 second.
+	defaultHistoryRetentionMs       = 30 * 24 * 60 * 60 * 1_000 // 30 days.
 )
 
 // Configures the database.
@@ -18,10 +24,52 @@ type dbConfig struct {
 
 	// Background tasks are run at this interval.
 	backgroundTaskInterval time.Duration
+
+	// Deleted records are purged from the trash after this duration.
+	// Zero disables automatic purging.
+	purgeDeletedAfter time.Duration
+
+	// Snapshots are written at this interval, when there are new commits.
+	// Zero disables automatic snapshots.
+	snapshotInterval time.Duration
+
+	// Wal files covered by a snapshot are archived rather than deleted, so OpenAt can reach points
+	// before the newest snapshot.
+	archiveWal bool
+
+	// Sealed wal files are compressed by the background tasks.
+	compressWal bool
+
+	// Controls when commits are synced to disk.
+	durability Durability
+
+	// The wal is synced at this interval, when using the interval durability mode.
+	syncInterval time.Duration
+
+	// Record history older than this duration is discarded.
+	// Zero keeps the full history, which grows without bound.
+	historyRetention time.Duration
+
+	// The file system holding the storage root.
+	fsys fileSystem
+
+	// Encrypts wal files and snapshots.  Nil disables encryption.
+	keys *keyring
 }
 
 type dbConfigurer func(dbConfig *dbConfig) *dbConfig
 
+// Creates a configuration from the defaults and the given options.
+func newDbConfig(configOptions ...dbConfigurer) *dbConfig {
+	config := &dbConfig{}
+	config = WithDefaultConfig()(config)
+	for _, configOption := range configOptions {
+		config = configOption(config)
+	}
+
+	return config
+}
+
 // Applies default values to all configuration options.
 func WithDefaultConfig() dbConfigurer {
 	return func(dbConfig *dbConfig) *dbConfig {
@@ -33,6 +81,15 @@ func WithDefaultConfig() dbConfigurer {
 		interval := time.Millisecond * defaultBackgroundTaskIntervalMs
 		dbConfig.backgroundTaskInterval = interval
 		dbConfig.rollWalAfterBytes = defaultRollWalAfterBytes
+		dbConfig.purgeDeletedAfter = time.Millisecond * defaultPurgeDeletedAfterMs
+		dbConfig.snapshotInterval = time.Millisecond * defaultSnapshotIntervalMs
+		dbConfig.archiveWal = true
+		dbConfig.compressWal = true
+		dbConfig.durability = DurabilityAlways
+		dbConfig.syncInterval = time.Millisecond * defaultSyncIntervalMs
+		dbConfig.historyRetention = time.Millisecond * defaultHistoryRetentionMs
+		dbConfig.fsys = osFS{}
+		dbConfig.keys = nil
 
 		return dbConfig
 	}
@@ -78,3 +135,351 @@ func WithBackgroundTaskIntervalMs(value int) dbConfigurer {
 		return dbConfig
 	}
 }
+
+// Defines how long deleted records remain in the trash before they are purged.
+// Zero disables automatic purging.
+func WithPurgeDeletedAfterMs(value int64) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		if value < 0 {
+			logger.Panic("cannot configure database, purgeDeletedAfterMs cannot be negative")
+		}
+
+		dbConfig.purgeDeletedAfter = time.Millisecond * time.Duration(value)
+
+		return dbConfig
+	}
+}
+
+// Defines how often snapshots are written.  Zero disables automatic snapshots.
+func WithSnapshotIntervalMs(value int64) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		if value < 0 {
+			logger.Panic("cannot configure database, snapshotIntervalMs cannot be negative")
+		}
+
+		dbConfig.snapshotInterval = time.Millisecond * time.Duration(value)
+
+		return dbConfig
+	}
+}
+
+// Moves wal files covered by a snapshot to the archive directory, rather than deleting them.
+// Enabled by default.  Without the archive, OpenAt cannot reach points before the newest snapshot.
+func WithWalArchive(value bool) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		dbConfig.archiveWal = value
+
+		return dbConfig
+	}
+}
+
+// Compresses wal files once the wal rolls past them, as they are never written again.
+// Enabled by default.  Encrypted wal files are not compressed.
+func WithWalCompression(value bool) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		dbConfig.compressWal = value
+
+		return dbConfig
+	}
+}
+
+/*
+Defines when commits are synced to disk.  Defaults to DurabilityAlways.
+
+A commit is visible to readers and watchers as soon as it is written to the wal, which can be
+before it is synced.  Only the committing caller waits for the sync.  If a sync fails, commits it
+covered may already have been read, and may be lost by a crash.  So a failed sync disables writes,
+and later commits return ErrWalSyncFailed until the database is restarted.
+*/
+func WithDurability(value Durability) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		switch value {
+		case DurabilityAlways, DurabilityInterval, DurabilityNone:
+			dbConfig.durability = value
+		default:
+			logger.Panicf("cannot configure database, unsupported durability %s", value)
+		}
+
+		return dbConfig
+	}
+}
+
+// Defines how often the wal is synced, when using DurabilityInterval.
+func WithSyncIntervalMs(value int64) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		if value <= 0 {
+			logger.Panic("cannot configure database, syncIntervalMs must be greater than 0")
+		}
+
+		dbConfig.syncInterval = time.Millisecond * time.Duration(value)
+
+		return dbConfig
+	}
+}
+
+// Defines how long record history is kept.  Defaults to 30 days.
+// Zero keeps the full history, which is held in memory and in every snapshot and backup.
+func WithHistoryRetentionMs(value int64) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		if value < 0 {
+			logger.Panic("cannot configure database, historyRetentionMs cannot be negative")
+		}
+
+		dbConfig.historyRetention = time.Millisecond * time.Duration(value)
+
+		return dbConfig
+	}
+}
+
+// Keeps the storage root in memory rather than on disk.  Nothing is persisted once the database
+// stops, so this is only suitable for tests and ephemeral databases.
+func WithInMemoryStorage(value bool) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		if value {
+			dbConfig.fsys = newMemFS()
+		} else {
+			dbConfig.fsys = osFS{}
+		}
+
+		return dbConfig
+	}
+}
+
+/*
+Encrypts wal files and snapshots at rest with AES-256-GCM.  New files are encrypted with the active
+key, or the first key when activeId is empty.  Every key can decrypt existing files, so to rotate
+keys, add the new key, make it active, then run Reencrypt to rewrite older files.
+
+Files written before encryption was enabled remain readable.  No keys disables encryption for new
+files, though encrypted files cannot then be read.
+*/
+func WithEncryptionKeys(activeId string, keys ...EncryptionKey) dbConfigurer {
+	return func(dbConfig *dbConfig) *dbConfig {
+		// Validation.
+		if dbConfig == nil {
+			logger.Panic("cannot configure database")
+		}
+
+		keyring, err := newKeyring(activeId, keys)
+		if err != nil {
+			logger.Panicf("cannot configure database, %s", err)
+		}
+
+		dbConfig.keys = keyring
+
+		return dbConfig
+	}
+}
+
+type deletedFilter int
+
+const (
+	excludeDeleted deletedFilter = iota
+	includeDeleted
+	onlyDeleted
+)
+
+// Configures a read request.
+type readConfig struct {
+	// Controls if deleted records are hidden, returned or exclusively returned.
+	deleted deletedFilter
+
+	// Optional boolean tag query.
+	query *tagQuery
+
+	// Records must have every one of these tags, in addition to matching the query.
+	tags []string
+
+	// Lists are sorted by this order, then by key.
+	order      ListOrder
+	descending bool
+
+	// Maximum number of records to list.  Zero means no limit.
+	limit int
+
+	// Opaque position to continue listing from.
+	cursor string
+
+	// Records that expired before this time are hidden.
+	now time.Time
+
+	// Invalid options are reported when the read is executed.
+	err error
+}
+
+// Configures how records are read, for example to include deleted records.
+type ReadConfigurer func(readConfig *readConfig) *readConfig
+
+func newReadConfig(options ...ReadConfigurer) *readConfig {
+	config := &readConfig{now: time.Now()}
+	for _, option := range options {
+		config = option(config)
+	}
+
+	return config
+}
+
+// Includes deleted records in the result.
+func WithDeleted() ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		readConfig.deleted = includeDeleted
+		return readConfig
+	}
+}
+
+// Returns deleted records only.  Use to list the contents of the trash.
+func OnlyDeleted() ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		readConfig.deleted = onlyDeleted
+		return readConfig
+	}
+}
+
+// Returns records matching a boolean tag query, such as `work AND (urgent OR blocked)`.
+// See tagQuery for the full syntax.  Invalid queries return a *QuerySyntaxError.
+func WithQuery(query string) ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		parsed, err := parseQuery(query)
+		if err != nil {
+			readConfig.err = errors.Join(readConfig.err, err)
+			return readConfig
+		}
+
+		readConfig.query = parsed
+		return readConfig
+	}
+}
+
+// Returns records with every one of the tags.  Applied in addition to WithQuery, so it can filter a
+// Scan, which has no tags parameter, without building a query from the tags.
+func WithTags(tags ...string) ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		if err := validateTags(tags); err != nil {
+			readConfig.err = errors.Join(readConfig.err, err)
+			return readConfig
+		}
+
+		readConfig.tags = append(readConfig.tags, tags...)
+		return readConfig
+	}
+}
+
+// Sorts listed records by key (default), created or updated timestamp.
+// Ties are broken by key.
+func WithOrder(order ListOrder) ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		switch order {
+		case OrderByKey, OrderByCreated, OrderByUpdated:
+			readConfig.order = order
+		default:
+			readConfig.err = errors.Join(readConfig.err, fmt.Errorf("unsupported order %s", order))
+		}
+
+		return readConfig
+	}
+}
+
+// Sorts listed records in descending order.
+func WithDescending() ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		readConfig.descending = true
+		return readConfig
+	}
+}
+
+// Limits the number of records returned.  Use with db.ListPage to request further pages.
+func WithLimit(limit int) ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		if limit < 0 {
+			readConfig.err = errors.Join(readConfig.err, fmt.Errorf("limit cannot be negative"))
+			return readConfig
+		}
+
+		readConfig.limit = limit
+		return readConfig
+	}
+}
+
+// Continues listing after the last record of a previous page.
+// The cursor must be requested with the same order.
+func WithCursor(cursor string) ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		readConfig.cursor = cursor
+		return readConfig
+	}
+}
+
+// Evaluates expiry at the given time, rather than now.  Used to read from a point in history.
+func withNow(now time.Time) ReadConfigurer {
+	return func(readConfig *readConfig) *readConfig {
+		readConfig.now = now
+		return readConfig
+	}
+}
+
+// Tests if a record should be returned, based on its deleted status, expiry and the query.
+// Expired records are never returned.
+func (config *readConfig) matches(taggedKV TaggedKV) bool {
+	if isExpired(taggedKV, config.now) {
+		return false
+	}
+
+	if config.query != nil && !config.query.matches(taggedKV) {
+		return false
+	}
+
+	if !isSubset(config.tags, taggedKV.Tags) {
+		return false
+	}
+
+	isDeleted := !taggedKV.Deleted.IsZero()
+
+	switch config.deleted {
+	case includeDeleted:
+		return true
+	case onlyDeleted:
+		return isDeleted
+	default:
+		return !isDeleted
+	}
+}
@@ -0,0 +1,396 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	crashTestSeeds      = 200
+	crashTestMaxOps     = 60
+	crashTestRoot       = "/db"
+	crashTestKeyCount   = 4
+	crashTestTagCount   = 3
+	crashTestMaxCrashAt = 400
+)
+
+// The expected state of a record.
+type modelRecord struct {
+	value   string
+	tags    []string
+	deleted bool
+}
+
+// The expected state of the store, built from acknowledged commits.
+type crashModel map[string]modelRecord
+
+// A randomly chosen operation.  Only operations that are valid against the model are chosen, so
+// any error is caused by an injected fault.
+type crashOp struct {
+	name  string
+	run   func(store *storage) error
+	apply func(model crashModel)
+}
+
+func (m crashModel) clone() crashModel {
+	result := crashModel{}
+	for key, record := range m {
+		record.tags = slices.Clone(record.tags)
+		result[key] = record
+	}
+
+	return result
+}
+
+func (m crashModel) liveKeys() []string {
+	var result []string
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		if !m[key].deleted {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+// Sets a value.  Setting a deleted record replaces it, without its tags.
+func (m crashModel) set(key, value string) {
+	record, found := m[key]
+	if !found || record.deleted {
+		record = modelRecord{}
+	}
+
+	record.value = value
+	m[key] = record
+}
+
+func (m crashModel) tag(key, tag string) {
+	record := m[key]
+	if !slices.Contains(record.tags, tag) {
+		record.tags = append(slices.Clone(record.tags), tag)
+	}
+	m[key] = record
+}
+
+func (m crashModel) delete(key string) {
+	record := m[key]
+	record.deleted = true
+	m[key] = record
+}
+
+func (m crashModel) String() string {
+	var result []string
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		record := m[key]
+		result = append(result, fmt.Sprintf("%s=%s%v deleted=%t", key, record.value, record.tags, record.deleted))
+	}
+
+	return strings.Join(result, ", ")
+}
+
+// Reads the state of the store into a model.
+func readModel(store *storage) (crashModel, error) {
+	items, err := store.list([]string{}, WithDeleted())
+	if err != nil {
+		return nil, err
+	}
+
+	result := crashModel{}
+	for _, item := range items {
+		result[item.Key] = modelRecord{
+			value:   item.Value,
+			tags:    slices.Sorted(slices.Values(item.Tags)),
+			deleted: !item.Deleted.IsZero(),
+		}
+	}
+
+	return result, nil
+}
+
+func modelsEqual(a, b crashModel) bool {
+	return maps.EqualFunc(a, b, func(x, y modelRecord) bool {
+		return x.value == y.value && x.deleted == y.deleted &&
+			slices.Equal(slices.Sorted(slices.Values(x.tags)), slices.Sorted(slices.Values(y.tags)))
+	})
+}
+
+// Chooses a random operation that is valid against the model.
+func randomCrashOp(rng *rand.Rand, model crashModel, step int) crashOp {
+	key := fmt.Sprintf("key-%d", rng.IntN(crashTestKeyCount))
+	value := fmt.Sprintf("value-%d", step)
+	live := model.liveKeys()
+
+	switch choice := rng.IntN(10); {
+	case choice < 2 && len(live) > 0:
+		key := live[rng.IntN(len(live))]
+		tag := fmt.Sprintf("tag-%d", rng.IntN(crashTestTagCount))
+		return crashOp{
+			name:  fmt.Sprintf("tag %s %s", key, tag),
+			run:   func(store *storage) error { return store.tag(key, tag) },
+			apply: func(model crashModel) { model.tag(key, tag) },
+		}
+
+	case choice < 3 && len(live) > 0:
+		key := live[rng.IntN(len(live))]
+		return crashOp{
+			name:  fmt.Sprintf("delete %s", key),
+			run:   func(store *storage) error { return store.delete(key) },
+			apply: func(model crashModel) { model.delete(key) },
+		}
+
+	case choice < 4:
+		// A multi-operation transaction, which must be applied atomically.
+		other := fmt.Sprintf("key-%d", rng.IntN(crashTestKeyCount))
+		return crashOp{
+			name: fmt.Sprintf("update %s %s", key, other),
+			run: func(store *storage) error {
+				return store.update(func(tx *updateTx) error {
+					if err := tx.set(key, value); err != nil {
+						return err
+					}
+					return tx.set(other, value+"-other")
+				})
+			},
+			apply: func(model crashModel) {
+				model.set(key, value)
+				model.set(other, value+"-other")
+			},
+		}
+
+	case choice < 5:
+		return crashOp{
+			name:  "roll",
+			run:   func(store *storage) error { store.maybeRoll(1); return nil },
+			apply: func(model crashModel) {},
+		}
+
+	case choice < 6 && rng.IntN(2) == 0:
+		return crashOp{
+			name:  "compress",
+			run:   func(store *storage) error { _, err := store.compressWal(); return err },
+			apply: func(model crashModel) {},
+		}
+
+	case choice < 6:
+		return crashOp{
+			name:  "snapshot",
+			run:   func(store *storage) error { return store.snapshot(rng.IntN(2) == 0) },
+			apply: func(model crashModel) {},
+		}
+
+	default:
+		return crashOp{
+			name: fmt.Sprintf("set %s %s", key, value),
+			run: func(store *storage) error {
+				_, err := store.set(key, value)
+				return err
+			},
+			apply: func(model crashModel) { model.set(key, value) },
+		}
+	}
+}
+
+// Drives random operations until a crash, then reopens from the durable state.  Every acknowledged
+// commit must be recovered.  The operation that was in flight at the crash may, or may not, be
+// recovered, but nothing else may be.
+func Test_openStorage_RecoversAcknowledgedCommits_AfterCrash(t *testing.T) {
+	for seed := range uint64(crashTestSeeds) {
+		t.Run(fmt.Sprintf("seed-%d", seed), func(t *testing.T) {
+			// Arrange.
+			rng := rand.New(rand.NewPCG(seed, seed))
+			fsys := newFaultFS(newMemFS())
+			store, err := openStorage(fsys, nil, crashTestRoot)
+			if err != nil {
+				t.Fatalf("Failed to connect to storage: %v", err)
+			}
+
+			fsys.crashAt(1 + rng.IntN(crashTestMaxCrashAt))
+
+			// Act.
+			model := crashModel{}
+			var inFlight *crashOp
+			var history []string
+			for step := range crashTestMaxOps {
+				op := randomCrashOp(rng, model, step)
+				history = append(history, op.name)
+				if err := op.run(store); err != nil {
+					if fsys.check() == nil {
+						t.Fatalf("Unexpected error from `%s`: %v", op.name, err)
+					}
+
+					inFlight = &op
+					break
+				}
+
+				op.apply(model)
+			}
+
+			fsys.crashAt(1)
+			store.close()
+
+			keepTorn := rng.IntN(2) == 0
+			image := fsys.crashImage(nil)
+			if keepTorn {
+				image = fsys.crashImage(rng)
+			}
+
+			recovered, err := openStorage(image, nil, crashTestRoot)
+			if err != nil {
+				t.Fatalf("Failed to reopen storage after %v: %v", history, err)
+			}
+			defer recovered.close()
+
+			// Assert.
+			actual, err := readModel(recovered)
+			if err != nil {
+				t.Fatalf("Failed to read recovered storage: %v", err)
+			}
+
+			if modelsEqual(actual, model) {
+				return
+			}
+
+			if inFlight != nil {
+				withInFlight := model.clone()
+				inFlight.apply(withInFlight)
+				if modelsEqual(actual, withInFlight) {
+					return
+				}
+			}
+
+			t.Fatalf("Recovered state does not match acknowledged commits.\nops:      %v\nexpected: %s\nactual:   %s",
+				history, model, actual)
+		})
+	}
+}
+
+func Test_storage_set_ShouldError_OnFailedWalWrite(t *testing.T) {
+	// Arrange.
+	inner := newMemFS()
+	fsys := newFaultFS(inner)
+	store, err := openStorage(fsys, nil, crashTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	fsys.failWrite(1)
+
+	// Act.
+	_, err = store.set("key-2", "value-2")
+
+	// Assert.
+	if !errors.Is(err, errInjectedWrite) {
+		t.Fatalf("Expected the injected write error, found %v", err)
+	}
+
+	if _, found, _ := store.get("key-2"); found {
+		t.Errorf("Expected a failed commit to not be applied")
+	}
+
+	store.close()
+	reopened, err := openStorage(inner, nil, crashTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.close()
+
+	actual, _ := readModel(reopened)
+	if len(actual) != 1 || actual["key-1"].value != "value-1" {
+		t.Errorf("Expected only key-1 after reopen, found %s", actual)
+	}
+}
+
+func Test_storage_set_ShouldError_AfterFailedWalSync(t *testing.T) {
+	// Arrange.
+	fsys := newFaultFS(newMemFS())
+	store, err := openStorage(fsys, nil, crashTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	fsys.failSync(1)
+	_, firstErr := store.set("key-1", "value-1")
+
+	// Act.
+	_, err = store.set("key-2", "value-2")
+
+	// Assert.
+	if !errors.Is(firstErr, ErrWalSyncFailed) || !errors.Is(err, ErrWalSyncFailed) {
+		t.Fatalf("Expected ErrWalSyncFailed, found %v and %v", firstErr, err)
+	}
+
+	if _, found, _ := store.get("key-2"); found {
+		t.Errorf("Expected commits after a failed sync to not be applied")
+	}
+}
+
+func Test_openStorage_TruncatesShortWrite(t *testing.T) {
+	// Arrange.
+	inner := newMemFS()
+	fsys := newFaultFS(inner)
+	store, err := openStorage(fsys, nil, crashTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	fsys.shortWrite(1)
+	if _, err := store.set("key-2", "value-2"); err == nil {
+		t.Fatalf("Expected the short write to fail the commit")
+	}
+	store.close()
+
+	// Act.
+	reopened, err := openStorage(inner, nil, crashTestRoot)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.close()
+
+	if _, err := reopened.set("key-3", "value-3"); err != nil {
+		t.Fatalf("set after reopen returned error: %v", err)
+	}
+
+	actual, _ := readModel(reopened)
+	if len(actual) != 2 || actual["key-1"].value != "value-1" || actual["key-3"].value != "value-3" {
+		t.Errorf("Expected key-1 and key-3 after reopen, found %s", actual)
+	}
+}
+
+func Test_faultFS_crashImage_DropsUnsyncedData(t *testing.T) {
+	// Arrange.
+	fsys := newFaultFS(newMemFS())
+	fsys.MkdirAll("/dir", 0755)
+
+	synced, _ := fsys.OpenFile("/dir/synced", os.O_CREATE|os.O_RDWR, 0644)
+	synced.Write([]byte("durable"))
+	synced.Sync()
+	fsys.SyncDir("/dir")
+	synced.Write([]byte(" lost"))
+
+	unlinked, _ := fsys.OpenFile("/dir/unlinked", os.O_CREATE|os.O_RDWR, 0644)
+	unlinked.Write([]byte("data"))
+	unlinked.Sync()
+
+	// Act.
+	image := fsys.crashImage(nil)
+
+	// Assert.
+	if data, _ := image.ReadFile("/dir/synced"); string(data) != "durable" {
+		t.Errorf("Expected only synced data to survive, found `%s`", data)
+	}
+
+	if _, err := image.Stat("/dir/unlinked"); err == nil {
+		t.Errorf("Expected a file without a synced directory entry to be lost")
+	}
+}
@@ -25,12 +25,18 @@ System tags are ready-only, and always start with a period.  Examples include:
 2. User Tags
 Can contain any combination of lowercase letters, numbers and hyphens.  Tags must be between 1 and
 20 characters long.
+
+Records can be given a time to live.  Expired records are hidden from all reads, and are removed
+by the background maintenance tasks.
 */
 package tagdb
 
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
@@ -44,6 +50,9 @@ type db struct {
 	storage   *storage
 	config    *dbConfig
 	isRunning bool
+
+	// Databases opened at recovery points by OpenAt.
+	pointsInTime *pointInTimeCache
 }
 
 func Start(root string, ctx context.Context, configOptions ...dbConfigurer) {
@@ -55,56 +64,91 @@ func Start(root string, ctx context.Context, configOptions ...dbConfigurer) {
 	logger.Info("starting tagdb")
 
 	// Configure.
-	config := &dbConfig{}
-	config = WithDefaultConfig()(config)
-	for _, configOption := range configOptions {
-		config = configOption(config)
-	}
+	config := newDbConfig(configOptions...)
 
 	// Ensure storage root exists.
-	if err := createDirIfNotExists(root); err != nil {
+	if err := createDirIfNotExists(config.fsys, root); err != nil {
 		logger.Panicf("cannot create database storage because %s", err)
 	}
 
-	store, err := openStorage(root)
+	store, err := openStorage(config.fsys, config.keys, root)
 	if err != nil {
 		logger.Panicf("cannot open database storage because %s", err)
 	}
+	store.setDurability(config.durability)
+	store.setHistoryRetention(config.historyRetention)
 
 	// Create connection.
 	dbConnection = &db{
-		storage:   store,
-		config:    config,
-		isRunning: true,
+		storage:      store,
+		config:       config,
+		isRunning:    true,
+		pointsInTime: newPointInTimeCache(),
 	}
 
 	// Start background maintenance tasks.
-	go func(store *storage, config *dbConfig, ctx context.Context) {
+	go func(conn *db, ctx context.Context) {
+		config := conn.config
 		if config.backgroundTaskInterval <= 0 {
 			logger.Info("background maintenance tasks disabled")
 			return
 		}
 
 		ticker := time.NewTicker(config.backgroundTaskInterval)
-
-		select {
-		case <-ticker.C:
-			if dbConnection == nil || !dbConnection.isRunning {
-				logger.Infof("shutting down db")
-				Stop()
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !conn.isRunning {
+					logger.Infof("shutting down maintenance tasks")
+					return
+				}
+
+				logger.Info("running maintenance tasks")
+				conn.storage.maybeRoll(config.rollWalAfterBytes)
+				if config.compressWal {
+					conn.storage.maybeCompressWal()
+				}
+				conn.storage.maybePurgeExpired()
+				conn.storage.maybePurgeDeleted(config.purgeDeletedAfter)
+				conn.storage.maybePruneHistory()
+				conn.storage.maybeSnapshot(config.snapshotInterval, config.archiveWal)
+
+			case <-ctx.Done():
+				logger.Infof("shutting down maintenance tasks")
+				if conn == dbConnection {
+					Stop()
+				}
 				return
 			}
+		}
 
-			logger.Info("running maintenance tasks")
-			dbConnection.storage.maybeRoll(config.rollWalAfterBytes)
+	}(dbConnection, ctx)
 
-		case <-ctx.Done():
-			logger.Infof("shutting down maintenance tasks")
-			Stop()
-			return
-		}
+	// Sync the wal at a fixed interval.
+	if config.durability == DurabilityInterval {
+		go func(conn *db, ctx context.Context) {
+			ticker := time.NewTicker(conn.config.syncInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if !conn.isRunning {
+						return
+					}
 
-	}(store, config, ctx)
+					if err := conn.storage.sync(); err != nil {
+						logger.Warnf("failed to sync wal because %s", err)
+					}
+
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(dbConnection, ctx)
+	}
 }
 
 func Stop() {
@@ -120,6 +164,9 @@ func Stop() {
 
 	dbConnection.isRunning = false
 	dbConnection.storage.close()
+
+	// Allow the database to be restarted.
+	dbConnection = nil
 }
 
 func Connect() (*db, error) {
@@ -141,12 +188,65 @@ func Connect() (*db, error) {
 // List records by tags.
 // Tags are optional.  When not provided, all records are returned.
 // When provided, only records matching all tags are returned.
-func (db *db) List(tags []string) ([]TaggedKV, error) {
+// Use WithQuery to combine tags with AND, OR and NOT.
+// Records are sorted by key, unless requested via WithOrder.
+// Deleted records are hidden, unless requested via WithDeleted or OnlyDeleted.
+func (db *db) List(tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	page, err := db.ListPage(tags, options...)
+	return page.Items, err
+}
+
+// List a page of records by tags.
+// Use WithLimit to set the page size, and WithCursor with Page.Next to request the next page.
+// Accepts the same tags and options as List.
+func (db *db) ListPage(tags []string, options ...ReadConfigurer) (Page, error) {
 	logger.Infof("db list records with tags `%+v`", tags)
 
 	// Validation.
 	if !db.isRunning {
 		err := logger.Error("cannot list because database is not running")
+		return Page{Items: []TaggedKV{}}, err
+	}
+
+	if err := validateTags(tags); err != nil {
+		return Page{Items: []TaggedKV{}}, err
+	}
+
+	return db.storage.listPage(tags, options...)
+}
+
+// Lists records in lexical key order.
+// Use prefix to browse hierarchical keys, such as `proj/alpha/`.  Use start (inclusive) and end
+// (exclusive) to restrict the key range.  Prefix, start and end are optional.
+// At most limit records are returned.  Zero means no limit.
+// Deleted records are hidden, unless requested via WithDeleted or OnlyDeleted.
+func (db *db) Scan(prefix, start, end string, limit int, options ...ReadConfigurer) ([]TaggedKV, error) {
+	logger.Infof("db scan records with prefix `%s`, start `%s`, end `%s` and limit %d", prefix, start, end, limit)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot scan because database is not running")
+		return []TaggedKV{}, err
+	}
+
+	if limit < 0 {
+		return []TaggedKV{}, fmt.Errorf("limit cannot be negative")
+	}
+
+	return db.storage.scan(prefix, start, end, limit, options...)
+}
+
+// Finds records with values containing the search text.
+// Results are ranked by the number of matching terms.  Terms are case-insensitive words.
+// Tags are optional.  When provided, only records matching all tags are returned.
+// Use WithQuery to filter with AND, OR and NOT, and WithLimit to return the top results only.
+// Deleted records are hidden, unless requested via WithDeleted or OnlyDeleted.
+func (db *db) Search(text string, tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	logger.Infof("db search records for `%s` with tags `%+v`", text, tags)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot search because database is not running")
 		return []TaggedKV{}, err
 	}
 
@@ -154,11 +254,12 @@ func (db *db) List(tags []string) ([]TaggedKV, error) {
 		return []TaggedKV{}, err
 	}
 
-	return db.storage.list(tags)
+	return db.storage.search(text, tags, options...)
 }
 
 // Retrieves a record by its key.
-func (db *db) Get(key string) (taggedKv TaggedKV, found bool, err error) {
+// Deleted records are hidden, unless requested via WithDeleted or OnlyDeleted.
+func (db *db) Get(key string, options ...ReadConfigurer) (taggedKv TaggedKV, found bool, err error) {
 	logger.Infof("db get record with key `%v`", key)
 
 	// Validation.
@@ -171,7 +272,206 @@ func (db *db) Get(key string) (taggedKv TaggedKV, found bool, err error) {
 		return TaggedKV{}, false, err
 	}
 
-	return db.storage.get(key)
+	return db.storage.get(key, options...)
+}
+
+// Returns the committed changes to a record, oldest first.  Purging a record removes its history.
+// History is kept in snapshots, so it survives wal compaction.  Changes older than the retention
+// set by WithHistoryRetentionMs are discarded.
+func (db *db) History(key string) ([]HistoryEntry, error) {
+	logger.Infof("db history of record with key `%v`", key)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot get history because database is not running")
+		return []HistoryEntry{}, err
+	}
+
+	if err := validateKey(key); err != nil {
+		return []HistoryEntry{}, err
+	}
+
+	return db.storage.history(key), nil
+}
+
+// Subscribes to committed changes, optionally filtered by key prefix or tag.
+// Changes are buffered per subscription.  A consumer that falls behind is unsubscribed, its channel
+// is closed and Subscription.Err returns ErrSlowConsumer.
+func (db *db) Watch(options ...WatchConfigurer) (*Subscription, error) {
+	logger.Info("db watch")
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot watch because database is not running")
+		return nil, err
+	}
+
+	return db.storage.feed.subscribe(newWatchConfig(options...)), nil
+}
+
+// Writes a snapshot of the database, then removes or archives the wal files it covers.
+// Start-up loads the newest snapshot, and only replays later wal files.
+func (db *db) Snapshot() error {
+	logger.Info("db snapshot")
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot snapshot because database is not running")
+		return err
+	}
+
+	return db.storage.snapshot(db.config.archiveWal)
+}
+
+// Writes records by tags to w, as JSON Lines or CSV.
+// Accepts the same tags and options as List.  Use Import to load the output into another database.
+func (db *db) Export(w io.Writer, format DataFormat, tags []string, options ...ReadConfigurer) error {
+	logger.Infof("db export %s records with tags `%+v`", format, tags)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot export because database is not running")
+		return err
+	}
+
+	items, err := db.List(tags, options...)
+	if err != nil {
+		return err
+	}
+
+	return exportRecords(w, format, items)
+}
+
+// Imports records from JSON Lines or CSV, as written by Export.
+// Every row is validated.  Invalid rows are skipped and reported in the result.  Valid rows are
+// committed in batches, see WithBatchSize, and replace the value, tags and expiry of existing
+// records.  Use WithDryRun to validate without committing.
+func (db *db) Import(r io.Reader, format DataFormat, options ...ImportConfigurer) (ImportResult, error) {
+	logger.Infof("db import %s records", format)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot import because database is not running")
+		return ImportResult{Errors: []ImportRowError{}}, err
+	}
+
+	return db.storage.importRecords(r, format, newImportConfig(options...))
+}
+
+// Adds a tag to, removes a tag from, or deletes every record selected by a tag query, a key prefix,
+// or both, in a single transaction.  Deleted and expired records are never selected.  Use
+// WithBulkDryRun to report the affected records without committing.  Invalid queries return a
+// *QuerySyntaxError.
+func (db *db) Bulk(op BulkOperation, options ...BulkConfigurer) (BulkResult, error) {
+	logger.Infof("db bulk %s with query `%s` and prefix `%s`", op.Action, op.Query, op.Prefix)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot apply bulk operation because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if opErr := validateBulkOperation(op); opErr != nil {
+		err = errors.Join(err, opErr)
+	}
+
+	if err != nil {
+		return BulkResult{Keys: []string{}}, err
+	}
+
+	return db.storage.bulk(op, newBulkConfig(options...))
+}
+
+// Writes a consistent backup of the database, as a gzipped tar archive.
+// Writes are only blocked while the records are copied in memory.  Use Restore to restore it.
+func (db *db) Backup(w io.Writer) error {
+	logger.Info("db backup")
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot backup because database is not running")
+		return err
+	}
+
+	return db.storage.backup(w)
+}
+
+// Returns storage statistics, including the disk space saved by wal compression.
+func (db *db) Stats() (Stats, error) {
+	logger.Info("db stats")
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot get stats because database is not running")
+		return Stats{}, err
+	}
+
+	return db.storage.stats()
+}
+
+// Opens a read-only copy of the database, as it was at a recovery point.  See OpenAt.
+// Recent recovery points are cached, and only a few are rebuilt at once.
+func (db *db) OpenAt(point RecoveryPoint) (*pointInTimeDb, error) {
+	logger.Infof("db open at %s", point)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot open at recovery point because database is not running")
+		return nil, err
+	}
+
+	return db.pointsInTime.get(point, func() (*pointInTimeDb, error) {
+		return openAt(db.storage.fsys, db.storage.keys, db.storage.root, point)
+	})
+}
+
+// Runs fn within a read-only transaction.
+// All reads observe the same consistent state.
+// Writes from other callers wait until fn returns, so fn must not write to the database.
+func (db *db) View(fn func(tx ReadTx) error) error {
+	logger.Info("db view transaction")
+
+	// Validation.
+	if !db.isRunning {
+		return logger.Error("cannot view because database is not running")
+	}
+
+	return db.storage.view(fn)
+}
+
+// Runs fn within a read-write transaction.
+// When fn succeeds all of its writes are committed atomically.  When fn returns an error no
+// writes are applied, and the error is returned.
+// All other callers wait until fn returns, so fn must not call other db methods.
+func (db *db) Update(fn func(tx Tx) error) error {
+	logger.Info("db update transaction")
+
+	// Validation.
+	if !db.isRunning {
+		return logger.Error("cannot update because database is not running")
+	}
+
+	return db.storage.update(func(tx *updateTx) error {
+		return fn(tx)
+	})
+}
+
+// Runs fn within a read-write transaction, like Update.
+// Returns the version assigned to every record changed by the transaction, such as for an ETag.
+// Reading the record back after Update could return the version of a later writer.
+func (db *db) UpdateWithVersion(fn func(tx Tx) error) (uint64, error) {
+	logger.Info("db update transaction with version")
+
+	// Validation.
+	if !db.isRunning {
+		return 0, logger.Error("cannot update because database is not running")
+	}
+
+	return db.storage.updateVersion(func(tx *updateTx) error {
+		return fn(tx)
+	})
 }
 
 // Creates or updates a record.
@@ -190,7 +490,7 @@ func (db *db) Set(key, value string) error {
 		err = errors.Join(err, keyErr)
 	}
 
-	if valueErr := validateValue(key); valueErr != nil {
+	if valueErr := validateValue(value); valueErr != nil {
 		err = errors.Join(err, valueErr)
 	}
 
@@ -198,10 +498,75 @@ func (db *db) Set(key, value string) error {
 		return err
 	}
 
-	return db.storage.set(key, value)
+	_, err = db.storage.set(key, value)
+	return err
 }
 
-// Removes a record from the database.
+// Creates or updates a record, which expires after the ttl.
+// Expired records are hidden immediately, and removed by the background maintenance tasks.
+func (db *db) SetWithTTL(key, value string, ttl time.Duration) error {
+	logger.Infof("db set record with key `%s`, value `%s` and ttl `%s`", key, value, ttl)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot set because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if valueErr := validateValue(value); valueErr != nil {
+		err = errors.Join(err, valueErr)
+	}
+
+	if ttlErr := validateTTL(ttl); ttlErr != nil {
+		err = errors.Join(err, ttlErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.storage.setWithTTL(key, value, ttl)
+	return err
+}
+
+// Creates or updates a record, if its version matches the expected version.
+// An expected version of zero requires that the record does not exist.
+// Returns a *VersionConflictError when the versions differ.
+func (db *db) CompareAndSet(key, value string, expectedVersion uint64) error {
+	logger.Infof("db compare and set record with key `%s`, value `%s` and version %d", key, value, expectedVersion)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot set because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if valueErr := validateValue(value); valueErr != nil {
+		err = errors.Join(err, valueErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.storage.compareAndSet(key, value, expectedVersion)
+	return err
+}
+
+// Moves a record to the trash.
+// Deleted records can be restored until they are purged.
 func (db *db) Delete(key string) error {
 	logger.Infof("db delete record with key `%s`", key)
 
@@ -213,7 +578,7 @@ func (db *db) Delete(key string) error {
 		err = errors.Join(err, notRunningErr)
 	}
 
-	if keyErr := validateKey(key); err != nil {
+	if keyErr := validateKey(key); keyErr != nil {
 		err = errors.Join(err, keyErr)
 	}
 
@@ -224,6 +589,76 @@ func (db *db) Delete(key string) error {
 	return db.storage.delete(key)
 }
 
+// Moves a record to the trash, if its version matches the expected version.
+// Returns a *VersionConflictError when the versions differ.
+func (db *db) CompareAndDelete(key string, expectedVersion uint64) error {
+	logger.Infof("db compare and delete record with key `%s` and version %d", key, expectedVersion)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot delete because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return db.storage.compareAndDelete(key, expectedVersion)
+}
+
+// Returns a deleted record from the trash.
+func (db *db) Restore(key string) error {
+	logger.Infof("db restore record with key `%s`", key)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot restore because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return db.storage.restore(key)
+}
+
+// Permanently removes a deleted record from the trash.
+func (db *db) Purge(key string) error {
+	logger.Infof("db purge record with key `%s`", key)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot purge because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return db.storage.purge(key)
+}
+
 // Adds a tag to a record.
 func (db *db) Tag(key string, tag string) error {
 	logger.Infof("db tag record with key `%s` and tag `%s`", key, tag)
@@ -277,3 +712,117 @@ func (db *db) Untag(key string, tag string) error {
 
 	return db.storage.untag(key, tag)
 }
+
+// Lists tags with their record counts, and any metadata, sorted by tag.
+// The prefix is optional, and restricts the tags returned, such as for autocomplete.
+// Counts exclude deleted records, unless requested via WithDeleted or OnlyDeleted.  Use WithQuery
+// to count only records matching a query.  Tags with metadata are listed even without records.
+func (db *db) Tags(prefix string, options ...ReadConfigurer) ([]TagInfo, error) {
+	logger.Infof("db list tags with prefix `%s`", prefix)
+
+	// Validation.
+	if !db.isRunning {
+		err := logger.Error("cannot list tags because database is not running")
+		return []TagInfo{}, err
+	}
+
+	return db.storage.tags(prefix, options...)
+}
+
+// Sets the description and colour of a tag.  Empty metadata removes it.
+// Metadata is independent of records, so can be set before any record has the tag.
+func (db *db) SetTagMetadata(tag string, metadata TagMetadata) error {
+	logger.Infof("db set metadata of tag `%s`", tag)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot set tag metadata because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if tagErr := validateTag(tag); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if metadataErr := validateTagMetadata(metadata); metadataErr != nil {
+		err = errors.Join(err, metadataErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return db.storage.setTagMetadata(tag, metadata)
+}
+
+// Renames a tag on every record, including deleted records, in a single transaction.  Metadata
+// moves with the tag.  Returns the number of records changed.
+// Returns ErrTagNotFound when the old tag is not in use, and ErrTagExists when the new tag is.  Use
+// MergeTags to combine existing tags.
+func (db *db) RenameTag(oldTag, newTag string) (int, error) {
+	logger.Infof("db rename tag `%s` to `%s`", oldTag, newTag)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot rename tag because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if tagErr := validateTag(oldTag); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if tagErr := validateTag(newTag); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if oldTag == newTag {
+		err = errors.Join(err, fmt.Errorf("cannot rename tag `%s` to itself", oldTag))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return db.storage.renameTag(oldTag, newTag)
+}
+
+// Replaces the source tags with the target tag on every record, including deleted records, in a
+// single transaction.  The target may be new, or already in use.  It keeps its own metadata, else
+// takes that of the first source with metadata.  Returns the number of records changed.
+// Returns ErrTagNotFound when a source tag is not in use.
+func (db *db) MergeTags(sources []string, target string) (int, error) {
+	logger.Infof("db merge tags `%s` into `%s`", strings.Join(sources, ","), target)
+
+	// Validation.
+	var err error
+
+	if !db.isRunning {
+		notRunningErr := logger.Error("cannot merge tags because database is not running")
+		err = errors.Join(err, notRunningErr)
+	}
+
+	if len(sources) == 0 {
+		err = errors.Join(err, errors.New("at least one source tag is required"))
+	}
+
+	for _, source := range sources {
+		if tagErr := validateTag(source); tagErr != nil {
+			err = errors.Join(err, tagErr)
+		}
+	}
+
+	if tagErr := validateTag(target); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return db.storage.mergeTags(sources, target)
+}
@@ -0,0 +1,157 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+// Returned by commits once a wal sync has failed.  The failed sync may have lost earlier commits,
+// so the database stops accepting writes until it is restarted and recovers from the wal.
+var ErrWalSyncFailed = errors.New("wal sync failed, writes are disabled")
+
+// Controls when committed transactions are synced to disk.
+type Durability int
+
+const (
+	// Commits return once their wal records are synced to disk.  Concurrent commits share a sync.
+	DurabilityAlways Durability = iota
+
+	// Commits return once their wal records are written.  The wal is synced at a fixed interval, so
+	// a power failure can lose the commits of the last interval.
+	DurabilityInterval
+
+	// Commits return once their wal records are written.  Syncing is left to the operating system.
+	DurabilityNone
+)
+
+func (durability Durability) String() string {
+	switch durability {
+	case DurabilityAlways:
+		return "always"
+	case DurabilityInterval:
+		return "interval"
+	case DurabilityNone:
+		return "none"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(durability))
+	}
+}
+
+// Parses a durability mode, such as `always`.
+func ParseDurability(value string) (Durability, error) {
+	for _, durability := range []Durability{DurabilityAlways, DurabilityInterval, DurabilityNone} {
+		if value == durability.String() {
+			return durability, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported durability `%s`, expected always, interval or none", value)
+}
+
+/*
+Syncs committed transactions to disk.
+
+Commits are written to the wal while holding the storage lock, and each is given a ticket.  The
+lock is released before waiting for the sync, so commits that arrive while a sync is running queue
+up behind it.  The first of them to wake syncs on behalf of the whole group.
+
+A failed sync is fatal.  Its commits were already applied, and the operating system may have
+dropped their pages, so every later commit is refused with ErrWalSyncFailed.
+*/
+type groupCommitter struct {
+	durability Durability
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written uint64 // Tickets issued to written commits.
+	synced  uint64 // Highest ticket known to be on disk.
+	syncing bool
+	wal     *wal // The wal file holding the latest written commit.
+	syncs   uint64
+	failed  error // Set by the first failed sync.
+}
+
+func newGroupCommitter(durability Durability) *groupCommitter {
+	gc := &groupCommitter{durability: durability}
+	gc.cond = sync.NewCond(&gc.mu)
+
+	return gc
+}
+
+// Records that a commit was written to the wal file, and returns its ticket.
+// The caller must hold the storage lock, so tickets follow the wal order.
+func (gc *groupCommitter) write(w *wal) uint64 {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	gc.written++
+	gc.wal = w
+
+	return gc.written
+}
+
+// Returns the error that disabled writes, or nil while syncs are succeeding.
+func (gc *groupCommitter) err() error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	return gc.failed
+}
+
+// Waits for a commit to be synced, when the durability mode requires it.
+func (gc *groupCommitter) wait(ticket uint64) error {
+	if gc.durability != DurabilityAlways {
+		return nil
+	}
+
+	return gc.syncTo(ticket)
+}
+
+// Syncs every commit written so far.
+func (gc *groupCommitter) sync() error {
+	gc.mu.Lock()
+	ticket := gc.written
+	gc.mu.Unlock()
+
+	return gc.syncTo(ticket)
+}
+
+// Waits until the ticket is synced.  Leads a sync when none is running.
+func (gc *groupCommitter) syncTo(ticket uint64) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	for gc.synced < ticket {
+		if gc.failed != nil {
+			return gc.failed
+		}
+
+		if gc.syncing {
+			gc.cond.Wait()
+			continue
+		}
+
+		// Sync on behalf of every commit written so far.
+		gc.syncing = true
+		target, w := gc.written, gc.wal
+		gc.mu.Unlock()
+		err := w.sync()
+		gc.mu.Lock()
+		gc.syncing = false
+		gc.syncs++
+		gc.cond.Broadcast()
+
+		if err != nil {
+			logger.Errorf("failed to sync wal because %s", err)
+			gc.failed = fmt.Errorf("%w, because %s", ErrWalSyncFailed, err)
+			return gc.failed
+		}
+
+		gc.synced = max(gc.synced, target)
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+)
+
+func Test_groupCommitter_wait_SharesOneSyncAcrossWrittenCommits(t *testing.T) {
+	// Arrange.
+	wal, err := openWal(osFS{}, nil, testWalId, path.Join(t.TempDir(), "test.wal"))
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	defer wal.close()
+
+	gc := newGroupCommitter(DurabilityAlways)
+	first := gc.write(wal)
+	gc.write(wal)
+	last := gc.write(wal)
+
+	// Act.
+	lastErr := gc.wait(last)
+	firstErr := gc.wait(first)
+
+	// Assert.
+	if err := errors.Join(lastErr, firstErr); err != nil {
+		t.Fatalf("wait returned error: %v", err)
+	}
+
+	if gc.syncs != 1 {
+		t.Errorf("expected 1 sync for 3 commits, found %d", gc.syncs)
+	}
+}
+
+func Test_groupCommitter_wait_DoesNotSync_WhenDurabilityIsNotAlways(t *testing.T) {
+	for _, durability := range []Durability{DurabilityInterval, DurabilityNone} {
+		t.Run(durability.String(), func(t *testing.T) {
+			// Arrange.
+			wal, err := openWal(osFS{}, nil, testWalId, path.Join(t.TempDir(), "test.wal"))
+			if err != nil {
+				t.Fatalf("unexpected error connecting to wal: %v", err)
+			}
+			defer wal.close()
+
+			gc := newGroupCommitter(durability)
+			ticket := gc.write(wal)
+
+			// Act.
+			err = gc.wait(ticket)
+
+			// Assert.
+			if err != nil {
+				t.Fatalf("wait returned error: %v", err)
+			}
+
+			if gc.syncs != 0 {
+				t.Errorf("expected no syncs, found %d", gc.syncs)
+			}
+		})
+	}
+}
+
+func Test_groupCommitter_sync_SyncsEveryWrittenCommit(t *testing.T) {
+	// Arrange.
+	wal, err := openWal(osFS{}, nil, testWalId, path.Join(t.TempDir(), "test.wal"))
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	defer wal.close()
+
+	gc := newGroupCommitter(DurabilityInterval)
+	gc.write(wal)
+	gc.write(wal)
+
+	// Act.
+	err = gc.sync()
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("sync returned error: %v", err)
+	}
+
+	if gc.synced != 2 || gc.syncs != 1 {
+		t.Errorf("expected 2 commits synced by 1 sync, found %d synced by %d", gc.synced, gc.syncs)
+	}
+}
+
+func Test_groupCommitter_wait_RefusesLaterCommits_WhenSyncFails(t *testing.T) {
+	// Arrange.
+	fsys := newFaultFS(newMemFS())
+	wal, err := openWal(fsys, nil, testWalId, "/test.wal")
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	defer wal.close()
+
+	gc := newGroupCommitter(DurabilityAlways)
+	first := gc.write(wal)
+	fsys.failSync(1)
+
+	// Act.
+	firstErr := gc.wait(first)
+	second := gc.write(wal)
+	secondErr := gc.wait(second)
+
+	// Assert.
+	if !errors.Is(firstErr, ErrWalSyncFailed) || !errors.Is(secondErr, ErrWalSyncFailed) {
+		t.Fatalf("expected ErrWalSyncFailed, found %v and %v", firstErr, secondErr)
+	}
+
+	if !errors.Is(gc.err(), ErrWalSyncFailed) || gc.syncs != 1 {
+		t.Errorf("expected writes to stay disabled after 1 sync, found %v after %d", gc.err(), gc.syncs)
+	}
+}
+
+func Test_storage_set_RetainsConcurrentCommitsAfterReopen(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	const count = 50
+
+	// Act.
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.set(fmt.Sprintf("key-%d", i), "value")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	store.close()
+
+	// Assert.
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("set returned error: %v", err)
+		}
+	}
+
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	items, _ := store.list([]string{})
+	if len(items) != count {
+		t.Errorf("expected %d items after reopen, found %d", count, len(items))
+	}
+}
@@ -0,0 +1,271 @@
+package tagdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	encryptionKeySize      = 32 // AES-256.
+	encryptionCheckSize    = 16
+	encryptionMaxKeyIdSize = 64
+	encryptionCheckLabel   = "tagdb encryption key check"
+)
+
+var encryptionKeyIdRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Returned when a file is encrypted with a key id that is not configured, or when an encrypted
+// file is opened without any keys.
+var ErrMissingEncryptionKey = errors.New("encryption key not configured")
+
+// Returned when a file is encrypted with a different key than the configured key with its id.
+var ErrWrongEncryptionKey = errors.New("wrong encryption key")
+
+/*
+An AES-256 key, used to encrypt wal files and snapshots at rest.
+
+The id is written to the header of every encrypted file, so older files can still be read after the
+active key is rotated.  Ids can contain letters, numbers, periods, underscores and hyphens.
+*/
+type EncryptionKey struct {
+	Id  string
+	Key []byte
+}
+
+/*
+Parses encryption keys from `id:key` pairs, separated by commas or new lines, where the key is 32
+bytes encoded as standard base64.  For example, `2025-01:<base64>,2025-06:<base64>`.
+
+Generate a key with `openssl rand -base64 32`.
+*/
+func ParseEncryptionKeys(value string) ([]EncryptionKey, error) {
+	var result []EncryptionKey
+	pairs := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid encryption key `%s`, expected id:base64", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key `%s` because %w", id, err)
+		}
+
+		result = append(result, EncryptionKey{Id: strings.TrimSpace(id), Key: key})
+	}
+
+	return result, nil
+}
+
+// Reads encryption keys from a file, such as a mounted secret.  See ParseEncryptionKeys.
+func ReadEncryptionKeysFile(keysPath string) ([]EncryptionKey, error) {
+	data, err := os.ReadFile(keysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseEncryptionKeys(string(data))
+}
+
+// The configured encryption keys.  A nil keyring disables encryption.
+type keyring struct {
+	// New files are encrypted with this key.
+	active *encryptionCipher
+
+	// Every configured key, by id, for reading.
+	ciphers map[string]*encryptionCipher
+}
+
+// Creates a keyring.  An empty active id uses the first key.  Returns nil when there are no keys.
+func newKeyring(activeId string, keys []EncryptionKey) (*keyring, error) {
+	if len(keys) == 0 {
+		if activeId != "" {
+			return nil, fmt.Errorf("active encryption key `%s` is not configured", activeId)
+		}
+
+		return nil, nil
+	}
+
+	if activeId == "" {
+		activeId = keys[0].Id
+	}
+
+	result := &keyring{ciphers: map[string]*encryptionCipher{}}
+	for _, key := range keys {
+		if len(key.Id) > encryptionMaxKeyIdSize || !encryptionKeyIdRegex.MatchString(key.Id) {
+			return nil, fmt.Errorf("invalid encryption key id `%s`", key.Id)
+		}
+
+		if _, found := result.ciphers[key.Id]; found {
+			return nil, fmt.Errorf("duplicate encryption key id `%s`", key.Id)
+		}
+
+		c, err := newEncryptionCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		result.ciphers[key.Id] = c
+	}
+
+	result.active = result.ciphers[activeId]
+	if result.active == nil {
+		return nil, fmt.Errorf("active encryption key `%s` is not configured", activeId)
+	}
+
+	return result, nil
+}
+
+// Returns the cipher for new files.  Nil when encryption is disabled.
+func (k *keyring) activeCipher() *encryptionCipher {
+	if k == nil {
+		return nil
+	}
+
+	return k.active
+}
+
+// Returns the cipher for a key id read from a file header, after checking it is the same key.
+func (k *keyring) cipherFor(keyId string, check []byte) (*encryptionCipher, error) {
+	if k == nil {
+		return nil, fmt.Errorf("%w, file is encrypted with key `%s`", ErrMissingEncryptionKey, keyId)
+	}
+
+	c, found := k.ciphers[keyId]
+	if !found {
+		return nil, fmt.Errorf("%w, file is encrypted with key `%s`", ErrMissingEncryptionKey, keyId)
+	}
+
+	if !hmac.Equal(c.check, check) {
+		return nil, fmt.Errorf(
+			"%w, key `%s` does not match the key the file was encrypted with",
+			ErrWrongEncryptionKey,
+			keyId)
+	}
+
+	return c, nil
+}
+
+// Authenticated encryption with a single key.
+type encryptionCipher struct {
+	keyId string
+	aead  cipher.AEAD
+
+	// Identifies the key without revealing it, so a wrong key is not mistaken for corruption.
+	check []byte
+}
+
+func newEncryptionCipher(key EncryptionKey) (*encryptionCipher, error) {
+	if len(key.Key) != encryptionKeySize {
+		return nil, fmt.Errorf(
+			"encryption key `%s` must be %d bytes, found %d",
+			key.Id,
+			encryptionKeySize,
+			len(key.Key))
+	}
+
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key.Key)
+	mac.Write([]byte(encryptionCheckLabel))
+
+	return &encryptionCipher{
+		keyId: key.Id,
+		aead:  aead,
+		check: mac.Sum(nil)[:encryptionCheckSize],
+	}, nil
+}
+
+// Encrypts plaintext with a random nonce.  Returns the nonce followed by the ciphertext.
+func (c *encryptionCipher) seal(plaintext, additionalData []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("cannot generate nonce because %s", err))
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+// Decrypts and authenticates the output of seal.
+func (c *encryptionCipher) open(sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted payload is too short")
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted payload failed authentication")
+	}
+
+	return plaintext, nil
+}
+
+/*
+Appends the encryption header, written to encrypted wal files and snapshots after their format.
+
+	| Field    | Size     | Comments                                  |
+	| -------- | -------- | ----------------------------------------- |
+	| IdLength | 1 byte   | Key id length.                            |
+	| KeyId    | IdLength | Key id.                                   |
+	| Check    | 16 bytes | HMAC-SHA256 key check value, truncated.   |
+*/
+func (c *encryptionCipher) appendHeader(buf []byte) []byte {
+	buf = append(buf, byte(len(c.keyId)))
+	buf = append(buf, c.keyId...)
+	return append(buf, c.check...)
+}
+
+// Parses an encryption header.  Returns the cipher, and the size of the header.
+func parseEncryptionHeader(data []byte, keys *keyring) (*encryptionCipher, int, error) {
+	if len(data) < 1 {
+		return nil, 0, fmt.Errorf("encryption header is incomplete")
+	}
+
+	idLength := int(data[0])
+	size := 1 + idLength + encryptionCheckSize
+	if len(data) < size {
+		return nil, 0, fmt.Errorf("encryption header is incomplete")
+	}
+
+	keyId := string(data[1 : 1+idLength])
+	c, err := keys.cipherFor(keyId, data[1+idLength:size])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return c, size, nil
+}
+
+// Tests if a file encrypted with the cipher, or nil for plain text, uses the active key.
+func (k *keyring) isActive(c *encryptionCipher) bool {
+	active := k.activeCipher()
+	if active == nil || c == nil {
+		return active == c
+	}
+
+	return active.keyId == c.keyId
+}
@@ -0,0 +1,336 @@
+package tagdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const encryptionTestRoot = "/db"
+
+func testEncryptionKey(id string, fill byte) EncryptionKey {
+	return EncryptionKey{Id: id, Key: bytes.Repeat([]byte{fill}, encryptionKeySize)}
+}
+
+func testKeyring(t *testing.T, activeId string, keys ...EncryptionKey) *keyring {
+	t.Helper()
+
+	result, err := newKeyring(activeId, keys)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	return result
+}
+
+// Writes a record to the wal, and another to a snapshot, then closes the storage.
+func writeEncryptedStorage(t *testing.T, fsys fileSystem, keys *keyring) {
+	t.Helper()
+
+	store, err := openStorage(fsys, keys, encryptionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "secret-value-1")
+	if err := store.snapshot(false); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	store.set("key-2", "secret-value-2")
+}
+
+func Test_openStorage_ShouldRecoverEncryptedWalAndSnapshot(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	keys := testKeyring(t, "", testEncryptionKey("key-a", 1))
+	writeEncryptedStorage(t, fsys, keys)
+
+	// Act.
+	store, err := openStorage(fsys, keys, encryptionTestRoot)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.close()
+
+	for key, expected := range map[string]string{"key-1": "secret-value-1", "key-2": "secret-value-2"} {
+		if actual, found, _ := store.get(key); !found || actual.Value != expected {
+			t.Errorf("Expected %s to be `%s`, found `%s`", key, expected, actual.Value)
+		}
+	}
+
+	for name, data := range fsys.files {
+		if bytes.Contains(data.data, []byte("secret-value")) {
+			t.Errorf("Expected `%s` to be encrypted", name)
+		}
+	}
+}
+
+func Test_openStorage_ShouldReportWrongKey_NotCorruption(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	writeEncryptedStorage(t, fsys, testKeyring(t, "", testEncryptionKey("key-a", 1)))
+
+	// Act.
+	_, err := openStorage(fsys, testKeyring(t, "", testEncryptionKey("key-a", 2)), encryptionTestRoot)
+
+	// Assert.
+	if !errors.Is(err, ErrWrongEncryptionKey) {
+		t.Fatalf("Expected ErrWrongEncryptionKey, found %v", err)
+	}
+
+	var corruptionErr *WalCorruptionError
+	if errors.As(err, &corruptionErr) {
+		t.Errorf("Expected a wrong key to not be reported as corruption")
+	}
+}
+
+func Test_openStorage_ShouldReportMissingKey(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	writeEncryptedStorage(t, fsys, testKeyring(t, "", testEncryptionKey("key-a", 1)))
+
+	// Act.
+	_, err := openStorage(fsys, nil, encryptionTestRoot)
+
+	// Assert.
+	if !errors.Is(err, ErrMissingEncryptionKey) {
+		t.Fatalf("Expected ErrMissingEncryptionKey, found %v", err)
+	}
+}
+
+func Test_readWalFile_ShouldReportTamperedPayload_AsCorruption(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	keys := testKeyring(t, "", testEncryptionKey("key-a", 1))
+	walPath := "/wal" + walFileExtension
+	if err := writeWalFile(fsys, keys, walPath, []operator{&setOperation{key: "key-1", value: "value-1"}}); err != nil {
+		t.Fatalf("Failed to write wal file: %v", err)
+	}
+
+	// Flip a ciphertext bit, and fix the checksum, so only authentication can detect it.
+	data := fsys.files[walPath].data
+	headerSize := len(newWalHeader(keys.activeCipher()).data)
+	payload := slices.Clone(data[headerSize+walFrameHeaderSize:])
+	payload[len(payload)-1] ^= 1
+	fsys.files[walPath].data = appendFrame(slices.Clone(data[:headerSize]), payload)
+
+	// Act.
+	_, err := readWalFile(fsys, keys, walPath)
+
+	// Assert.
+	var corruptionErr *WalCorruptionError
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("Expected a WalCorruptionError, found %v", err)
+	}
+}
+
+func Test_readWalFile_ShouldReportReplayedFrame_AsCorruption(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	keys := testKeyring(t, "", testEncryptionKey("key-a", 1))
+	walPath := "/wal" + walFileExtension
+	txId := uuid.NewString()
+	ops := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "value-1"},
+		&commitOperation{transactionId: txId},
+	}
+	if err := writeWalFile(fsys, keys, walPath, ops); err != nil {
+		t.Fatalf("Failed to write wal file: %v", err)
+	}
+
+	// Replay the first frame after the second.  Its checksum is valid, so only its offset differs.
+	data := fsys.files[walPath].data
+	headerSize := len(newWalHeader(keys.activeCipher()).data)
+	firstSize := walFrameHeaderSize + int(binary.LittleEndian.Uint32(data[headerSize:]))
+	fsys.files[walPath].data = append(slices.Clone(data), data[headerSize:headerSize+firstSize]...)
+
+	// Act.
+	_, err := readWalFile(fsys, keys, walPath)
+
+	// Assert.
+	var corruptionErr *WalCorruptionError
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("Expected a WalCorruptionError, found %v", err)
+	}
+
+	if corruptionErr.Offset != int64(len(data)) {
+		t.Errorf("Expected corruption at offset %d, found %d", len(data), corruptionErr.Offset)
+	}
+}
+
+func Test_storage_backup_ShouldEncryptSnapshot(t *testing.T) {
+	// Arrange.
+	keys := testKeyring(t, "", testEncryptionKey("key-a", 1))
+	store, err := openStorage(newMemFS(), keys, encryptionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+	store.set("key-1", "secret-value-1")
+
+	// Act.
+	var archive bytes.Buffer
+	if err := store.backup(&archive); err != nil {
+		t.Fatalf("backup returned error: %v", err)
+	}
+
+	// Assert.
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+
+	contents, _ := io.ReadAll(gzipReader)
+	if bytes.Contains(contents, []byte("secret-value-1")) {
+		t.Fatalf("Expected backup to be encrypted, found plain text")
+	}
+
+	if err := restore(newMemFS(), nil, bytes.NewReader(archive.Bytes()), "/restored"); err == nil {
+		t.Errorf("Expected restore without keys to fail")
+	}
+
+	fsys := newMemFS()
+	if err := restore(fsys, keys, bytes.NewReader(archive.Bytes()), "/restored"); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+
+	restored, err := openStorage(fsys, keys, "/restored")
+	if err != nil {
+		t.Fatalf("Failed to open restored storage: %v", err)
+	}
+	defer restored.close()
+
+	if taggedKV, found, _ := restored.get("key-1"); !found || taggedKV.Value != "secret-value-1" {
+		t.Errorf("Expected key-1 to be restored, found %+v", taggedKV)
+	}
+}
+
+func Test_Reencrypt_ShouldRewriteFiles_WithActiveKey(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	oldKey, newKey := testEncryptionKey("key-a", 1), testEncryptionKey("key-b", 2)
+	writeEncryptedStorage(t, fsys, testKeyring(t, "", oldKey))
+
+	// Act.
+	count, err := reencrypt(fsys, testKeyring(t, "key-b", oldKey, newKey), encryptionTestRoot)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt: %v", err)
+	}
+
+	if count == 0 {
+		t.Errorf("Expected files to be re-encrypted")
+	}
+
+	store, err := openStorage(fsys, testKeyring(t, "", newKey), encryptionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to open storage without the old key: %v", err)
+	}
+	defer store.close()
+
+	if actual, found, _ := store.get("key-2"); !found || actual.Value != "secret-value-2" {
+		t.Errorf("Expected key-2 to survive re-encryption, found `%s`", actual.Value)
+	}
+}
+
+func Test_Reencrypt_ShouldEncryptPlainTextFiles(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	writeEncryptedStorage(t, fsys, nil)
+	keys := testKeyring(t, "", testEncryptionKey("key-a", 1))
+
+	// Act.
+	count, err := reencrypt(fsys, keys, encryptionTestRoot)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt: %v", err)
+	}
+
+	if count == 0 {
+		t.Errorf("Expected files to be encrypted")
+	}
+
+	if again, _ := reencrypt(fsys, keys, encryptionTestRoot); again != 0 {
+		t.Errorf("Expected a second run to rewrite nothing, found %d file(s)", again)
+	}
+
+	snapshotIds, _ := listSnapshots(fsys, path.Join(encryptionTestRoot, "snapshots"))
+	for _, id := range snapshotIds {
+		snapshotPath := path.Join(encryptionTestRoot, "snapshots", fmt.Sprint(id)+snapshotFileExtension)
+		if _, c, err := readSnapshotFile(fsys, keys, snapshotPath); err != nil || c == nil {
+			t.Errorf("Expected snapshot `%s` to be encrypted, found error %v", snapshotPath, err)
+		}
+	}
+}
+
+func Test_openStorage_ShouldRollWal_OnKeyRotation(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	oldKey, newKey := testEncryptionKey("key-a", 1), testEncryptionKey("key-b", 2)
+	writeEncryptedStorage(t, fsys, testKeyring(t, "", oldKey))
+
+	// Act.
+	store, err := openStorage(fsys, testKeyring(t, "key-b", oldKey, newKey), encryptionTestRoot)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.close()
+
+	if c := store.walManager.current().header.cipher; c == nil || c.keyId != "key-b" {
+		t.Errorf("Expected the current wal to use the active key")
+	}
+}
+
+func Test_ParseEncryptionKeys_ShouldParsePairs(t *testing.T) {
+	// Arrange.
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, encryptionKeySize))
+	value := "key-a:" + key + ", key-b:" + key + "\nkey-c:" + key + "\n"
+
+	// Act.
+	keys, err := ParseEncryptionKeys(value)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+
+	if len(keys) != 3 || keys[0].Id != "key-a" || keys[1].Id != "key-b" || keys[2].Id != "key-c" {
+		t.Errorf("Expected keys a, b and c, found %v", keys)
+	}
+}
+
+func Test_newKeyring_ShouldError_OnInvalidKeys(t *testing.T) {
+	cases := map[string][]EncryptionKey{
+		"short key":    {{Id: "key-a", Key: []byte("short")}},
+		"invalid id":   {{Id: "key a", Key: bytes.Repeat([]byte{1}, encryptionKeySize)}},
+		"duplicate id": {testEncryptionKey("key-a", 1), testEncryptionKey("key-a", 2)},
+	}
+
+	for name, keys := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Act.
+			_, err := newKeyring("", keys)
+
+			// Assert.
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
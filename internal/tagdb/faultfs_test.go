@@ -0,0 +1,382 @@
+package tagdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Returned by every faultFS operation once it has crashed.
+var errCrashed = errors.New("file system crashed")
+
+// Returned by writes failed by faultFS.
+var errInjectedWrite = errors.New("injected write failure")
+
+// Returned by syncs failed by faultFS.
+var errInjectedSync = errors.New("injected sync failure")
+
+/*
+A memFS wrapper that injects faults, for crash consistency tests.
+
+Faults are armed by operation count, from when they are armed:
+
+  - failWrite  | The nth write fails without writing anything.
+  - shortWrite | The nth write writes half of its data, then fails.
+  - failSync   | The nth file sync fails without syncing anything.
+  - crashAt    | The nth mutating operation, and every operation after it, fails.
+
+Durability is tracked as on a real disk.  File contents are durable once the file is synced, and
+directory entries once the directory is synced.  crashImage returns the durable state, dropping
+everything else.  Directories themselves are treated as durable as soon as they are created.
+*/
+type faultFS struct {
+	mu    sync.Mutex
+	inner *memFS
+
+	// Counts down to each fault.  Zero is disarmed.
+	failWriteIn  int
+	shortWriteIn int
+	failSyncIn   int
+	crashIn      int
+	crashed      bool
+
+	// Durable state.
+	synced     map[*memFileData][]byte
+	syncedDirs map[string]map[string]*memFileData
+}
+
+// Wraps a memFS.  Its current contents are treated as durable.
+func newFaultFS(inner *memFS) *faultFS {
+	f := &faultFS{
+		inner:      inner,
+		synced:     map[*memFileData][]byte{},
+		syncedDirs: map[string]map[string]*memFileData{},
+	}
+
+	inner.mu.Lock()
+	defer inner.mu.Unlock()
+
+	for _, data := range inner.files {
+		f.synced[data] = slices.Clone(data.data)
+	}
+
+	for dir := range inner.dirs {
+		f.syncDirLocked(dir)
+	}
+	f.syncDirLocked("/")
+
+	return f
+}
+
+// Fails the nth write from now.
+func (f *faultFS) failWrite(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failWriteIn = n
+}
+
+// Writes half of the data of the nth write from now, then fails it.
+func (f *faultFS) shortWrite(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.shortWriteIn = n
+}
+
+// Fails the nth file sync from now.
+func (f *faultFS) failSync(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failSyncIn = n
+}
+
+// Crashes at the nth mutating operation from now.
+func (f *faultFS) crashAt(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.crashIn = n
+}
+
+/*
+Returns a new memFS holding only the durable state, as seen after a power loss.
+
+When rng is set, each file keeps a random prefix of the data appended since its last sync, as a
+disk may persist part of an unsynced write.  Otherwise all unsynced data is dropped.
+*/
+func (f *faultFS) crashImage(rng *rand.Rand) *memFS {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inner.mu.Lock()
+	defer f.inner.mu.Unlock()
+
+	image := newMemFS()
+	for dir, modTime := range f.inner.dirs {
+		image.dirs[dir] = modTime
+	}
+
+	for dir, entries := range f.syncedDirs {
+		for name, data := range entries {
+			content := f.synced[data]
+			if rng != nil && len(data.data) > len(content) && bytes.HasPrefix(data.data, content) {
+				content = data.data[:len(content)+rng.IntN(len(data.data)-len(content)+1)]
+			}
+
+			image.files[path.Join(dir, name)] = &memFileData{data: slices.Clone(content), modTime: time.Now()}
+		}
+	}
+
+	return image
+}
+
+// Counts a mutating operation.  Returns errCrashed when the crash point is reached.
+func (f *faultFS) mutate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.mutateLocked()
+}
+
+func (f *faultFS) mutateLocked() error {
+	if f.crashed {
+		return errCrashed
+	}
+
+	if f.crashIn > 0 {
+		f.crashIn--
+		if f.crashIn == 0 {
+			f.crashed = true
+			return errCrashed
+		}
+	}
+
+	return nil
+}
+
+// Returns errCrashed once crashed.
+func (f *faultFS) check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return errCrashed
+	}
+
+	return nil
+}
+
+// Records the current entries of a directory as durable.  Requires both locks.
+func (f *faultFS) syncDirLocked(dir string) {
+	entries := map[string]*memFileData{}
+	for name, data := range f.inner.files {
+		if path.Dir(name) == dir {
+			entries[path.Base(name)] = data
+		}
+	}
+
+	f.syncedDirs[dir] = entries
+}
+
+func (f *faultFS) OpenFile(name string, flag int, perm os.FileMode) (fsFile, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		if err := f.mutate(); err != nil {
+			return nil, err
+		}
+	} else if err := f.check(); err != nil {
+		return nil, err
+	}
+
+	inner, err := f.inner.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultFile{fs: f, inner: inner.(*memFile)}, nil
+}
+
+func (f *faultFS) ReadFile(name string) ([]byte, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+
+	return f.inner.ReadFile(name)
+}
+
+func (f *faultFS) ReadDir(name string) ([]os.DirEntry, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+
+	return f.inner.ReadDir(name)
+}
+
+func (f *faultFS) Stat(name string) (os.FileInfo, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+
+	return f.inner.Stat(name)
+}
+
+func (f *faultFS) MkdirAll(name string, perm os.FileMode) error {
+	if err := f.mutate(); err != nil {
+		return err
+	}
+
+	return f.inner.MkdirAll(name, perm)
+}
+
+func (f *faultFS) Remove(name string) error {
+	if err := f.mutate(); err != nil {
+		return err
+	}
+
+	return f.inner.Remove(name)
+}
+
+func (f *faultFS) Rename(oldName, newName string) error {
+	if err := f.mutate(); err != nil {
+		return err
+	}
+
+	return f.inner.Rename(oldName, newName)
+}
+
+func (f *faultFS) SyncDir(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.mutateLocked(); err != nil {
+		return err
+	}
+
+	f.inner.mu.Lock()
+	defer f.inner.mu.Unlock()
+
+	name = path.Clean(name)
+	if !f.inner.isDir(name) {
+		return &os.PathError{Op: "sync", Path: name, Err: os.ErrNotExist}
+	}
+
+	f.syncDirLocked(name)
+	return nil
+}
+
+// A faultFS file.
+type faultFile struct {
+	fs    *faultFS
+	inner *memFile
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.fs.check(); err != nil {
+		return 0, err
+	}
+
+	return f.inner.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.fs.check(); err != nil {
+		return 0, err
+	}
+
+	return f.inner.ReadAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	if err := f.fs.mutateLocked(); err != nil {
+		f.fs.mu.Unlock()
+		return 0, err
+	}
+
+	fail, short := false, false
+	if f.fs.failWriteIn > 0 {
+		f.fs.failWriteIn--
+		fail = f.fs.failWriteIn == 0
+	}
+
+	if f.fs.shortWriteIn > 0 {
+		f.fs.shortWriteIn--
+		short = f.fs.shortWriteIn == 0
+	}
+	f.fs.mu.Unlock()
+
+	switch {
+	case fail:
+		return 0, errInjectedWrite
+	case short:
+		n, err := f.inner.Write(p[:len(p)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	default:
+		return f.inner.Write(p)
+	}
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.fs.check(); err != nil {
+		return 0, err
+	}
+
+	return f.inner.Seek(offset, whence)
+}
+
+// Closing never fails after a crash, so tests can release files.
+func (f *faultFile) Close() error {
+	return f.inner.Close()
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) {
+	if err := f.fs.check(); err != nil {
+		return nil, err
+	}
+
+	return f.inner.Stat()
+}
+
+func (f *faultFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.mutateLocked(); err != nil {
+		return err
+	}
+
+	if f.fs.failSyncIn > 0 {
+		f.fs.failSyncIn--
+		if f.fs.failSyncIn == 0 {
+			return errInjectedSync
+		}
+	}
+
+	f.fs.inner.mu.Lock()
+	defer f.fs.inner.mu.Unlock()
+
+	if err := f.inner.check("sync", true); err != nil {
+		return err
+	}
+
+	f.fs.synced[f.inner.data] = slices.Clone(f.inner.data.data)
+	return nil
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.fs.mutate(); err != nil {
+		return err
+	}
+
+	return f.inner.Truncate(size)
+}
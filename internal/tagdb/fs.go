@@ -0,0 +1,80 @@
+package tagdb
+
+import (
+	"io"
+	"os"
+)
+
+// The file system used by storage.  Paths use forward slashes, as built by path.Join.
+// See osFS for the real file system, and memFS for an in-memory file system.
+type fileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (fsFile, error)
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldName, newName string) error
+
+	// Flushes directory entries, so creates, renames and removes survive a crash.
+	SyncDir(name string) error
+}
+
+// An open file.  Implemented by *os.File.
+type fsFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// The real file system.
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (fsFile, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// Avoid returning a non-nil interface holding a nil *os.File.
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) SyncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
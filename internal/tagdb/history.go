@@ -0,0 +1,131 @@
+package tagdb
+
+import "time"
+
+// A committed change to a record, returned by db.History.
+type HistoryEntry struct {
+	TransactionId string `json:"transactionId"`
+
+	// When the transaction was committed.
+	// Zero for commits written before timestamps were added.
+	Committed time.Time `json:"committed,omitzero"`
+
+	// The WAL operation type, such as SET, EXPIRE, TAG or UNTAG.
+	// Moving a record to the trash, and restoring it, are SYSTEM_TAG and SYSTEM_UNTAG of
+	// `.deleted`.
+	// Purging a record removes its history, so there is no entry for the purge.
+	Op string `json:"op"`
+
+	// The value set, the expiry or the deleted timestamp.  Empty for operations without a value.
+	Value string `json:"value,omitempty"`
+
+	// The tag added or removed.  Empty for operations without a tag.
+	Tag string `json:"tag,omitempty"`
+}
+
+// Converts an operation to an uncommitted history entry.
+// The `.created` and `.updated` system tags are excluded, as they repeat the commit timestamp.
+func toHistoryEntry(operation operator) (key string, entry HistoryEntry, isEntry bool) {
+	entry = HistoryEntry{TransactionId: operation.getTransactionId()}
+
+	switch o := operation.(type) {
+	case *setOperation:
+		entry.Op, entry.Value = opCodeSet.String(), o.value
+		return o.key, entry, true
+	case *deleteOperation:
+		entry.Op = opCodeDelete.String()
+		return o.key, entry, true
+	case *expireOperation:
+		entry.Op, entry.Value = opCodeExpire.String(), o.expires
+		return o.key, entry, true
+	case *tagOperation:
+		entry.Op, entry.Tag = opCodeTag.String(), o.tag
+		return o.key, entry, true
+	case *untagOperation:
+		entry.Op, entry.Tag = opCodeUntag.String(), o.tag
+		return o.key, entry, true
+	case *systemTagOperation:
+		if o.tag != systemTagDeleted {
+			return "", HistoryEntry{}, false
+		}
+		entry.Op, entry.Tag, entry.Value = opCodeSystemTag.String(), o.tag, o.value
+		return o.key, entry, true
+	case *systemUntagOperation:
+		if o.tag != systemTagDeleted {
+			return "", HistoryEntry{}, false
+		}
+		entry.Op, entry.Tag = opCodeSystemUntag.String(), o.tag
+		return o.key, entry, true
+	default:
+		return "", HistoryEntry{}, false
+	}
+}
+
+// Holds a history entry until its transaction commits.
+func (db *inMemStore) recordHistory(operation operator) {
+	key, entry, isEntry := toHistoryEntry(operation)
+	if !isEntry {
+		return
+	}
+
+	db.pendingHistory = append(db.pendingHistory, keyedHistoryEntry{key: key, entry: entry})
+}
+
+// Adds the pending history entries of a committed transaction to the history of each key.
+// Entries older than the retention period, relative to the commit, are removed from those keys.
+// A purge removes the history of its key, including the entries of the purging transaction.
+func (db *inMemStore) commitHistory(commit *commitOperation) {
+	committed, _ := parseTimestamp(commit.timestamp)
+	for _, pending := range db.pendingHistory {
+		if pending.entry.Op == opCodeDelete.String() {
+			delete(db.history, pending.key)
+			continue
+		}
+
+		pending.entry.Committed = committed
+		db.history[pending.key] = append(db.history[pending.key], pending.entry)
+
+		if !committed.IsZero() {
+			db.pruneHistory(pending.key, committed.Add(-db.historyRetention))
+		}
+	}
+
+	db.pendingHistory = db.pendingHistory[:0]
+}
+
+// Removes history entries committed before the cutoff.  Does nothing when retention is disabled.
+func (db *inMemStore) pruneHistory(key string, cutoff time.Time) {
+	if db.historyRetention <= 0 {
+		return
+	}
+
+	entries := db.history[key]
+	i := 0
+	for i < len(entries) && !entries[i].Committed.IsZero() && entries[i].Committed.Before(cutoff) {
+		i++
+	}
+
+	if i == len(entries) {
+		delete(db.history, key)
+		return
+	}
+
+	db.history[key] = entries[i:]
+}
+
+// Removes history entries committed before the cutoff, for all keys.
+func (db *inMemStore) pruneAllHistory(cutoff time.Time) {
+	for key := range db.history {
+		db.pruneHistory(key, cutoff)
+	}
+}
+
+// Returns a copy of the history of a key, oldest first.
+func (db *inMemStore) getHistory(key string) []HistoryEntry {
+	return append([]HistoryEntry{}, db.history[key]...)
+}
+
+type keyedHistoryEntry struct {
+	key   string
+	entry HistoryEntry
+}
@@ -0,0 +1,157 @@
+package tagdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func Test_storage_history_ReturnsCommittedChangesAfterReopen(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	store.set("key-1", "value-2")
+	store.untag("key-1", "tag-1")
+	store.delete("key-1")
+	store.set("key-2", "value-3")
+	store.close()
+
+	// Act.
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	entries := store.history("key-1")
+
+	// Assert.
+	expected := []HistoryEntry{
+		{Op: opCodeSet.String(), Value: "value-1"},
+		{Op: opCodeTag.String(), Tag: "tag-1"},
+		{Op: opCodeSet.String(), Value: "value-2"},
+		{Op: opCodeUntag.String(), Tag: "tag-1"},
+		{Op: opCodeSystemTag.String(), Tag: systemTagDeleted},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, found %+v", len(expected), entries)
+	}
+
+	for i, entry := range entries {
+		if entry.Op != expected[i].Op || entry.Tag != expected[i].Tag ||
+			(expected[i].Value != "" && entry.Value != expected[i].Value) {
+			t.Errorf("Unexpected entry %d:\nexpected %+v\nactual   %+v", i, expected[i], entry)
+		}
+
+		if uuid.Validate(entry.TransactionId) != nil || entry.Committed.IsZero() {
+			t.Errorf("Expected entry %d to have a transaction id and commit time: %+v", i, entry)
+		}
+	}
+}
+
+func Test_storage_history_RetainsSnapshottedChangesAfterReopen(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	store.set("key-2", "value-2")
+	store.delete("key-2")
+	if err := store.purge("key-2"); err != nil {
+		t.Fatalf("Failed to purge key-2: %v", err)
+	}
+	if err := store.snapshot(false); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	store.set("key-1", "value-3")
+	before := store.history("key-1")
+	purgedBefore := store.history("key-2")
+	store.close()
+
+	// Act.
+	store, err = openStorage(fsys, nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	after := store.history("key-1")
+	purgedAfter := store.history("key-2")
+
+	// Assert.
+	if len(before) != 3 || !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected history to survive the snapshot:\nexpected %+v\nactual   %+v", before, after)
+	}
+
+	if len(purgedBefore) != 0 || len(purgedAfter) != 0 {
+		t.Errorf("Expected purged record history to be removed, found %+v and %+v", purgedBefore, purgedAfter)
+	}
+}
+
+func Test_inMemStore_commitHistory_DiscardsEntriesOlderThanRetention(t *testing.T) {
+	// Arrange.
+	store := newInMemStore()
+	store.historyRetention = time.Hour
+
+	oldTx, newTx := uuid.NewString(), uuid.NewString()
+	now := time.Now()
+
+	// Act.
+	store.apply([]operator{
+		&setOperation{transactionId: oldTx, key: "key-1", value: "old"},
+		&commitOperation{transactionId: oldTx, timestamp: formatTimestamp(now.Add(-2 * time.Hour))},
+		&setOperation{transactionId: newTx, key: "key-1", value: "new"},
+		&commitOperation{transactionId: newTx, timestamp: formatTimestamp(now)},
+	})
+
+	// Assert.
+	entries := store.getHistory("key-1")
+	if len(entries) != 1 || entries[0].Value != "new" {
+		t.Errorf("Expected only the newest entry to be retained, found %+v", entries)
+	}
+}
+
+func Test_inMemStore_commitHistory_RemovesHistory_WhenPurged(t *testing.T) {
+	// Arrange.
+	store := newInMemStore()
+	setTx, purgeTx, recreateTx := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	// Act.
+	store.apply([]operator{
+		&setOperation{transactionId: setTx, key: "key-1", value: "secret"},
+		&tagOperation{transactionId: setTx, key: "key-1", tag: "tag-1"},
+		&commitOperation{transactionId: setTx},
+		&untagOperation{transactionId: purgeTx, key: "key-1", tag: "tag-1"},
+		&deleteOperation{transactionId: purgeTx, key: "key-1"},
+		&commitOperation{transactionId: purgeTx},
+		&setOperation{transactionId: recreateTx, key: "key-1", value: "new"},
+		&commitOperation{transactionId: recreateTx},
+	})
+
+	// Assert.
+	entries := store.getHistory("key-1")
+	if len(entries) != 1 || entries[0].Value != "new" {
+		t.Errorf("Expected only history after the purge, found %+v", entries)
+	}
+}
+
+func Test_inMemStore_getHistory_ReturnsEmpty_ForUnknownKey(t *testing.T) {
+	store := newInMemStore()
+
+	if entries := store.getHistory("key-1"); entries == nil || len(entries) != 0 {
+		t.Errorf("Expected an empty history, found %+v", entries)
+	}
+}
@@ -1,21 +1,58 @@
 package tagdb
 
 import (
+	"maps"
+	"strings"
+	"time"
+
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/bimap"
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/sortedset"
 )
 
 type inMemStore struct {
-	data  map[string]string
-	index bimap.BiMap[string]
+	data   map[string]string
+	keys   sortedset.SortedSet[string]
+	index  bimap.BiMap[string]
+	system map[string]map[string]string
+
+	// When records expire.  Records without an expiry are not included.
+	expires map[string]time.Time
+
+	// The number of commits applied, and the commit that last changed each record.
+	sequence uint64
+	versions map[string]uint64
+
+	// Keys changed by operations that are waiting for their commit.
+	uncommitted map[string]bool
+
+	// Full-text index of record values.  Maps keys to search terms.
+	terms bimap.BiMap[string]
+
+	// Committed changes per key, oldest first.  Restored from the snapshot at start-up, then
+	// extended by the wal files replayed after it, and later commits.
+	history          map[string][]HistoryEntry
+	pendingHistory   []keyedHistoryEntry
+	historyRetention time.Duration
+
+	// Optional metadata, by tag.  Independent of the records with each tag.
+	tagMetadata map[string]TagMetadata
 }
 
 func newInMemStore() *inMemStore {
 	logger.Info("initializing in-mem store")
 
 	return &inMemStore{
-		data:  map[string]string{},
-		index: bimap.BiMap[string]{},
+		data:        map[string]string{},
+		keys:        sortedset.SortedSet[string]{},
+		index:       bimap.BiMap[string]{},
+		system:      map[string]map[string]string{},
+		expires:     map[string]time.Time{},
+		versions:    map[string]uint64{},
+		uncommitted: map[string]bool{},
+		terms:       bimap.BiMap[string]{},
+		history:     map[string][]HistoryEntry{},
+		tagMetadata: map[string]TagMetadata{},
 	}
 }
 
@@ -30,12 +67,7 @@ func (db *inMemStore) list(tags []string) []TaggedKV {
 	if len(tags) == 0 {
 		// Return all records.
 		for key, value := range db.data {
-			recordTags := db.index.GetValues(key)
-			result = append(result, TaggedKV{
-				Key:   key,
-				Value: value,
-				Tags:  recordTags,
-			})
+			result = append(result, db.toTaggedKV(key, value))
 		}
 
 		return result
@@ -66,13 +98,76 @@ func (db *inMemStore) list(tags []string) []TaggedKV {
 
 	// Build result.
 	for _, key := range keysToReturn {
-		value := db.data[key]
-		recordTags := db.index.GetValues(key)
-		result = append(result, TaggedKV{
-			Key:   key,
-			Value: value,
-			Tags:  recordTags,
-		})
+		result = append(result, db.toTaggedKV(key, db.data[key]))
+	}
+
+	return result
+}
+
+// List records by tags and query.
+// Tags and query are optional.  When provided, only records matching all tags and the query are
+// returned.
+func (db *inMemStore) find(tags []string, query *tagQuery) []TaggedKV {
+	if query == nil {
+		return db.list(tags)
+	}
+
+	logger.Infof("in-mem find with tags %v and query `%s`", tags, query)
+
+	keys := query.eval(db)
+	for _, tag := range tags {
+		tagged := toFoundMap(db.index.GetKeys(tag))
+		for key := range keys {
+			if !tagged[key] {
+				delete(keys, key)
+			}
+		}
+	}
+
+	var result []TaggedKV
+	for key := range keys {
+		if value, found := db.data[key]; found {
+			result = append(result, db.toTaggedKV(key, value))
+		}
+	}
+
+	return result
+}
+
+// Returns every key in the store.
+func (db *inMemStore) allKeys() map[string]bool {
+	result := make(map[string]bool, len(db.data))
+	for key := range db.data {
+		result[key] = true
+	}
+
+	return result
+}
+
+// Lists records in lexical key order.
+// Only keys starting with prefix, and within the range start (inclusive) to end (exclusive), are
+// considered.  Prefix, start and end are optional.  Records are returned while include returns
+// true, until the limit is reached.  Zero means no limit.
+func (db *inMemStore) scan(prefix, start, end string, limit int, include func(TaggedKV) bool) []TaggedKV {
+	logger.Infof("in-mem scan with prefix `%s`, start `%s`, end `%s` and limit %d", prefix, start, end, limit)
+
+	result := []TaggedKV{}
+
+	from := max(prefix, start)
+	for key := range db.keys.Ascend(from) {
+		if !strings.HasPrefix(key, prefix) || (end != "" && key >= end) {
+			break
+		}
+
+		taggedKV := db.toTaggedKV(key, db.data[key])
+		if !include(taggedKV) {
+			continue
+		}
+
+		result = append(result, taggedKV)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
 	}
 
 	return result
@@ -84,38 +179,144 @@ func (db *inMemStore) get(key string) (taggedKv TaggedKV, found bool) {
 
 	value, found := db.data[key]
 	if found {
-		tags := db.index.GetValues(key)
-		return TaggedKV{
-			Key:   key,
-			Value: value,
-			Tags:  tags,
-		}, true
+		return db.toTaggedKV(key, value), true
 	}
 	return TaggedKV{}, false
 }
 
+// Builds a record from its value, user tags and system tags.
+func (db *inMemStore) toTaggedKV(key, value string) TaggedKV {
+	taggedKV := TaggedKV{
+		Key:     key,
+		Value:   value,
+		Tags:    db.index.GetValues(key),
+		Version: db.versions[key],
+		Expires: db.expires[key],
+	}
+
+	for tag, tagValue := range db.system[key] {
+		switch tag {
+		case systemTagCreated:
+			taggedKV.Created = db.parseSystemTimestamp(key, tag, tagValue)
+		case systemTagUpdated:
+			taggedKV.Updated = db.parseSystemTimestamp(key, tag, tagValue)
+		case systemTagDeleted:
+			taggedKV.Deleted = db.parseSystemTimestamp(key, tag, tagValue)
+		}
+	}
+
+	return taggedKV
+}
+
+func (db *inMemStore) parseSystemTimestamp(key, tag, value string) time.Time {
+	timestamp, err := parseTimestamp(value)
+	if err != nil {
+		logger.Warnf("cannot parse system tag `%s` on key `%s` with value `%s`", tag, key, value)
+	}
+
+	return timestamp
+}
+
+// Copies a single record, including its tags, from another store.
+func (db *inMemStore) copyRecord(source *inMemStore, key string) {
+	value, found := source.data[key]
+	if !found {
+		return
+	}
+
+	db.data[key] = value
+	db.keys.Add(key)
+
+	for _, tag := range source.index.GetValues(key) {
+		db.index.Add(key, tag)
+	}
+
+	if systemTags, found := source.system[key]; found {
+		db.system[key] = maps.Clone(systemTags)
+	}
+
+	if expires, found := source.expires[key]; found {
+		db.expires[key] = expires
+	}
+
+	if version, found := source.versions[key]; found {
+		db.versions[key] = version
+	}
+}
+
 func (db *inMemStore) apply(op []operator) {
 	logger.Infof("applying %d operation(s) to in-mem store", len(op))
 	for _, operation := range op {
+		db.recordHistory(operation)
+
 		switch o := operation.(type) {
 		case *setOperation:
 			logger.Infof("applying in-mem set operation: key=`%s`, value=`%s`", o.key, o.value)
 			db.data[o.key] = o.value
+			db.keys.Add(o.key)
+			db.unindexTerms(o.key)
+			db.indexTerms(o.key, o.value)
+			db.uncommitted[o.key] = true
 
 		case *deleteOperation:
 			logger.Infof("applying in-mem delete operation: key=`%s`", o.key)
 			delete(db.data, o.key)
+			db.keys.Remove(o.key)
+			db.unindexTerms(o.key)
+			delete(db.system, o.key)
+			delete(db.expires, o.key)
+			db.uncommitted[o.key] = true
+
+		case *expireOperation:
+			logger.Infof("applying in-mem expire operation: key=`%s`, expires=`%s`", o.key, o.expires)
+			db.uncommitted[o.key] = true
+			if o.expires == "" {
+				delete(db.expires, o.key)
+				break
+			}
+
+			expires, err := parseTimestamp(o.expires)
+			if err != nil {
+				logger.Warnf("cannot parse expiry `%s` on key `%s`", o.expires, o.key)
+				break
+			}
+			db.expires[o.key] = expires
 
 		case *tagOperation:
 			logger.Infof("applying in-mem tag operation: key=`%s`, tag=`%s`", o.key, o.tag)
 			db.index.Add(o.key, o.tag)
+			db.uncommitted[o.key] = true
 
 		case *untagOperation:
 			logger.Infof("applying in-mem untag operation: key=`%s`, tag=`%s`", o.key, o.tag)
 			db.index.Remove(o.key, o.tag)
+			db.uncommitted[o.key] = true
+
+		case *systemTagOperation:
+			logger.Infof("applying in-mem system tag operation: key=`%s`, tag=`%s`, value=`%s`", o.key, o.tag, o.value)
+			if _, found := db.system[o.key]; !found {
+				db.system[o.key] = map[string]string{}
+			}
+			db.system[o.key][o.tag] = o.value
+			db.uncommitted[o.key] = true
+
+		case *systemUntagOperation:
+			logger.Infof("applying in-mem system untag operation: key=`%s`, tag=`%s`", o.key, o.tag)
+			delete(db.system[o.key], o.tag)
+			db.uncommitted[o.key] = true
+
+		case *tagMetadataOperation:
+			logger.Infof("applying in-mem tag metadata operation: tag=`%s`", o.tag)
+			metadata := TagMetadata{Description: o.description, Colour: o.colour}
+			if metadata == (TagMetadata{}) {
+				delete(db.tagMetadata, o.tag)
+				break
+			}
+			db.tagMetadata[o.tag] = metadata
 
 		case *commitOperation:
-			// No-op.
+			db.commit()
+			db.commitHistory(o)
 
 		default:
 			// The in-mem store **must** never diverge from the wal.
@@ -124,3 +325,17 @@ func (db *inMemStore) apply(op []operator) {
 		}
 	}
 }
+
+// Assigns the next version to every record changed since the previous commit.
+func (db *inMemStore) commit() {
+	db.sequence++
+	for key := range db.uncommitted {
+		if _, found := db.data[key]; found {
+			db.versions[key] = db.sequence
+		} else {
+			delete(db.versions, key)
+		}
+	}
+
+	clear(db.uncommitted)
+}
@@ -13,9 +13,18 @@ func Test_InMemStore_ShouldRoundTrip(t *testing.T) {
 		op       operator
 		expected TaggedKV
 	}{
-		{op: &setOperation{transactionId: txId, key: "key1", value: "value1"}, expected: TaggedKV{Key: "key1", Value: "value1"}},
-		{op: &setOperation{transactionId: txId, key: "key2", value: "value2"}, expected: TaggedKV{Key: "key2", Value: "value2"}},
-		{op: &setOperation{transactionId: txId, key: "key3", value: "value3"}, expected: TaggedKV{Key: "key3", Value: "value3"}},
+		{
+			op:       &setOperation{transactionId: txId, key: "key1", value: "value1"},
+			expected: TaggedKV{Key: "key1", Value: "value1"},
+		},
+		{
+			op:       &setOperation{transactionId: txId, key: "key2", value: "value2"},
+			expected: TaggedKV{Key: "key2", Value: "value2"},
+		},
+		{
+			op:       &setOperation{transactionId: txId, key: "key3", value: "value3"},
+			expected: TaggedKV{Key: "key3", Value: "value3"},
+		},
 	}
 	db := newInMemStore()
 
@@ -255,3 +264,47 @@ func Test_InMemStore_list_ShouldReturnItemsWithMatchingTags(t *testing.T) {
 		t.Errorf("Expected second tagged KV to have key 'key-2', but got '%s'", taggedKVs[0].Key)
 	}
 }
+
+func Test_InMemStore_scan_ShouldReturnKeysInOrder(t *testing.T) {
+	// Arrange
+	db := newInMemStore()
+	db.apply([]operator{
+		&setOperation{transactionId: "tx1", key: "proj/beta/notes", value: "value-1"},
+		&setOperation{transactionId: "tx1", key: "proj/alpha/todo", value: "value-2"},
+		&setOperation{transactionId: "tx1", key: "other", value: "value-3"},
+		&setOperation{transactionId: "tx1", key: "proj/alpha/notes", value: "value-4"},
+		&setOperation{transactionId: "tx1", key: "proj/alpha/links", value: "value-5"},
+		&deleteOperation{transactionId: "tx1", key: "proj/alpha/links"},
+	})
+	includeAll := func(TaggedKV) bool { return true }
+
+	testCases := []struct {
+		prefix   string
+		start    string
+		end      string
+		limit    int
+		expected []string
+	}{
+		{expected: []string{"other", "proj/alpha/notes", "proj/alpha/todo", "proj/beta/notes"}},
+		{prefix: "proj/alpha/", expected: []string{"proj/alpha/notes", "proj/alpha/todo"}},
+		{prefix: "proj/", limit: 2, expected: []string{"proj/alpha/notes", "proj/alpha/todo"}},
+		{start: "proj/alpha/todo", end: "proj/beta/notes", expected: []string{"proj/alpha/todo"}},
+		{prefix: "proj/", start: "proj/b", expected: []string{"proj/beta/notes"}},
+		{prefix: "missing/", expected: []string{}},
+	}
+
+	for _, testCase := range testCases {
+		// Act
+		taggedKVs := db.scan(testCase.prefix, testCase.start, testCase.end, testCase.limit, includeAll)
+
+		// Assert
+		var actual []string
+		for _, taggedKV := range taggedKVs {
+			actual = append(actual, taggedKV.Key)
+		}
+
+		if !slices.Equal(actual, testCase.expected) && !(len(actual) == 0 && len(testCase.expected) == 0) {
+			t.Errorf("scan %+v returned %v", testCase, actual)
+		}
+	}
+}
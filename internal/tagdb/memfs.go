@@ -0,0 +1,416 @@
+package tagdb
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+An in-memory file system, for ephemeral databases and fast tests.
+
+Files and directories only exist for the life of the memFS.  Open files keep their contents when
+they are renamed or removed, as on Unix.  Syncs do nothing, as there is nothing to persist.
+*/
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+	dirs  map[string]time.Time
+}
+
+type memFileData struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		files: map[string]*memFileData{},
+		dirs:  map[string]time.Time{},
+	}
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (fsFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	data, found := m.files[name]
+	switch {
+	case m.isDir(name):
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+
+	case !found && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+
+	case found && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+
+	case !found:
+		if !m.isDir(path.Dir(name)) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+
+		data = &memFileData{modTime: time.Now()}
+		m.files[name] = data
+	}
+
+	f := &memFile{fs: m, name: name, data: data, flag: flag}
+	if flag&os.O_TRUNC != 0 && f.canWrite() {
+		data.data = nil
+		data.modTime = time.Now()
+	}
+
+	return f, nil
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	data, found := m.files[name]
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return slices.Clone(data.data), nil
+}
+
+func (m *memFS) ReadDir(name string) ([]os.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	if !m.isDir(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	var result []os.DirEntry
+	for filePath, data := range m.files {
+		if path.Dir(filePath) == name {
+			result = append(result, fs.FileInfoToDirEntry(newMemFileInfo(filePath, data)))
+		}
+	}
+
+	for dirPath, modTime := range m.dirs {
+		if dirPath != name && path.Dir(dirPath) == name {
+			result = append(result, fs.FileInfoToDirEntry(&memFileInfo{name: path.Base(dirPath), modTime: modTime, isDir: true}))
+		}
+	}
+
+	slices.SortFunc(result, func(a, b os.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return result, nil
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stat(path.Clean(name))
+}
+
+func (m *memFS) stat(name string) (os.FileInfo, error) {
+	if data, found := m.files[name]; found {
+		return newMemFileInfo(name, data), nil
+	}
+
+	if m.isDir(name) {
+		return &memFileInfo{name: path.Base(name), modTime: m.dirs[name], isDir: true}, nil
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *memFS) MkdirAll(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	for dir := name; !m.isDir(dir); dir = path.Dir(dir) {
+		if _, found := m.files[dir]; found {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+
+		m.dirs[dir] = time.Now()
+	}
+
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	if _, found := m.files[name]; found {
+		delete(m.files, name)
+		return nil
+	}
+
+	if _, found := m.dirs[name]; !found {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	for other := range m.files {
+		if path.Dir(other) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+
+	for other := range m.dirs {
+		if other != name && path.Dir(other) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+
+	delete(m.dirs, name)
+	return nil
+}
+
+// Renames a file.  Replaces any existing file with the new name.  Directories cannot be renamed.
+func (m *memFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldName = path.Clean(oldName)
+	newName = path.Clean(newName)
+	data, found := m.files[oldName]
+	if !found {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+
+	if !m.isDir(path.Dir(newName)) || m.isDir(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+
+	delete(m.files, oldName)
+	m.files[newName] = data
+	return nil
+}
+
+func (m *memFS) SyncDir(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	if !m.isDir(name) {
+		return &os.PathError{Op: "sync", Path: name, Err: os.ErrNotExist}
+	}
+
+	return nil
+}
+
+// The root directory always exists.
+func (m *memFS) isDir(name string) bool {
+	if name == "/" || name == "." {
+		return true
+	}
+
+	_, found := m.dirs[name]
+	return found
+}
+
+// An open memFS file.
+type memFile struct {
+	fs     *memFS
+	name   string
+	data   *memFileData
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) canRead() bool {
+	return f.flag&os.O_WRONLY == 0
+}
+
+func (f *memFile) canWrite() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// Returns an error when the file cannot be used for the operation.  Requires the fs lock.
+func (f *memFile) check(op string, allowed bool) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+
+	if !allowed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrPermission}
+	}
+
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("read", f.canRead()); err != nil {
+		return 0, err
+	}
+
+	if f.offset >= int64(len(f.data.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.data.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("read", f.canRead()); err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if off >= int64(len(f.data.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.data.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("write", f.canWrite()); err != nil {
+		return 0, err
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data.data))
+	}
+
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.data.data)) {
+		f.data.data = append(f.data.data, make([]byte, end-int64(len(f.data.data)))...)
+	}
+
+	copy(f.data.data[f.offset:], p)
+	f.offset = end
+	f.data.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("seek", true); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data.data))
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("close", true); err != nil {
+		return err
+	}
+
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("stat", true); err != nil {
+		return nil, err
+	}
+
+	return newMemFileInfo(f.name, f.data), nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	return f.check("sync", true)
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("truncate", f.canWrite()); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	if size <= int64(len(f.data.data)) {
+		f.data.data = f.data.data[:size]
+	} else {
+		f.data.data = append(f.data.data, make([]byte, size-int64(len(f.data.data)))...)
+	}
+
+	f.data.modTime = time.Now()
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func newMemFileInfo(name string, data *memFileData) *memFileInfo {
+	return &memFileInfo{name: path.Base(name), size: int64(len(data.data)), modTime: data.modTime}
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.isDir }
+func (i *memFileInfo) Sys() any           { return nil }
+
+func (i *memFileInfo) Mode() os.FileMode {
+	if i.isDir {
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
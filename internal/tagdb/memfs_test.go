@@ -0,0 +1,124 @@
+package tagdb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func Test_memFS_ReadsWrittenFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	fsys.MkdirAll("/root/dir", 0755)
+
+	file, err := fsys.OpenFile("/root/dir/test.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile returned error: %v", err)
+	}
+	defer file.Close()
+
+	// Act.
+	file.Write([]byte("hello "))
+	file.Write([]byte("world"))
+	file.Seek(0, io.SeekStart)
+	data, err := io.ReadAll(file)
+
+	// Assert.
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("Expected `hello world`, found `%s` (%v)", data, err)
+	}
+
+	if info, _ := fsys.Stat("/root/dir/test.txt"); info.Size() != 11 {
+		t.Errorf("Expected size 11, found %d", info.Size())
+	}
+}
+
+func Test_memFS_ShouldError_WhenParentDirIsMissing(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+
+	// Act.
+	_, err := fsys.OpenFile("/missing/test.txt", os.O_CREATE|os.O_RDWR, 0644)
+
+	// Assert.
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, found %v", err)
+	}
+}
+
+func Test_memFS_Truncate_ShrinksFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	file, _ := fsys.OpenFile("/test.txt", os.O_CREATE|os.O_RDWR, 0644)
+	defer file.Close()
+	file.Write([]byte("hello world"))
+
+	// Act.
+	err := file.Truncate(5)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Truncate returned error: %v", err)
+	}
+
+	if data, _ := fsys.ReadFile("/test.txt"); string(data) != "hello" {
+		t.Errorf("Expected `hello`, found `%s`", data)
+	}
+}
+
+func Test_memFS_Rename_MovesFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	fsys.MkdirAll("/a", 0755)
+	fsys.MkdirAll("/b", 0755)
+	file, _ := fsys.OpenFile("/a/test.txt", os.O_CREATE|os.O_WRONLY, 0644)
+	file.Write([]byte("data"))
+	file.Close()
+
+	// Act.
+	err := fsys.Rename("/a/test.txt", "/b/moved.txt")
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Rename returned error: %v", err)
+	}
+
+	if _, err := fsys.Stat("/a/test.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the old name to be removed, found %v", err)
+	}
+
+	entries, _ := fsys.ReadDir("/b")
+	if len(entries) != 1 || entries[0].Name() != "moved.txt" {
+		t.Errorf("Expected moved.txt in /b, found %v", entries)
+	}
+}
+
+func Test_memFS_Remove_ShouldError_WhenDirIsNotEmpty(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	fsys.MkdirAll("/dir/child", 0755)
+
+	// Act.
+	err := fsys.Remove("/dir")
+
+	// Assert.
+	if err == nil {
+		t.Errorf("Expected an error removing a non-empty directory")
+	}
+}
+
+func Test_memFile_ShouldError_AfterClose(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	file, _ := fsys.OpenFile("/test.txt", os.O_CREATE|os.O_RDWR, 0644)
+	file.Close()
+
+	// Act.
+	_, err := file.Write([]byte("data"))
+
+	// Assert.
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected os.ErrClosed, found %v", err)
+	}
+}
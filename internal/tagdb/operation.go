@@ -1,7 +1,7 @@
 package tagdb
 
 import (
-	"bytes"
+	"encoding/binary"
 	"fmt"
 	"strings"
 
@@ -9,7 +9,8 @@ import (
 )
 
 type operator interface {
-	serialize() []byte
+	// Returns the transaction id, op code and operation specific fields.
+	fields() []string
 	getTransactionId() string
 }
 
@@ -21,6 +22,10 @@ const (
 	opCodeTag
 	opCodeUntag
 	opCodeCommit
+	opCodeSystemTag
+	opCodeSystemUntag
+	opCodeExpire
+	opCodeTagMetadata
 )
 
 func (op operationCode) String() string {
@@ -35,6 +40,14 @@ func (op operationCode) String() string {
 		return "UNTAG"
 	case opCodeCommit:
 		return "COMMIT"
+	case opCodeSystemTag:
+		return "SYSTEM_TAG"
+	case opCodeSystemUntag:
+		return "SYSTEM_UNTAG"
+	case opCodeExpire:
+		return "EXPIRE"
+	case opCodeTagMetadata:
+		return "TAG_METADATA"
 	default:
 		panic(fmt.Sprintf("unsupported operation code %d", op))
 	}
@@ -52,25 +65,36 @@ type setOperation struct {
 	value         string
 }
 
-func (op setOperation) serialize() []byte {
-	fields := []string{op.transactionId, opCodeSet.String(), op.key, op.value}
-	record := strings.Join(fields, opFieldSeparator) + opRecordSeparator
-	return []byte(record)
+func (op setOperation) fields() []string {
+	return []string{op.transactionId, opCodeSet.String(), op.key, op.value}
 }
 
 func (op setOperation) getTransactionId() string {
 	return op.transactionId
 }
 
+// Sets when a record expires.  An empty value removes the expiry.
+type expireOperation struct {
+	transactionId string
+	key           string
+	expires       string
+}
+
+func (op expireOperation) fields() []string {
+	return []string{op.transactionId, opCodeExpire.String(), op.key, op.expires}
+}
+
+func (op expireOperation) getTransactionId() string {
+	return op.transactionId
+}
+
 type deleteOperation struct {
 	transactionId string
 	key           string
 }
 
-func (op deleteOperation) serialize() []byte {
-	fields := []string{op.transactionId, opCodeDelete.String(), op.key}
-	record := strings.Join(fields, opFieldSeparator) + opRecordSeparator
-	return []byte(record)
+func (op deleteOperation) fields() []string {
+	return []string{op.transactionId, opCodeDelete.String(), op.key}
 }
 
 func (op deleteOperation) getTransactionId() string {
@@ -83,10 +107,8 @@ type tagOperation struct {
 	tag           string
 }
 
-func (op tagOperation) serialize() []byte {
-	fields := []string{op.transactionId, opCodeTag.String(), op.key, op.tag}
-	record := strings.Join(fields, opFieldSeparator) + opRecordSeparator
-	return []byte(record)
+func (op tagOperation) fields() []string {
+	return []string{op.transactionId, opCodeTag.String(), op.key, op.tag}
 }
 
 func (op tagOperation) getTransactionId() string {
@@ -99,30 +121,88 @@ type untagOperation struct {
 	tag           string
 }
 
-func (op untagOperation) serialize() []byte {
-	fields := []string{op.transactionId, opCodeUntag.String(), op.key, op.tag}
-	record := strings.Join(fields, opFieldSeparator) + opRecordSeparator
-	return []byte(record)
+func (op untagOperation) fields() []string {
+	return []string{op.transactionId, opCodeUntag.String(), op.key, op.tag}
 }
 
 func (op untagOperation) getTransactionId() string {
 	return op.transactionId
 }
 
+// Sets a read-only system tag, such as `.created`, on a record.
+type systemTagOperation struct {
+	transactionId string
+	key           string
+	tag           string
+	value         string
+}
+
+func (op systemTagOperation) fields() []string {
+	return []string{op.transactionId, opCodeSystemTag.String(), op.key, op.tag, op.value}
+}
+
+func (op systemTagOperation) getTransactionId() string {
+	return op.transactionId
+}
+
+// Removes a read-only system tag, such as `.deleted`, from a record.
+type systemUntagOperation struct {
+	transactionId string
+	key           string
+	tag           string
+}
+
+func (op systemUntagOperation) fields() []string {
+	return []string{op.transactionId, opCodeSystemUntag.String(), op.key, op.tag}
+}
+
+func (op systemUntagOperation) getTransactionId() string {
+	return op.transactionId
+}
+
+// Sets the metadata of a tag.  Empty metadata removes it.
+type tagMetadataOperation struct {
+	transactionId string
+	tag           string
+	description   string
+	colour        string
+}
+
+func (op tagMetadataOperation) fields() []string {
+	return []string{op.transactionId, opCodeTagMetadata.String(), op.tag, op.description, op.colour}
+}
+
+func (op tagMetadataOperation) getTransactionId() string {
+	return op.transactionId
+}
+
+// Commits a transaction.  The timestamp is empty for commits written before timestamps were added.
 type commitOperation struct {
 	transactionId string
+	timestamp     string
 }
 
-func (op commitOperation) serialize() []byte {
-	fields := []string{op.transactionId, opCodeCommit.String()}
-	record := strings.Join(fields, opFieldSeparator) + opRecordSeparator
-	return []byte(record)
+func (op commitOperation) fields() []string {
+	if op.timestamp == "" {
+		return []string{op.transactionId, opCodeCommit.String()}
+	}
+
+	return []string{op.transactionId, opCodeCommit.String(), op.timestamp}
 }
 
 func (op commitOperation) getTransactionId() string {
 	return op.transactionId
 }
 
+// Encodes an operation using the legacy text format.
+// Fields are joined by a unit separator, and terminated by a record separator.  Values containing
+// either separator cannot be read back, so this is only used to test the legacy reader.
+func serialize(op operator) []byte {
+	record := strings.Join(op.fields(), opFieldSeparator) + opRecordSeparator
+	return []byte(record)
+}
+
+// Decodes an operation from the legacy text format.
 func deserialize(data []byte) (operator, error) {
 	// Remove optional trailing record separator.
 	if len(data) > 0 && data[len(data)-1] == opRecordSeparatorByte {
@@ -133,11 +213,71 @@ func deserialize(data []byte) (operator, error) {
 	record := string(data)
 	fields := strings.Split(record, opFieldSeparator)
 
+	return newOperation(fields)
+}
+
+/*
+Encodes an operation using the binary format.  Fields are length prefixed, so may contain any bytes.
+
+	| Field  | Size    | Comments                           |
+	| ------ | ------- | ---------------------------------- |
+	| Count  | uvarint | Number of fields.                  |
+	| Length | uvarint | Field length.  Repeated per field. |
+	| Field  | Length  | Field bytes.  Repeated per field.  |
+*/
+func encode(op operator) []byte {
+	fields := op.fields()
+
+	data := binary.AppendUvarint(nil, uint64(len(fields)))
+	for _, field := range fields {
+		data = binary.AppendUvarint(data, uint64(len(field)))
+		data = append(data, field...)
+	}
+
+	return data
+}
+
+// Decodes an operation from the binary format.
+func decode(data []byte) (operator, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, fmt.Errorf("cannot decode corrupted operation record")
+	}
+	data = data[n:]
+
+	fields := make([]string, 0, count)
+	for range count {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n) {
+			return nil, fmt.Errorf("cannot decode corrupted operation record")
+		}
+
+		fields = append(fields, string(data[n:n+int(length)]))
+		data = data[n+int(length):]
+	}
+
+	if len(data) != 0 {
+		return nil, fmt.Errorf("cannot decode operation record with %d trailing bytes", len(data))
+	}
+
+	return newOperation(fields)
+}
+
+// Creates an operation from its fields.
+func newOperation(fields []string) (operator, error) {
+	record := strings.Join(fields, opFieldSeparator)
+
 	const txField = 0     // Required for all op codes.
 	const opCodeField = 1 // Required for all op codes.
 	const keyField = 2
 	const valueField = 3 // Mutually exclusive with tagField.
 	const tagField = 3   // Mutually exclusive with valueField.
+	const systemTagValueField = 4
+	const expiresField = 3
+	const commitTimestampField = 2
+	const metadataTagField = 2
+	const metadataDescriptionField = 3
+	const metadataColourField = 4
 
 	// Validation.
 	if len(fields) < 2 {
@@ -165,7 +305,23 @@ func deserialize(data []byte) (operator, error) {
 		expectedFieldCount = 4
 	case opCodeCommit.String():
 		opCode = opCodeCommit
-		expectedFieldCount = 2
+		expectedFieldCount = 3
+		if len(fields) == 2 {
+			// Written before commit timestamps were added.
+			expectedFieldCount = 2
+		}
+	case opCodeSystemTag.String():
+		opCode = opCodeSystemTag
+		expectedFieldCount = 5
+	case opCodeSystemUntag.String():
+		opCode = opCodeSystemUntag
+		expectedFieldCount = 4
+	case opCodeExpire.String():
+		opCode = opCodeExpire
+		expectedFieldCount = 4
+	case opCodeTagMetadata.String():
+		opCode = opCodeTagMetadata
+		expectedFieldCount = 5
 	default:
 		return nil, fmt.Errorf("cannot deserialize unsupported operation code: %s", fields[opCodeField])
 	}
@@ -187,6 +343,12 @@ func deserialize(data []byte) (operator, error) {
 			key:           fields[keyField],
 			value:         fields[valueField],
 		}, nil
+	case opCodeExpire:
+		return &expireOperation{
+			transactionId: fields[txField],
+			key:           fields[keyField],
+			expires:       fields[expiresField],
+		}, nil
 	case opCodeDelete:
 		return &deleteOperation{
 			transactionId: fields[txField],
@@ -205,22 +367,35 @@ func deserialize(data []byte) (operator, error) {
 			tag:           fields[tagField],
 		}, nil
 	case opCodeCommit:
-		return &commitOperation{
+		op := &commitOperation{
 			transactionId: fields[txField],
+		}
+		if len(fields) > commitTimestampField {
+			op.timestamp = fields[commitTimestampField]
+		}
+
+		return op, nil
+	case opCodeSystemTag:
+		return &systemTagOperation{
+			transactionId: fields[txField],
+			key:           fields[keyField],
+			tag:           fields[tagField],
+			value:         fields[systemTagValueField],
+		}, nil
+	case opCodeSystemUntag:
+		return &systemUntagOperation{
+			transactionId: fields[txField],
+			key:           fields[keyField],
+			tag:           fields[tagField],
+		}, nil
+	case opCodeTagMetadata:
+		return &tagMetadataOperation{
+			transactionId: fields[txField],
+			tag:           fields[metadataTagField],
+			description:   fields[metadataDescriptionField],
+			colour:        fields[metadataColourField],
 		}, nil
 	default:
 		return nil, fmt.Errorf("cannot deserialize due to unsupported op code %d", opCode)
 	}
 }
-
-func opSplit(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	if atEOF {
-		return 0, nil, nil
-	}
-
-	if i := bytes.IndexByte(data, opRecordSeparatorByte); i >= 0 {
-		return i + 1, data[0 : i+1], nil
-	}
-
-	return 0, nil, nil
-}
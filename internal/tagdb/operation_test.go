@@ -12,14 +12,20 @@ func Test_operator_RoundTrips(t *testing.T) {
 	txId := uuid.NewString()
 	testCases := []operator{
 		&setOperation{txId, "key1", "value1"},
+		&expireOperation{txId, "key1", "2025-01-02T03:04:05Z"},
 		&deleteOperation{txId, "key2"},
 		&tagOperation{txId, "key3", "tag1"},
 		&untagOperation{txId, "key4", "tag2"},
-		&commitOperation{txId},
+		&systemTagOperation{txId, "key5", systemTagCreated, "2025-01-02T03:04:05Z"},
+		&systemUntagOperation{txId, "key6", systemTagDeleted},
+		&tagMetadataOperation{txId, "tag1", "A description", "#1e90ff"},
+		&tagMetadataOperation{txId, "tag2", "", ""},
+		&commitOperation{txId, ""},
+		&commitOperation{txId, "2025-01-02T03:04:05Z"},
 	}
 
 	for _, expected := range testCases {
-		b := expected.serialize()
+		b := serialize(expected)
 		actual, err := deserialize(b)
 		if err != nil {
 			t.Errorf("unexpected error during deserialization: %v", err)
@@ -46,7 +52,7 @@ func Test_operator_ShouldError_OnInvalidOperator(t *testing.T) {
 func Test_operator_ShouldError_OnInvalidTransactionId(t *testing.T) {
 	txId := "invalid-uuid"
 	op := &setOperation{txId, "key1", "value1"}
-	data := op.serialize()
+	data := serialize(op)
 
 	_, err := deserialize(data)
 	if err == nil {
@@ -77,3 +83,39 @@ func Test_operator_ShouldError_OnInvalidRecord(t *testing.T) {
 		t.Errorf("expected error on invalid operator, but got none")
 	}
 }
+
+func Test_operator_RoundTripsBinary(t *testing.T) {
+	txId := uuid.NewString()
+	testCases := []operator{
+		&setOperation{txId, "key1", "value\x1Fwith\x1Eseparators"},
+		&setOperation{txId, "key2", ""},
+		&systemTagOperation{txId, "key3", systemTagCreated, "2025-01-02T03:04:05Z"},
+		&tagMetadataOperation{txId, "tag1", "", "#fff"},
+		&commitOperation{txId, ""},
+		&commitOperation{txId, "2025-01-02T03:04:05.123456789Z"},
+	}
+
+	for _, expected := range testCases {
+		actual, err := decode(encode(expected))
+		if err != nil {
+			t.Errorf("unexpected error during decoding: %v", err)
+			continue
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf(
+				"operation failed to round trip:\n\texpected: %+v\n\tactual:   %+v",
+				expected,
+				actual)
+		}
+	}
+}
+
+func Test_operator_ShouldError_OnTruncatedBinaryRecord(t *testing.T) {
+	data := encode(&setOperation{uuid.NewString(), "key1", "value1"})
+
+	_, err := decode(data[:len(data)-1])
+	if err == nil {
+		t.Errorf("expected error on truncated record, but got none")
+	}
+}
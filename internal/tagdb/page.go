@@ -0,0 +1,132 @@
+package tagdb
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Returned when a cursor cannot be decoded, or was issued for a different order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// The order in which records are listed.  Ties are always broken by key.
+type ListOrder int
+
+const (
+	OrderByKey ListOrder = iota
+	OrderByCreated
+	OrderByUpdated
+)
+
+func (order ListOrder) String() string {
+	switch order {
+	case OrderByKey:
+		return "key"
+	case OrderByCreated:
+		return "created"
+	case OrderByUpdated:
+		return "updated"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(order))
+	}
+}
+
+// The position of the last record returned in a page.
+// Serialized as base64 JSON, and treated as opaque by callers.
+type pageCursor struct {
+	Order      ListOrder `json:"o"`
+	Descending bool      `json:"d,omitempty"`
+	Key        string    `json:"k"`
+	Timestamp  time.Time `json:"t,omitzero"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		// Cannot happen, all fields are serializable.
+		panic(fmt.Sprintf("cannot serialize cursor because %s", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (pageCursor, error) {
+	var cursor pageCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, fmt.Errorf("%w `%s`", ErrInvalidCursor, value)
+	}
+
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("%w `%s`", ErrInvalidCursor, value)
+	}
+
+	return cursor, nil
+}
+
+// Sorts records, then returns the page following the cursor.
+func paginate(items []TaggedKV, config *readConfig) (Page, error) {
+	compare := func(a, b TaggedKV) int {
+		var result int
+		switch config.order {
+		case OrderByCreated:
+			result = a.Created.Compare(b.Created)
+		case OrderByUpdated:
+			result = a.Updated.Compare(b.Updated)
+		}
+
+		if result == 0 {
+			result = cmp.Compare(a.Key, b.Key)
+		}
+
+		if config.descending {
+			return -result
+		}
+
+		return result
+	}
+
+	slices.SortFunc(items, compare)
+
+	// Skip records up to and including the cursor.
+	if config.cursor != "" {
+		cursor, err := decodeCursor(config.cursor)
+		if err != nil {
+			return Page{Items: []TaggedKV{}}, err
+		}
+
+		if cursor.Order != config.order || cursor.Descending != config.descending {
+			return Page{Items: []TaggedKV{}}, fmt.Errorf("%w, cursor does not match the requested order", ErrInvalidCursor)
+		}
+
+		last := TaggedKV{Key: cursor.Key, Created: cursor.Timestamp, Updated: cursor.Timestamp}
+		start, _ := slices.BinarySearchFunc(items, last, func(item, target TaggedKV) int {
+			if result := compare(item, target); result != 0 {
+				return result
+			}
+			return -1
+		})
+		items = items[start:]
+	}
+
+	if config.limit <= 0 || len(items) <= config.limit {
+		return Page{Items: items}, nil
+	}
+
+	items = items[:config.limit]
+	last := items[len(items)-1]
+	next := pageCursor{Order: config.order, Descending: config.descending, Key: last.Key}
+	switch config.order {
+	case OrderByCreated:
+		next.Timestamp = last.Created
+	case OrderByUpdated:
+		next.Timestamp = last.Updated
+	}
+
+	return Page{Items: items, Next: encodeCursor(next)}, nil
+}
@@ -0,0 +1,90 @@
+package tagdb
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_paginate_WalksAllPagesInOrder(t *testing.T) {
+	// Arrange
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []TaggedKV{
+		{Key: "key-c", Created: base.Add(1 * time.Hour)},
+		{Key: "key-a", Created: base.Add(3 * time.Hour)},
+		{Key: "key-e", Created: base.Add(1 * time.Hour)},
+		{Key: "key-b", Created: base.Add(2 * time.Hour)},
+		{Key: "key-d", Created: base},
+	}
+
+	testCases := []struct {
+		options  []ReadConfigurer
+		expected []string
+	}{
+		{
+			options:  []ReadConfigurer{},
+			expected: []string{"key-a", "key-b", "key-c", "key-d", "key-e"},
+		},
+		{
+			options:  []ReadConfigurer{WithDescending()},
+			expected: []string{"key-e", "key-d", "key-c", "key-b", "key-a"},
+		},
+		{
+			options:  []ReadConfigurer{WithOrder(OrderByCreated)},
+			expected: []string{"key-d", "key-c", "key-e", "key-b", "key-a"},
+		},
+		{
+			options:  []ReadConfigurer{WithOrder(OrderByCreated), WithDescending()},
+			expected: []string{"key-a", "key-b", "key-e", "key-c", "key-d"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		// Act
+		var actual []string
+		var cursor string
+		for range len(items) {
+			options := append(slices.Clone(testCase.options), WithLimit(2), WithCursor(cursor))
+			page, err := paginate(slices.Clone(items), newReadConfig(options...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, item := range page.Items {
+				actual = append(actual, item.Key)
+			}
+
+			cursor = page.Next
+			if cursor == "" {
+				break
+			}
+		}
+
+		// Assert
+		if !slices.Equal(actual, testCase.expected) {
+			t.Errorf("expected %v, got %v", testCase.expected, actual)
+		}
+	}
+}
+
+func Test_paginate_ShouldRejectMismatchedCursor(t *testing.T) {
+	// Arrange
+	items := []TaggedKV{{Key: "key-a"}, {Key: "key-b"}, {Key: "key-c"}}
+	page, err := paginate(slices.Clone(items), newReadConfig(WithLimit(1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Act
+	_, orderErr := paginate(slices.Clone(items), newReadConfig(WithOrder(OrderByUpdated), WithCursor(page.Next)))
+	_, garbageErr := paginate(slices.Clone(items), newReadConfig(WithCursor("not a cursor")))
+
+	// Assert
+	if orderErr == nil {
+		t.Errorf("expected error for cursor with a different order")
+	}
+
+	if garbageErr == nil {
+		t.Errorf("expected error for invalid cursor")
+	}
+}
@@ -0,0 +1,360 @@
+package tagdb
+
+import (
+	"fmt"
+	"slices"
+	"unicode"
+)
+
+/*
+A boolean tag query.
+
+	| Syntax      | Matches records                         |
+	| ----------- | --------------------------------------- |
+	| work        | tagged `work`.                          |
+	| a AND b     | tagged both `a` and `b`.                |
+	| a OR b      | tagged either `a` or `b`, or both.      |
+	| NOT a       | not tagged `a`.                         |
+	| (a OR b)    | Parentheses group terms.                |
+
+Operators must be uppercase, tags must be valid user tags.  NOT binds tighter than AND, which binds
+tighter than OR.  So `a OR b AND NOT c` is equivalent to `a OR (b AND (NOT c))`.
+*/
+type tagQuery struct {
+	text string
+	root queryNode
+}
+
+// Returned when a query cannot be parsed.
+type QuerySyntaxError struct {
+	Query    string
+	Position int
+	Reason   string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("invalid query `%s` at position %d: %s", e.Query, e.Position, e.Reason)
+}
+
+type queryNode interface {
+	// Returns the keys matching the node, using the tag index.
+	eval(store *inMemStore) map[string]bool
+
+	// Tests if a record with the given tags matches the node.
+	matches(tags []string) bool
+}
+
+func parseQuery(text string) (*tagQuery, error) {
+	tokens, err := tokenizeQuery(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &queryParser{text: text, tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !parser.isEnd() {
+		return nil, parser.errorf("unexpected `%s`", parser.peek().text)
+	}
+
+	return &tagQuery{text: text, root: root}, nil
+}
+
+// Returns the keys matching the query.
+func (q *tagQuery) eval(store *inMemStore) map[string]bool {
+	return q.root.eval(store)
+}
+
+// Tests if a record matches the query.
+func (q *tagQuery) matches(taggedKV TaggedKV) bool {
+	return q.root.matches(taggedKV.Tags)
+}
+
+func (q *tagQuery) String() string {
+	return q.text
+}
+
+type tagNode struct {
+	tag string
+}
+
+func (n *tagNode) eval(store *inMemStore) map[string]bool {
+	return toFoundMap(store.index.GetKeys(n.tag))
+}
+
+func (n *tagNode) matches(tags []string) bool {
+	return slices.Contains(tags, n.tag)
+}
+
+type andNode struct {
+	children []queryNode
+}
+
+func (n *andNode) eval(store *inMemStore) map[string]bool {
+	// Negated children are subtracted from the result, rather than evaluated against every key.
+	var result map[string]bool
+	var excluded []map[string]bool
+	for _, child := range n.children {
+		if not, isNot := child.(*notNode); isNot {
+			excluded = append(excluded, not.child.eval(store))
+			continue
+		}
+
+		keys := child.eval(store)
+		if result == nil {
+			result = keys
+			continue
+		}
+
+		for key := range result {
+			if !keys[key] {
+				delete(result, key)
+			}
+		}
+	}
+
+	if result == nil {
+		result = store.allKeys()
+	}
+
+	for _, keys := range excluded {
+		for key := range keys {
+			delete(result, key)
+		}
+	}
+
+	return result
+}
+
+func (n *andNode) matches(tags []string) bool {
+	for _, child := range n.children {
+		if !child.matches(tags) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type orNode struct {
+	children []queryNode
+}
+
+func (n *orNode) eval(store *inMemStore) map[string]bool {
+	result := map[string]bool{}
+	for _, child := range n.children {
+		for key := range child.eval(store) {
+			result[key] = true
+		}
+	}
+
+	return result
+}
+
+func (n *orNode) matches(tags []string) bool {
+	for _, child := range n.children {
+		if child.matches(tags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type notNode struct {
+	child queryNode
+}
+
+func (n *notNode) eval(store *inMemStore) map[string]bool {
+	result := store.allKeys()
+	for key := range n.child.eval(store) {
+		delete(result, key)
+	}
+
+	return result
+}
+
+func (n *notNode) matches(tags []string) bool {
+	return !n.child.matches(tags)
+}
+
+type queryTokenKind int
+
+const (
+	queryTokenTag queryTokenKind = iota
+	queryTokenAnd
+	queryTokenOr
+	queryTokenNot
+	queryTokenOpen
+	queryTokenClose
+)
+
+type queryToken struct {
+	kind     queryTokenKind
+	text     string
+	position int
+}
+
+func tokenizeQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, queryToken{queryTokenOpen, "(", i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, queryToken{queryTokenClose, ")", i})
+			i++
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				i++
+			}
+
+			word := string(runes[start:i])
+			switch word {
+			case "AND":
+				tokens = append(tokens, queryToken{queryTokenAnd, word, start})
+			case "OR":
+				tokens = append(tokens, queryToken{queryTokenOr, word, start})
+			case "NOT":
+				tokens = append(tokens, queryToken{queryTokenNot, word, start})
+			default:
+				if err := validateTag(word); err != nil {
+					return nil, &QuerySyntaxError{Query: text, Position: start, Reason: err.Error()}
+				}
+				tokens = append(tokens, queryToken{queryTokenTag, word, start})
+			}
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, &QuerySyntaxError{Query: text, Position: 0, Reason: "query is empty"}
+	}
+
+	return tokens, nil
+}
+
+// A recursive descent parser.
+//
+//	or    = and { "OR" and }
+//	and   = unary { "AND" unary }
+//	unary = "NOT" unary | group | tag
+//	group = "(" or ")"
+type queryParser struct {
+	text   string
+	tokens []queryToken
+	next   int
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []queryNode{first}
+	for !p.isEnd() && p.peek().kind == queryTokenOr {
+		p.next++
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == 1 {
+		return first, nil
+	}
+
+	return &orNode{children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []queryNode{first}
+	for !p.isEnd() && p.peek().kind == queryTokenAnd {
+		p.next++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == 1 {
+		return first, nil
+	}
+
+	return &andNode{children: children}, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.isEnd() {
+		return nil, p.errorf("unexpected end of query")
+	}
+
+	token := p.peek()
+	switch token.kind {
+	case queryTokenNot:
+		p.next++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{child: child}, nil
+
+	case queryTokenOpen:
+		p.next++
+		child, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.isEnd() || p.peek().kind != queryTokenClose {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		p.next++
+		return child, nil
+
+	case queryTokenTag:
+		p.next++
+		return &tagNode{tag: token.text}, nil
+
+	default:
+		return nil, p.errorf("unexpected `%s`", token.text)
+	}
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) isEnd() bool {
+	return p.next >= len(p.tokens)
+}
+
+func (p *queryParser) errorf(format string, a ...any) error {
+	position := len([]rune(p.text))
+	if !p.isEnd() {
+		position = p.peek().position
+	}
+
+	return &QuerySyntaxError{Query: p.text, Position: position, Reason: fmt.Sprintf(format, a...)}
+}
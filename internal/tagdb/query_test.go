@@ -0,0 +1,89 @@
+package tagdb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func Test_parseQuery_EvaluatesAgainstIndex(t *testing.T) {
+	// Arrange
+	db := newInMemStore()
+	db.apply([]operator{
+		&setOperation{transactionId: "tx1", key: "key-1", value: "value-1"},
+		&setOperation{transactionId: "tx1", key: "key-2", value: "value-2"},
+		&setOperation{transactionId: "tx1", key: "key-3", value: "value-3"},
+		&setOperation{transactionId: "tx1", key: "key-4", value: "value-4"},
+		&tagOperation{transactionId: "tx1", key: "key-1", tag: "work"},
+		&tagOperation{transactionId: "tx1", key: "key-1", tag: "urgent"},
+		&tagOperation{transactionId: "tx1", key: "key-2", tag: "work"},
+		&tagOperation{transactionId: "tx1", key: "key-2", tag: "blocked"},
+		&tagOperation{transactionId: "tx1", key: "key-2", tag: "archived"},
+		&tagOperation{transactionId: "tx1", key: "key-3", tag: "work"},
+		&tagOperation{transactionId: "tx1", key: "key-4", tag: "urgent"},
+	})
+
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "work", expected: []string{"key-1", "key-2", "key-3"}},
+		{query: "work AND urgent", expected: []string{"key-1"}},
+		{query: "work OR urgent", expected: []string{"key-1", "key-2", "key-3", "key-4"}},
+		{query: "NOT work", expected: []string{"key-4"}},
+		{query: "work AND NOT archived", expected: []string{"key-1", "key-3"}},
+		{query: "work AND (urgent OR blocked) AND NOT archived", expected: []string{"key-1"}},
+		{query: "urgent OR work AND blocked", expected: []string{"key-1", "key-2", "key-4"}},
+		{query: "NOT (work OR urgent)", expected: []string{}},
+		{query: "unknown", expected: []string{}},
+	}
+
+	for _, testCase := range testCases {
+		// Act
+		query, err := parseQuery(testCase.query)
+		if err != nil {
+			t.Errorf("unexpected error parsing `%s`: %v", testCase.query, err)
+			continue
+		}
+
+		var actual []string
+		for key := range query.eval(db) {
+			actual = append(actual, key)
+		}
+		slices.Sort(actual)
+
+		// Assert
+		if !slices.Equal(actual, testCase.expected) && !(len(actual) == 0 && len(testCase.expected) == 0) {
+			t.Errorf("query `%s` returned %v, expected %v", testCase.query, actual, testCase.expected)
+		}
+
+		for _, key := range []string{"key-1", "key-2", "key-3", "key-4"} {
+			taggedKV, _ := db.get(key)
+			if query.matches(taggedKV) != slices.Contains(testCase.expected, key) {
+				t.Errorf("query `%s` matches disagrees with eval for key `%s`", testCase.query, key)
+			}
+		}
+	}
+}
+
+func Test_parseQuery_ShouldRejectInvalidQueries(t *testing.T) {
+	testCases := []string{
+		"",
+		"work AND",
+		"(work OR urgent",
+		"work urgent",
+		"work OR OR urgent",
+		"Work",
+		"work )",
+		".deleted",
+	}
+
+	for _, testCase := range testCases {
+		_, err := parseQuery(testCase)
+
+		var syntaxErr *QuerySyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("expected syntax error for `%s`, but got %v", testCase, err)
+		}
+	}
+}
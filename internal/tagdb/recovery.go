@@ -0,0 +1,418 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// Databases opened at recovery points are cached, as each is rebuilt by replaying the wal.
+	pointInTimeCacheSize = 4
+
+	// Limits how many recovery points are rebuilt at once.
+	maxConcurrentOpenAt = 2
+
+	// Times closer to now than this are not cached, as commits stamped before them may still be
+	// reaching the wal.
+	pointInTimeCacheMinAge = time.Minute
+)
+
+// Returned by OpenAt when a recovery point cannot be reached.  Either the transaction does not
+// exist, or the wal files needed to replay up to the point were removed by compaction.
+var ErrRecoveryPointNotFound = errors.New("recovery point not found")
+
+/*
+A point in the history of the database, used by OpenAt.
+
+Set one of:
+
+  - TransactionId | Includes every transaction committed up to, and including, this one.
+  - Time          | Includes every transaction committed at or before this time.
+*/
+type RecoveryPoint struct {
+	TransactionId string
+	Time          time.Time
+}
+
+// A recovery point that includes every transaction up to, and including, the given transaction.
+func AtTransaction(transactionId string) RecoveryPoint {
+	return RecoveryPoint{TransactionId: transactionId}
+}
+
+// A recovery point that includes every transaction committed at or before the given time.
+func AtTime(t time.Time) RecoveryPoint {
+	return RecoveryPoint{Time: t}
+}
+
+// Parses a recovery point from a transaction id, or an RFC3339 timestamp.
+func ParseRecoveryPoint(value string) (RecoveryPoint, error) {
+	if err := uuid.Validate(value); err == nil {
+		return AtTransaction(value), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return RecoveryPoint{}, fmt.Errorf(
+			"invalid recovery point `%s`, expected a transaction id or RFC3339 timestamp",
+			value)
+	}
+
+	return AtTime(t), nil
+}
+
+func (point RecoveryPoint) String() string {
+	if point.TransactionId != "" {
+		return "transaction " + point.TransactionId
+	}
+
+	return formatTimestamp(point.Time)
+}
+
+func (point RecoveryPoint) validate() error {
+	hasTransaction := point.TransactionId != ""
+	hasTime := !point.Time.IsZero()
+	if hasTransaction == hasTime {
+		return fmt.Errorf("recovery point requires either a transaction id or a time")
+	}
+
+	return nil
+}
+
+// Returns the key a recovery point is cached by.  Only points that cannot change are cached, which
+// are transactions, and times far enough in the past.
+func (point RecoveryPoint) cacheKey() (string, bool) {
+	if point.TransactionId != "" {
+		return point.TransactionId, true
+	}
+
+	if time.Since(point.Time) < pointInTimeCacheMinAge {
+		return "", false
+	}
+
+	return formatTimestamp(point.Time), true
+}
+
+// Caches databases opened at recovery points, and limits how many are rebuilt at once.
+type pointInTimeCache struct {
+	mu    sync.Mutex
+	items map[string]*pointInTimeDb
+
+	// Cache keys, least recently used first.
+	order []string
+
+	// Holds a slot for each recovery point being rebuilt.
+	opening chan struct{}
+}
+
+func newPointInTimeCache() *pointInTimeCache {
+	return &pointInTimeCache{
+		items:   map[string]*pointInTimeDb{},
+		opening: make(chan struct{}, maxConcurrentOpenAt),
+	}
+}
+
+// Returns the database at a recovery point from the cache, otherwise opens and caches it.
+func (c *pointInTimeCache) get(
+	point RecoveryPoint,
+	open func() (*pointInTimeDb, error),
+) (*pointInTimeDb, error) {
+	key, cacheable := point.cacheKey()
+	if !cacheable {
+		c.opening <- struct{}{}
+		defer func() { <-c.opening }()
+
+		return open()
+	}
+
+	if db, found := c.lookup(key); found {
+		return db, nil
+	}
+
+	c.opening <- struct{}{}
+	defer func() { <-c.opening }()
+
+	// Another request may have opened the same point while this one waited.
+	if db, found := c.lookup(key); found {
+		return db, nil
+	}
+
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items[key] = db
+	c.order = append(c.order, key)
+	if len(c.order) > pointInTimeCacheSize {
+		delete(c.items, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	return db, nil
+}
+
+// Returns a cached database, and marks it as recently used.
+func (c *pointInTimeCache) lookup(key string) (*pointInTimeDb, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	db, found := c.items[key]
+	if found {
+		i := slices.Index(c.order, key)
+		c.order = append(slices.Delete(c.order, i, i+1), key)
+	}
+
+	return db, found
+}
+
+// A read-only copy of the database, as it was at a recovery point.
+type pointInTimeDb struct {
+	storage *storage
+
+	// Expiry is evaluated at the recovery point, rather than now.
+	now time.Time
+}
+
+/*
+Opens a read-only copy of the database, as it was at a recovery point.
+
+The copy is rebuilt by replaying the wal, and does not change any files.  So it can be opened while
+the database is running, for example to recover records after a bad bulk edit.
+
+Points before the newest snapshot can only be reached when every wal file is still available,
+which requires WithWalArchive, enabled by default.  Otherwise ErrRecoveryPointNotFound is returned.
+*/
+func OpenAt(root string, point RecoveryPoint, configOptions ...dbConfigurer) (*pointInTimeDb, error) {
+	config := newDbConfig(configOptions...)
+	return openAt(config.fsys, config.keys, root, point)
+}
+
+func openAt(fsys fileSystem, keys *keyring, root string, point RecoveryPoint) (*pointInTimeDb, error) {
+	logger.Infof("opening database at %s", point)
+
+	// Validation.
+	if err := point.validate(); err != nil {
+		return nil, err
+	}
+
+	// Find wal files.  Archived files are only used where the wal directory does not have them.
+	walPaths, err := listWalFiles(fsys, path.Join(root, "archive"))
+	if err != nil {
+		return nil, logger.Errorf("cannot list archived wal files because %s", err)
+	}
+
+	current, err := listWalFiles(fsys, path.Join(root, "wal"))
+	if err != nil {
+		return nil, logger.Errorf("cannot list wal files because %s", err)
+	}
+	maps.Copy(walPaths, current)
+	ids := slices.Sorted(maps.Keys(walPaths))
+
+	// Replay the full history when available, otherwise start from the newest snapshot.
+	firstId := int64(0)
+	var snap *snapshot
+	if !isContiguousFrom(ids, firstId) {
+		snap, err = readLatestSnapshot(fsys, keys, path.Join(root, "snapshots"))
+		if err != nil {
+			return nil, logger.Errorf("cannot read snapshot because %s", err)
+		}
+
+		if snap == nil || !isContiguousFrom(ids, snap.WalId) {
+			return nil, fmt.Errorf("%w, because wal files are missing", ErrRecoveryPointNotFound)
+		}
+		firstId = snap.WalId
+
+		if !point.Time.IsZero() {
+			created, err := parseTimestamp(snap.Created)
+			if err != nil || point.Time.Before(created) {
+				return nil, fmt.Errorf(
+					"%w, because history before the newest snapshot was compacted",
+					ErrRecoveryPointNotFound)
+			}
+		}
+	}
+
+	var operations []operator
+	for _, id := range ids {
+		if id < firstId {
+			continue
+		}
+
+		walOps, err := readWalFile(fsys, keys, walPaths[id])
+		if err != nil {
+			return nil, logger.Errorf("cannot read wal file `%s` because %s", walPaths[id], err)
+		}
+
+		operations = append(operations, walOps...)
+	}
+
+	operations, lastCommit, found := operationsUntil(operations, point)
+	if !found {
+		return nil, fmt.Errorf("%w, transaction %s", ErrRecoveryPointNotFound, point.TransactionId)
+	}
+
+	// Rebuild the store.
+	store := newInMemStore()
+	if snap != nil {
+		store.restoreSnapshot(snap)
+	}
+	store.apply(operations)
+
+	now := point.Time
+	if now.IsZero() {
+		now = lastCommit
+	}
+
+	return &pointInTimeDb{
+		storage: &storage{fsys: fsys, keys: keys, root: root, inMemStore: store},
+		now:     now,
+	}, nil
+}
+
+// Returns the operations of transactions committed up to the recovery point, and the time of the
+// last included commit.  Found is false when the recovery point transaction was not committed.
+func operationsUntil(
+	ops []operator,
+	point RecoveryPoint,
+) (result []operator, lastCommit time.Time, found bool) {
+	pending := map[string][]operator{}
+	for _, op := range ops {
+		txId := op.getTransactionId()
+		commit, isCommit := op.(*commitOperation)
+		if !isCommit {
+			pending[txId] = append(pending[txId], op)
+			continue
+		}
+
+		// Commits written before timestamps were added have a zero time, so are always included.
+		committed, _ := parseTimestamp(commit.timestamp)
+		if !point.Time.IsZero() && committed.After(point.Time) {
+			break
+		}
+
+		result = append(result, pending[txId]...)
+		result = append(result, commit)
+		delete(pending, txId)
+		if !committed.IsZero() {
+			lastCommit = committed
+		}
+
+		if txId == point.TransactionId {
+			return result, lastCommit, true
+		}
+	}
+
+	return result, lastCommit, point.TransactionId == ""
+}
+
+// Returns the paths of the wal files in a directory, by id.  A missing directory has no files.
+func listWalFiles(fsys fileSystem, dir string) (map[int64]string, error) {
+	result := map[int64]string{}
+
+	dirEntries, err := fsys.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range dirEntries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), walFileExtension) {
+			continue
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), walFileExtension), 10, 64)
+		if err != nil {
+			logger.Warnf("skipping wal file with invalid name `%s`", entry.Name())
+			continue
+		}
+
+		result[id] = path.Join(dir, entry.Name())
+	}
+
+	return result, nil
+}
+
+// Tests if the sorted ids include every id from firstId to the last id.
+func isContiguousFrom(ids []int64, firstId int64) bool {
+	i := slices.Index(ids, firstId)
+	if i < 0 {
+		return false
+	}
+
+	for j, id := range ids[i:] {
+		if id != firstId+int64(j) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// List records by tags, as they were at the recovery point.  See db.List.
+func (db *pointInTimeDb) List(tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	page, err := db.ListPage(tags, options...)
+	return page.Items, err
+}
+
+// List a page of records by tags, as they were at the recovery point.  See db.ListPage.
+func (db *pointInTimeDb) ListPage(tags []string, options ...ReadConfigurer) (Page, error) {
+	if err := validateTags(tags); err != nil {
+		return Page{Items: []TaggedKV{}}, err
+	}
+
+	return db.storage.listPage(tags, db.withNow(options)...)
+}
+
+// Lists records in lexical key order, as they were at the recovery point.  See db.Scan.
+func (db *pointInTimeDb) Scan(prefix, start, end string, limit int, options ...ReadConfigurer) ([]TaggedKV, error) {
+	if limit < 0 {
+		return []TaggedKV{}, fmt.Errorf("limit cannot be negative")
+	}
+
+	return db.storage.scan(prefix, start, end, limit, db.withNow(options)...)
+}
+
+// Finds records with values containing the search text, as they were at the recovery point.
+// See db.Search.
+func (db *pointInTimeDb) Search(text string, tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	if err := validateTags(tags); err != nil {
+		return []TaggedKV{}, err
+	}
+
+	return db.storage.search(text, tags, db.withNow(options)...)
+}
+
+// Retrieves a record by its key, as it was at the recovery point.  See db.Get.
+func (db *pointInTimeDb) Get(key string, options ...ReadConfigurer) (taggedKv TaggedKV, found bool, err error) {
+	if err := validateKey(key); err != nil {
+		return TaggedKV{}, false, err
+	}
+
+	return db.storage.get(key, db.withNow(options)...)
+}
+
+// Evaluates expiry at the recovery point.
+func (db *pointInTimeDb) withNow(options []ReadConfigurer) []ReadConfigurer {
+	if db.now.IsZero() {
+		return options
+	}
+
+	return append(slices.Clone(options), withNow(db.now))
+}
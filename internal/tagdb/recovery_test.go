@@ -0,0 +1,284 @@
+package tagdb
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Returns the ids of committed transactions in the first wal file, in commit order.
+func committedTransactionIds(t *testing.T, storeRoot string) []string {
+	ops, err := readWalFile(osFS{}, nil, path.Join(storeRoot, "wal", "0.wal"))
+	if err != nil {
+		t.Fatalf("Failed to read wal: %v", err)
+	}
+
+	var result []string
+	for _, op := range ops {
+		if commit, isCommit := op.(*commitOperation); isCommit {
+			result = append(result, commit.transactionId)
+		}
+	}
+
+	return result
+}
+
+func Test_OpenAt_ReturnsStateAtTransaction(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.set("key-1", "value-2")
+	store.set("key-2", "value-3")
+	txIds := committedTransactionIds(t, storeRoot)
+
+	// Act.
+	db, err := OpenAt(storeRoot, AtTransaction(txIds[0]))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("OpenAt returned error: %v", err)
+	}
+
+	if item, found, _ := db.Get("key-1"); !found || item.Value != "value-1" {
+		t.Errorf("Expected key-1 to have its first value, found %+v", item)
+	}
+
+	if _, found, _ := db.Get("key-2"); found {
+		t.Errorf("Expected key-2 to not exist yet")
+	}
+}
+
+func Test_OpenAt_ReturnsStateAtTime(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	point := time.Now()
+	time.Sleep(time.Millisecond)
+	store.set("key-1", "value-2")
+	store.delete("key-1")
+
+	// Act.
+	db, err := OpenAt(storeRoot, AtTime(point))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("OpenAt returned error: %v", err)
+	}
+
+	items, _ := db.List([]string{"tag-1"})
+	if len(items) != 1 || items[0].Value != "value-1" {
+		t.Errorf("Expected key-1 with its first value, found %+v", items)
+	}
+}
+
+func Test_OpenAt_EvaluatesExpiryAtRecoveryPoint(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.setWithTTL("key-1", "value-1", 50*time.Millisecond)
+	point := time.Now()
+	time.Sleep(60 * time.Millisecond)
+
+	// Act.
+	db, err := OpenAt(storeRoot, AtTime(point))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("OpenAt returned error: %v", err)
+	}
+
+	if _, found, _ := db.Get("key-1"); !found {
+		t.Errorf("Expected key-1 to be live at the recovery point")
+	}
+}
+
+func Test_OpenAt_ShouldError_OnUnknownTransaction(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+
+	// Act.
+	_, err = OpenAt(storeRoot, AtTransaction(uuid.NewString()))
+
+	// Assert.
+	if !errors.Is(err, ErrRecoveryPointNotFound) {
+		t.Errorf("Expected ErrRecoveryPointNotFound, found %v", err)
+	}
+}
+
+func Test_OpenAt_ShouldError_WhenHistoryWasCompacted(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	point := time.Now()
+	store.set("key-1", "value-1")
+	store.snapshot(false)
+	store.set("key-2", "value-2")
+
+	// Act.
+	_, beforeErr := OpenAt(storeRoot, AtTime(point))
+	db, afterErr := OpenAt(storeRoot, AtTime(time.Now()))
+
+	// Assert.
+	if !errors.Is(beforeErr, ErrRecoveryPointNotFound) {
+		t.Errorf("Expected ErrRecoveryPointNotFound before the snapshot, found %v", beforeErr)
+	}
+
+	if afterErr != nil {
+		t.Fatalf("Expected points after the snapshot to open, found %v", afterErr)
+	}
+
+	if items, _ := db.List([]string{}); len(items) != 2 {
+		t.Errorf("Expected 2 items after the snapshot, found %+v", items)
+	}
+}
+
+func Test_OpenAt_ReplaysArchivedWalFiles(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	txIds := committedTransactionIds(t, storeRoot)
+	store.set("key-1", "value-2")
+	store.snapshot(true)
+
+	// Act.
+	db, err := OpenAt(storeRoot, AtTransaction(txIds[0]))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("OpenAt returned error: %v", err)
+	}
+
+	if item, found, _ := db.Get("key-1"); !found || item.Value != "value-1" {
+		t.Errorf("Expected key-1 to have its first value, found %+v", item)
+	}
+}
+
+func Test_pointInTimeCache_get_ReusesOpenedPoints(t *testing.T) {
+	// Arrange.
+	cache := newPointInTimeCache()
+	point := AtTransaction(uuid.NewString())
+	opened := 0
+	open := func() (*pointInTimeDb, error) {
+		opened++
+		return &pointInTimeDb{}, nil
+	}
+
+	// Act.
+	first, _ := cache.get(point, open)
+	second, _ := cache.get(point, open)
+
+	// Assert.
+	if opened != 1 || first != second {
+		t.Errorf("Expected the point to be opened once and reused, opened %d times", opened)
+	}
+}
+
+func Test_pointInTimeCache_get_EvictsLeastRecentlyUsed(t *testing.T) {
+	// Arrange.
+	cache := newPointInTimeCache()
+	open := func() (*pointInTimeDb, error) { return &pointInTimeDb{}, nil }
+	first := AtTransaction(uuid.NewString())
+	cache.get(first, open)
+	for range pointInTimeCacheSize {
+		cache.get(first, open)
+		cache.get(AtTransaction(uuid.NewString()), open)
+	}
+
+	// Act.
+	for range pointInTimeCacheSize {
+		cache.get(AtTransaction(uuid.NewString()), open)
+	}
+
+	// Assert.
+	if len(cache.items) != pointInTimeCacheSize || len(cache.order) != pointInTimeCacheSize {
+		t.Errorf("Expected %d cached points, found %d", pointInTimeCacheSize, len(cache.items))
+	}
+
+	if _, found := cache.items[first.TransactionId]; found {
+		t.Errorf("Expected the least recently used point to be evicted")
+	}
+}
+
+func Test_pointInTimeCache_get_DoesNotCacheRecentTimes(t *testing.T) {
+	// Arrange.
+	cache := newPointInTimeCache()
+	point := AtTime(time.Now())
+	opened := 0
+	open := func() (*pointInTimeDb, error) {
+		opened++
+		return &pointInTimeDb{}, nil
+	}
+
+	// Act.
+	cache.get(point, open)
+	cache.get(point, open)
+
+	// Assert.
+	if opened != 2 {
+		t.Errorf("Expected recent times to be opened on every call, opened %d times", opened)
+	}
+}
+
+func Test_ParseRecoveryPoint(t *testing.T) {
+	txId := uuid.NewString()
+	testCases := []struct {
+		value    string
+		expected RecoveryPoint
+		isValid  bool
+	}{
+		{txId, AtTransaction(txId), true},
+		{"2025-01-02T03:04:05Z", AtTime(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)), true},
+		{"yesterday", RecoveryPoint{}, false},
+	}
+
+	for _, testCase := range testCases {
+		actual, err := ParseRecoveryPoint(testCase.value)
+		if (err == nil) != testCase.isValid {
+			t.Errorf("unexpected error for `%s`: %v", testCase.value, err)
+			continue
+		}
+
+		if actual.TransactionId != testCase.expected.TransactionId || !actual.Time.Equal(testCase.expected.Time) {
+			t.Errorf("unexpected recovery point for `%s`: %+v", testCase.value, actual)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package tagdb
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+/*
+Rewrites the wal files, archived wal files and snapshots in a storage root that are not encrypted
+with the active key, as set by WithEncryptionKeys.  Returns the number of files rewritten.
+
+Run after rotating keys, so the old key can be removed, or after enabling encryption, so existing
+files are encrypted.  Without keys, encrypted files are decrypted.  Each file is replaced
+atomically, so an interrupted run can be repeated.  The database must not be running on the root.
+*/
+func Reencrypt(root string, configOptions ...dbConfigurer) (int, error) {
+	config := newDbConfig(configOptions...)
+	return reencrypt(config.fsys, config.keys, root)
+}
+
+func reencrypt(fsys fileSystem, keys *keyring, root string) (int, error) {
+	logger.Infof("re-encrypting storage root `%s`", root)
+
+	count := 0
+	for _, dir := range []string{path.Join(root, "wal"), path.Join(root, "archive")} {
+		walPaths, err := listWalFiles(fsys, dir)
+		if err != nil {
+			return count, logger.Errorf("cannot list wal files because %s", err)
+		}
+
+		for _, id := range slices.Sorted(maps.Keys(walPaths)) {
+			rewritten, err := reencryptWalFile(fsys, keys, walPaths[id])
+			if err != nil {
+				return count, logger.Errorf("cannot re-encrypt wal file `%s` because %s", walPaths[id], err)
+			}
+
+			if rewritten {
+				count++
+			}
+		}
+	}
+
+	snapshotDir := path.Join(root, "snapshots")
+	ids, err := listSnapshots(fsys, snapshotDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return count, logger.Errorf("cannot list snapshots because %s", err)
+	}
+
+	for _, id := range ids {
+		snapshotPath := path.Join(snapshotDir, strconv.FormatInt(id, 10)+snapshotFileExtension)
+		snap, c, err := readSnapshotFile(fsys, keys, snapshotPath)
+		if err != nil {
+			return count, logger.Errorf("cannot re-encrypt snapshot because %s", err)
+		}
+
+		if keys.isActive(c) {
+			continue
+		}
+
+		if err := writeSnapshot(fsys, keys, snapshotDir, snap); err != nil {
+			return count, logger.Errorf("cannot re-encrypt snapshot `%s` because %s", snapshotPath, err)
+		}
+		count++
+	}
+
+	logger.Infof("re-encrypted %d file(s)", count)
+	return count, nil
+}
+
+// Rewrites a wal file with the active key, unless it already uses it.
+func reencryptWalFile(fsys fileSystem, keys *keyring, walPath string) (bool, error) {
+	data, err := fsys.ReadFile(walPath)
+	if err != nil {
+		return false, err
+	}
+
+	if data, err = decompressWal(data); err != nil {
+		return false, err
+	}
+
+	header, err := parseWalHeader(data, keys)
+	if err != nil {
+		return false, err
+	}
+
+	if keys.isActive(header.cipher) && header.isWritable() {
+		return false, nil
+	}
+
+	ops, _, err := parseWal(walPath, header, data)
+	if err != nil {
+		return false, err
+	}
+
+	return true, writeWalFile(fsys, keys, walPath, ops)
+}
@@ -0,0 +1,72 @@
+package tagdb
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+// Splits free text into lowercase search terms.
+// Terms are runs of letters and numbers.  Duplicates are removed.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	slices.Sort(fields)
+	return slices.Compact(fields)
+}
+
+// Adds the terms of a value to the full-text index.
+func (db *inMemStore) indexTerms(key, value string) {
+	for _, term := range tokenize(value) {
+		db.terms.Add(key, term)
+	}
+}
+
+// Removes all terms of a record from the full-text index.
+func (db *inMemStore) unindexTerms(key string) {
+	for _, term := range db.terms.GetValues(key) {
+		db.terms.Remove(key, term)
+	}
+}
+
+// Finds records with values containing any of the search terms.
+// Results are ranked by the number of matching terms, then by key.
+func (db *inMemStore) search(text string, include func(TaggedKV) bool) []TaggedKV {
+	logger.Infof("in-mem search for `%s`", text)
+
+	// Score each key by the number of matching terms.
+	scores := map[string]int{}
+	for _, term := range tokenize(text) {
+		for _, key := range db.terms.GetKeys(term) {
+			scores[key]++
+		}
+	}
+
+	result := []TaggedKV{}
+	for key := range scores {
+		value, found := db.data[key]
+		if !found {
+			continue
+		}
+
+		taggedKV := db.toTaggedKV(key, value)
+		if include(taggedKV) {
+			result = append(result, taggedKV)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b TaggedKV) int {
+		if byScore := cmp.Compare(scores[b.Key], scores[a.Key]); byScore != 0 {
+			return byScore
+		}
+
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	return result
+}
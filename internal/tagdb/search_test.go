@@ -0,0 +1,85 @@
+package tagdb
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_tokenize_SplitsOnNonAlphanumerics(t *testing.T) {
+	actual := tokenize("Meeting notes: https://example.com/notes?id=42, meeting ROOM 4b")
+	expected := []string{"42", "4b", "com", "example", "https", "id", "meeting", "notes", "room"}
+
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func Test_InMemStore_search_RanksByMatchingTerms(t *testing.T) {
+	// Arrange
+	db := newInMemStore()
+	db.apply([]operator{
+		&setOperation{transactionId: "tx1", key: "key-1", value: "weekly team meeting"},
+		&setOperation{transactionId: "tx1", key: "key-2", value: "team lunch"},
+		&setOperation{transactionId: "tx1", key: "key-3", value: "Weekly Team Meeting notes"},
+		&setOperation{transactionId: "tx1", key: "key-4", value: "unrelated"},
+		&setOperation{transactionId: "tx1", key: "key-5", value: "team offsite"},
+		&setOperation{transactionId: "tx1", key: "key-5", value: "replaced"},
+		&setOperation{transactionId: "tx1", key: "key-6", value: "team"},
+		&deleteOperation{transactionId: "tx1", key: "key-6"},
+	})
+	includeAll := func(TaggedKV) bool { return true }
+
+	// Act
+	taggedKVs := db.search("team meeting", includeAll)
+
+	// Assert
+	var actual []string
+	for _, taggedKV := range taggedKVs {
+		actual = append(actual, taggedKV.Key)
+	}
+
+	expected := []string{"key-1", "key-3", "key-2"}
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func Test_storage_search_RebuildsIndexOnReopen(t *testing.T) {
+	// Arrange
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "release checklist")
+	store.set("key-2", "release notes")
+	store.tag("key-2", "docs")
+	store.close()
+
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	// Act
+	all, err := store.search("release", []string{})
+	if err != nil {
+		t.Fatalf("search returned error: %v", err)
+	}
+
+	tagged, err := store.search("release", []string{"docs"})
+	if err != nil {
+		t.Fatalf("search returned error: %v", err)
+	}
+
+	// Assert
+	if len(all) != 2 {
+		t.Errorf("expected 2 results after reopen, got %+v", all)
+	}
+
+	if len(tagged) != 1 || tagged[0].Key != "key-2" {
+		t.Errorf("expected tag filter to return key-2 only, got %+v", tagged)
+	}
+}
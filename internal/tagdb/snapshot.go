@@ -0,0 +1,317 @@
+package tagdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+const (
+	snapshotFileExtension = ".snapshot"
+	snapshotTempExtension = ".tmp"
+	snapshotFormatVersion = 1
+
+	// Encrypted snapshots start with this magic, followed by the encryption version, the encryption
+	// header, and the sealed JSON.  The magic, version and encryption header are authenticated.
+	snapshotEncryptedMagic   = "TAGDBSNP"
+	snapshotEncryptedVersion = 1
+)
+
+/*
+A point-in-time copy of the in-mem store.
+
+Snapshots are named after the first WAL segment they do not cover.  So `7.snapshot` contains every
+transaction committed to segments 0 to 6, and start-up replays segments 7 onwards.
+
+Snapshots are JSON, encrypted with the active key when encryption is configured.
+*/
+type snapshot struct {
+	Format   int              `json:"format"`
+	WalId    int64            `json:"walId"`
+	Sequence uint64           `json:"sequence"`
+	Created  string           `json:"created,omitempty"`
+	Records  []snapshotRecord `json:"records"`
+
+	// Tags with metadata.  Missing from snapshots written before tag metadata was added.
+	TagMetadata []snapshotTagMetadata `json:"tagMetadata,omitempty"`
+
+	// Record history, sorted by key.  Missing from snapshots written before history was kept.
+	History []snapshotHistory `json:"history,omitempty"`
+}
+
+type snapshotHistory struct {
+	Key     string         `json:"key"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+type snapshotTagMetadata struct {
+	Tag         string `json:"tag"`
+	Description string `json:"description,omitempty"`
+	Colour      string `json:"colour,omitempty"`
+}
+
+type snapshotRecord struct {
+	Key     string            `json:"key"`
+	Value   string            `json:"value"`
+	Tags    []string          `json:"tags,omitempty"`
+	System  map[string]string `json:"system,omitempty"`
+	Expires string            `json:"expires,omitempty"`
+	Version uint64            `json:"version,omitempty"`
+}
+
+// Copies the state of the store.  The caller must hold the storage lock.
+func (db *inMemStore) snapshot(walId int64) *snapshot {
+	result := &snapshot{
+		Format:   snapshotFormatVersion,
+		WalId:    walId,
+		Sequence: db.sequence,
+		Created:  formatTimestamp(time.Now()),
+		Records:  make([]snapshotRecord, 0, len(db.data)),
+	}
+
+	for key := range db.keys.All() {
+		record := snapshotRecord{
+			Key:     key,
+			Value:   db.data[key],
+			Tags:    db.index.GetValues(key),
+			System:  maps.Clone(db.system[key]),
+			Version: db.versions[key],
+		}
+
+		if expires, found := db.expires[key]; found {
+			record.Expires = formatTimestamp(expires)
+		}
+
+		result.Records = append(result.Records, record)
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(db.tagMetadata)) {
+		metadata := db.tagMetadata[tag]
+		result.TagMetadata = append(result.TagMetadata, snapshotTagMetadata{
+			Tag:         tag,
+			Description: metadata.Description,
+			Colour:      metadata.Colour,
+		})
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(db.history)) {
+		result.History = append(result.History, snapshotHistory{Key: key, Entries: db.getHistory(key)})
+	}
+
+	return result
+}
+
+// Loads the state of an empty store from a snapshot.
+func (db *inMemStore) restoreSnapshot(snap *snapshot) {
+	logger.Infof("restoring %d record(s) from snapshot %d", len(snap.Records), snap.WalId)
+
+	var operations []operator
+	for _, record := range snap.Records {
+		operations = append(operations, &setOperation{key: record.Key, value: record.Value})
+
+		for _, tag := range record.Tags {
+			operations = append(operations, &tagOperation{key: record.Key, tag: tag})
+		}
+
+		for _, tag := range slices.Sorted(maps.Keys(record.System)) {
+			operations = append(operations, &systemTagOperation{key: record.Key, tag: tag, value: record.System[tag]})
+		}
+
+		if record.Expires != "" {
+			operations = append(operations, &expireOperation{key: record.Key, expires: record.Expires})
+		}
+	}
+
+	for _, metadata := range snap.TagMetadata {
+		operations = append(operations, &tagMetadataOperation{
+			tag:         metadata.Tag,
+			description: metadata.Description,
+			colour:      metadata.Colour,
+		})
+	}
+	db.apply(operations)
+
+	// Versions and history come from the snapshot, rather than the restore.
+	clear(db.uncommitted)
+	db.pendingHistory = nil
+	db.sequence = snap.Sequence
+	for _, record := range snap.Records {
+		if record.Version > 0 {
+			db.versions[record.Key] = record.Version
+		}
+	}
+
+	for _, history := range snap.History {
+		db.history[history.Key] = history.Entries
+	}
+}
+
+// Writes a snapshot atomically.  A partially written snapshot is never visible under its final name.
+func writeSnapshot(fsys fileSystem, keys *keyring, snapshotDir string, snap *snapshot) error {
+	data, err := encodeSnapshot(keys.activeCipher(), snap)
+	if err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(snap.WalId, 10) + snapshotFileExtension
+	finalPath := path.Join(snapshotDir, name)
+	tempPath := finalPath + snapshotTempExtension
+
+	file, err := fsys.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, writeErr := file.Write(data)
+	syncErr := file.Sync()
+	closeErr := file.Close()
+	if err := errors.Join(writeErr, syncErr, closeErr); err != nil {
+		fsys.Remove(tempPath)
+		return err
+	}
+
+	if err := fsys.Rename(tempPath, finalPath); err != nil {
+		fsys.Remove(tempPath)
+		return err
+	}
+
+	return fsys.SyncDir(snapshotDir)
+}
+
+// Reads the newest snapshot.  Returns nil when there are no snapshots.
+func readLatestSnapshot(fsys fileSystem, keys *keyring, snapshotDir string) (*snapshot, error) {
+	ids, err := listSnapshots(fsys, snapshotDir)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	latestId := ids[len(ids)-1]
+	snapshotPath := path.Join(snapshotDir, strconv.FormatInt(latestId, 10)+snapshotFileExtension)
+	snap, _, err := readSnapshotFile(fsys, keys, snapshotPath)
+	return snap, err
+}
+
+// Reads a snapshot file.  Returns the cipher it was encrypted with, or nil for plain text.
+func readSnapshotFile(fsys fileSystem, keys *keyring, snapshotPath string) (*snapshot, *encryptionCipher, error) {
+	data, err := fsys.ReadFile(snapshotPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, data, err := decryptSnapshot(keys, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read snapshot `%s` because %w", snapshotPath, err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, nil, fmt.Errorf("cannot read snapshot `%s` because %w", snapshotPath, err)
+	}
+
+	if snap.Format != snapshotFormatVersion {
+		return nil, nil, fmt.Errorf("cannot read snapshot `%s` with unsupported format %d", snapshotPath, snap.Format)
+	}
+
+	return &snap, c, nil
+}
+
+// Serializes a snapshot, encrypting it when the cipher is set.
+func encodeSnapshot(c *encryptionCipher, snap *snapshot) ([]byte, error) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize snapshot because %w", err)
+	}
+
+	if c == nil {
+		return data, nil
+	}
+
+	header := c.appendHeader(append([]byte(snapshotEncryptedMagic), snapshotEncryptedVersion))
+	return append(header, c.seal(data, header)...), nil
+}
+
+// Decrypts an encrypted snapshot.  Plain text snapshots are returned unchanged, with a nil cipher.
+func decryptSnapshot(keys *keyring, data []byte) (*encryptionCipher, []byte, error) {
+	if !strings.HasPrefix(string(data[:min(len(data), len(snapshotEncryptedMagic))]), snapshotEncryptedMagic) {
+		return nil, data, nil
+	}
+
+	offset := len(snapshotEncryptedMagic) + 1
+	if len(data) < offset {
+		return nil, nil, fmt.Errorf("encrypted snapshot header is incomplete")
+	}
+
+	if version := data[offset-1]; version != snapshotEncryptedVersion {
+		return nil, nil, fmt.Errorf("unsupported snapshot encryption version %d", version)
+	}
+
+	c, size, err := parseEncryptionHeader(data[offset:], keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := data[:offset+size]
+	plaintext, err := c.open(data[offset+size:], header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, plaintext, nil
+}
+
+// Removes snapshots older than the given WAL id.
+func removeSnapshotsBefore(fsys fileSystem, snapshotDir string, walId int64) error {
+	ids, err := listSnapshots(fsys, snapshotDir)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id >= walId {
+			continue
+		}
+
+		snapshotPath := path.Join(snapshotDir, strconv.FormatInt(id, 10)+snapshotFileExtension)
+		if err := fsys.Remove(snapshotPath); err != nil {
+			return err
+		}
+		logger.Infof("removed snapshot `%s`", snapshotPath)
+	}
+
+	return nil
+}
+
+// Returns the ids of all snapshots, in ascending order.
+func listSnapshots(fsys fileSystem, snapshotDir string) ([]int64, error) {
+	dirEntries, err := fsys.ReadDir(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for _, entry := range dirEntries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotFileExtension) {
+			continue
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), snapshotFileExtension), 10, 64)
+		if err != nil {
+			logger.Warnf("skipping snapshot with invalid name `%s`", entry.Name())
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+	return ids, nil
+}
@@ -0,0 +1,177 @@
+package tagdb
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_storage_snapshot_RestoresStateAfterReopen(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value one")
+	store.tag("key-1", "tag-1")
+	store.setWithTTL("key-2", "value-2", time.Hour)
+	store.set("key-3", "value-3")
+	store.delete("key-3")
+	before, _ := store.list([]string{}, WithDeleted())
+
+	// Act.
+	if err := store.snapshot(false); err != nil {
+		t.Fatalf("snapshot returned error: %v", err)
+	}
+	store.set("key-4", "value-4")
+	store.close()
+
+	// Assert.
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	after, _ := store.list([]string{}, WithDeleted())
+	if len(after) != len(before)+1 {
+		t.Fatalf("Expected %d items after reopen, but found %+v", len(before)+1, after)
+	}
+
+	for i, expected := range before {
+		actual := after[i]
+		if actual.Key != expected.Key || actual.Value != expected.Value || actual.Version != expected.Version ||
+			!slices.Equal(actual.Tags, expected.Tags) || !actual.Created.Equal(expected.Created) ||
+			!actual.Deleted.Equal(expected.Deleted) || !actual.Expires.Equal(expected.Expires) {
+			t.Fatalf("Item mismatch after reopen:\nexpected %+v\nactual   %+v", expected, actual)
+		}
+	}
+
+	if latest := after[len(after)-1]; latest.Key != "key-4" || latest.Version <= before[len(before)-1].Version {
+		t.Fatalf("Expected key-4 to be replayed with a later version: %+v", latest)
+	}
+
+	if results := store.inMemStore.search("one", func(TaggedKV) bool { return true }); len(results) != 1 {
+		t.Fatalf("Expected search index to be restored: %+v", results)
+	}
+}
+
+func Test_storage_snapshot_RemovesCoveredWalFiles(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.snapshot(false)
+	store.set("key-2", "value-2")
+
+	// Act.
+	if err := store.snapshot(true); err != nil {
+		t.Fatalf("snapshot returned error: %v", err)
+	}
+
+	// Assert.
+	walIds := store.walManager.idsFrom(0)
+	if !slices.Equal(walIds, []int64{2}) {
+		t.Fatalf("Expected only the current wal file to remain, but found %v", walIds)
+	}
+
+	if _, err := os.Stat(path.Join(storeRoot, "wal", "0.wal")); !os.IsNotExist(err) {
+		t.Fatalf("Expected wal file 0 to be deleted")
+	}
+
+	if _, err := os.Stat(path.Join(storeRoot, "archive", "1.wal")); err != nil {
+		t.Fatalf("Expected wal file 1 to be archived: %v", err)
+	}
+
+	snapshots, _ := listSnapshots(osFS{}, path.Join(storeRoot, "snapshots"))
+	if !slices.Equal(snapshots, []int64{2}) {
+		t.Fatalf("Expected only the newest snapshot to remain, but found %v", snapshots)
+	}
+}
+
+func Test_storage_snapshot_RetainsConcurrentCommitsAfterReopen(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	const writers, writes = 8, 50
+
+	// Act.
+	var wg sync.WaitGroup
+	acknowledged := make(chan string, writers*writes)
+	errs := make(chan error, writers*writes)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if _, err := store.set(key, "value"); err != nil {
+					errs <- err
+					continue
+				}
+				acknowledged <- key
+			}
+		}()
+	}
+
+	// Roll and snapshot until the writers finish.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+		default:
+			store.maybeRoll(0)
+			if err := store.snapshot(i%2 == 0); err != nil {
+				t.Errorf("snapshot returned error: %v", err)
+			}
+			continue
+		}
+		break
+	}
+	close(acknowledged)
+	close(errs)
+	store.close()
+
+	// Assert.
+	for err := range errs {
+		t.Errorf("set returned error: %v", err)
+	}
+
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	count := 0
+	for key := range acknowledged {
+		count++
+		if _, found, _ := store.get(key); !found {
+			t.Errorf("expected acknowledged key `%s` after reopen", key)
+		}
+	}
+
+	if count != writers*writes {
+		t.Errorf("expected %d acknowledged writes, found %d", writers*writes, count)
+	}
+}
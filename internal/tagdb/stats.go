@@ -0,0 +1,44 @@
+package tagdb
+
+// Storage statistics, returned by db.Stats.
+type Stats struct {
+	// Records in the store, including records in the trash.
+	Records int `json:"records"`
+
+	// Wal files in the wal directory.  Archived files are not included.
+	WalFiles int `json:"walFiles"`
+
+	// Wal files that have been compressed.
+	CompressedWalFiles int `json:"compressedWalFiles"`
+
+	// Size of the wal files on disk.
+	WalBytes int64 `json:"walBytes"`
+
+	// Disk space saved by compressing wal files.
+	WalBytesSaved int64 `json:"walBytesSaved"`
+}
+
+func (s *storage) stats() (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := Stats{
+		Records:  len(s.inMemStore.data),
+		WalFiles: len(s.walManager.walFiles),
+	}
+
+	for _, wal := range s.walManager.walFiles {
+		size, uncompressedSize, err := wal.sizes()
+		if err != nil {
+			return Stats{}, err
+		}
+
+		result.WalBytes += size
+		if wal.header.compressed {
+			result.CompressedWalFiles++
+			result.WalBytesSaved += uncompressedSize - size
+		}
+	}
+
+	return result, nil
+}
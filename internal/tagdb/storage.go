@@ -4,40 +4,79 @@ import (
 	"errors"
 	"fmt"
 	"path"
-	"slices"
 	"sync"
+	"time"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
 )
 
 // Write-ahead log.
 type storage struct {
-	root       string
-	walDir     string
-	inMemStore *inMemStore
-	walManager *walManager
-	mu         sync.RWMutex
+	fsys        fileSystem
+	keys        *keyring
+	root        string
+	walDir      string
+	snapshotDir string
+	archiveDir  string
+	inMemStore  *inMemStore
+	walManager  *walManager
+	committer   *groupCommitter
+	feed        *changeFeed
+	mu          sync.RWMutex
+
+	// The commit sequence and time of the last snapshot.
+	snapshotSequence uint64
+	snapshotTime     time.Time
 }
 
-func openStorage(root string) (*storage, error) {
+// Opens the storage root.  Files are encrypted with the active key when keys is set.
+func openStorage(fsys fileSystem, keys *keyring, root string) (*storage, error) {
 	// Ensure wal dir exists.
 	walDir := path.Join(root, "wal")
-	if err := createDirIfNotExists(walDir); err != nil {
+	if err := createDirIfNotExists(fsys, walDir); err != nil {
 		innerErr := logger.Error("cannot open wal directory")
 		return nil, errors.Join(innerErr, err)
 	}
 
+	// Ensure snapshot dir exists.
+	snapshotDir := path.Join(root, "snapshots")
+	if err := createDirIfNotExists(fsys, snapshotDir); err != nil {
+		innerErr := logger.Error("cannot open snapshot directory")
+		return nil, errors.Join(innerErr, err)
+	}
+
+	// Load the newest snapshot.
+	inMemStore := newInMemStore()
+	firstWalId := int64(0)
+	snap, err := readLatestSnapshot(fsys, keys, snapshotDir)
+	if err != nil {
+		innerErr := logger.Error("cannot read snapshot")
+		return nil, errors.Join(innerErr, err)
+	}
+
+	if snap != nil {
+		inMemStore.restoreSnapshot(snap)
+		firstWalId = snap.WalId
+	}
+
 	// Get current wal file name.
-	walManager, err := newWalManager(walDir)
+	walManager, err := newWalManager(fsys, keys, walDir, firstWalId)
 	if err != nil {
 		innerErr := logger.Error("cannot create wal manager")
 		return nil, errors.Join(innerErr, err)
 	}
 
-	// Rehydrate in-mem store from wals.
+	// Start a new wal file when the active key has changed, so new commits use the active key.
+	// Compressed files are sealed, so also need a new file.
+	if current := walManager.current().header; !keys.isActive(current.cipher) || current.compressed {
+		logger.Info("rolling wal to a new file")
+		walManager.roll()
+	}
+
+	// Rehydrate in-mem store from wals not covered by the snapshot.
 	var operations []operator
-	for i := range walManager.currentId + 1 {
-		wal := walManager.walFiles[int64(i)]
+	for _, id := range walManager.idsFrom(firstWalId) {
+		wal := walManager.walFiles[id]
 		walOps, err := wal.read()
 		if err != nil {
 			innerErr := logger.Error("cannot read wal operations")
@@ -47,16 +86,24 @@ func openStorage(root string) (*storage, error) {
 		operations = append(operations, walOps...)
 	}
 
-	inMemStore := newInMemStore()
+	snapshotSequence := inMemStore.sequence
 	inMemStore.apply(operations)
 
 	// Create and return storage connection.
 	storageConnection := &storage{
-		root:       root,
-		walDir:     walDir,
-		inMemStore: inMemStore,
-		walManager: walManager,
-		mu:         sync.RWMutex{},
+		fsys:             fsys,
+		keys:             keys,
+		root:             root,
+		walDir:           walDir,
+		snapshotDir:      snapshotDir,
+		archiveDir:       path.Join(root, "archive"),
+		inMemStore:       inMemStore,
+		walManager:       walManager,
+		committer:        newGroupCommitter(DurabilityAlways),
+		feed:             newChangeFeed(),
+		mu:               sync.RWMutex{},
+		snapshotSequence: snapshotSequence,
+		snapshotTime:     time.Now(),
 	}
 
 	return storageConnection, nil
@@ -64,56 +111,145 @@ func openStorage(root string) (*storage, error) {
 
 func (w *storage) close() error {
 	logger.Info("closing storage connection")
+	w.feed.close()
 
 	return w.walManager.close()
 }
 
-func (s *storage) list(tags []string) ([]TaggedKV, error) {
+// Sets when commits are synced to disk.
+func (s *storage) setDurability(durability Durability) {
+	s.committer.durability = durability
+}
+
+// Syncs every commit written so far.  Used by the interval durability mode.
+func (s *storage) sync() error {
+	return s.committer.sync()
+}
+
+// Sets how long record history is kept.  Zero keeps the full history.
+func (s *storage) setHistoryRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inMemStore.historyRetention = retention
+}
+
+func (s *storage) newReadWriteTransaction() *readWriteTransaction {
+	return newReadWriteTransaction(s.inMemStore, s.walManager, s.committer, s.feed, &s.mu)
+}
+
+func (s *storage) list(tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	page, err := s.listPage(tags, options...)
+	return page.Items, err
+}
+
+func (s *storage) listPage(tags []string, options ...ReadConfigurer) (Page, error) {
 	tx := newReadOnlyTransaction(s.inMemStore, &s.mu)
 	defer tx.close()
 
-	return tx.list(tags)
+	return tx.list(tags, newReadConfig(options...))
 }
 
-func (s *storage) get(key string) (taggedKV TaggedKV, found bool, err error) {
+func (s *storage) scan(prefix, start, end string, limit int, options ...ReadConfigurer) ([]TaggedKV, error) {
 	tx := newReadOnlyTransaction(s.inMemStore, &s.mu)
 	defer tx.close()
 
-	return tx.get(key)
+	return tx.scan(prefix, start, end, limit, newReadConfig(options...))
 }
 
-func (s *storage) set(key, value string) error {
-	tx := newReadWriteTransaction(s.inMemStore, s.walManager.current(), &s.mu)
-	tx.set(key, value)
+func (s *storage) search(text string, tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	tx := newReadOnlyTransaction(s.inMemStore, &s.mu)
+	defer tx.close()
 
-	return tx.commit()
+	return tx.search(text, tags, newReadConfig(options...))
 }
 
-func (s *storage) delete(key string) error {
-	tx := newReadWriteTransaction(s.inMemStore, s.walManager.current(), &s.mu)
+func (s *storage) get(key string, options ...ReadConfigurer) (taggedKV TaggedKV, found bool, err error) {
+	tx := newReadOnlyTransaction(s.inMemStore, &s.mu)
+	defer tx.close()
 
-	old, found, err := tx.get(key)
-	if err != nil {
-		tx.cancel()
-		return err
-	}
+	return tx.get(key, newReadConfig(options...))
+}
+
+// Returns the committed changes to a record, oldest first.
+func (s *storage) history(key string) []HistoryEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.inMemStore.getHistory(key)
+}
+
+// Runs fn within a read-only transaction.
+func (s *storage) view(fn func(tx ReadTx) error) error {
+	tx := newReadOnlyTransaction(s.inMemStore, &s.mu)
+	defer tx.close()
+
+	return fn(&viewTx{tx: tx})
+}
+
+// Runs fn within a read-write transaction.
+// The transaction is committed when fn succeeds, and cancelled when it returns an error.
+func (s *storage) update(fn func(tx *updateTx) error) error {
+	_, err := s.updateVersion(fn)
+	return err
+}
+
+// Runs fn within a read-write transaction, like update.
+// Returns the version assigned to the records changed by the transaction.
+func (s *storage) updateVersion(fn func(tx *updateTx) error) (uint64, error) {
+	tx := s.newReadWriteTransaction()
+	defer tx.cancel()
 
-	if !found {
-		tx.cancel()
-		return fmt.Errorf("key not found `%s` ", key)
+	if err := fn(&updateTx{tx: tx}); err != nil {
+		logger.Infof("rolling back transaction %s because %s", tx.transactionId, err)
+		return 0, err
 	}
 
-	for _, tag := range old.Tags {
-		tx.untag(key, tag)
+	if err := tx.commit(); err != nil {
+		return 0, err
 	}
 
-	tx.delete(key)
+	return tx.version, nil
+}
 
-	return tx.commit()
+// Creates or updates a record.  Returns the committed version.
+func (s *storage) set(key, value string) (uint64, error) {
+	return s.updateVersion(func(tx *updateTx) error {
+		return tx.set(key, value)
+	})
 }
 
-func (s *storage) tag(key, tag string) error {
-	tx := newReadWriteTransaction(s.inMemStore, s.walManager.current(), &s.mu)
+// Creates or updates a record, which expires after the ttl.  Returns the committed version.
+func (s *storage) setWithTTL(key, value string, ttl time.Duration) (uint64, error) {
+	return s.updateVersion(func(tx *updateTx) error {
+		return tx.setWithTTL(key, value, ttl)
+	})
+}
+
+// Creates or updates a record, if its version matches.  Returns the committed version.
+func (s *storage) compareAndSet(key, value string, expectedVersion uint64) (uint64, error) {
+	return s.updateVersion(func(tx *updateTx) error {
+		return tx.compareAndSet(key, value, expectedVersion)
+	})
+}
+
+// Moves a record to the trash, if its version matches.
+func (s *storage) compareAndDelete(key string, expectedVersion uint64) error {
+	return s.update(func(tx *updateTx) error {
+		return tx.compareAndDelete(key, expectedVersion)
+	})
+}
+
+// Moves a record to the trash.
+func (s *storage) delete(key string) error {
+	return s.update(func(tx *updateTx) error {
+		return tx.delete(key)
+	})
+}
+
+// Returns a record from the trash.
+func (s *storage) restore(key string) error {
+	tx := s.newReadWriteTransaction()
 	defer tx.cancel()
 
 	taggedKV, found, err := tx.get(key)
@@ -121,21 +257,19 @@ func (s *storage) tag(key, tag string) error {
 		return err
 	}
 
-	if !found {
-		return fmt.Errorf("key not found `%s` ", key)
-	}
-	if slices.Contains(taggedKV.Tags, tag) {
-		logger.Infof("tag `%s` already exists on key `%s`", tag, key)
-		return nil
+	if !found || taggedKV.Deleted.IsZero() {
+		return fmt.Errorf("deleted key not found `%s` ", key)
 	}
 
-	tx.tag(key, tag)
+	tx.systemUntag(key, systemTagDeleted)
+	tx.stamp(key)
 
 	return tx.commit()
 }
 
-func (s *storage) untag(key, tag string) error {
-	tx := newReadWriteTransaction(s.inMemStore, s.walManager.current(), &s.mu)
+// Permanently removes a record from the trash.
+func (s *storage) purge(key string) error {
+	tx := s.newReadWriteTransaction()
 	defer tx.cancel()
 
 	taggedKV, found, err := tx.get(key)
@@ -143,25 +277,170 @@ func (s *storage) untag(key, tag string) error {
 		return err
 	}
 
-	if !found {
-		return fmt.Errorf("key not found `%s` ", key)
+	if !found || taggedKV.Deleted.IsZero() {
+		return fmt.Errorf("deleted key not found `%s` ", key)
 	}
 
-	if !slices.Contains(taggedKV.Tags, tag) {
-		return fmt.Errorf("Tag `%s` not found on key `%s`", tag, key)
+	tx.purge(taggedKV)
+
+	return tx.commit()
+}
+
+// Permanently removes all records deleted before the cutoff.
+// Returns the number of purged records.
+func (s *storage) purgeDeletedBefore(cutoff time.Time) (int, error) {
+	tx := s.newReadWriteTransaction()
+	defer tx.cancel()
+
+	var count int
+	for _, taggedKV := range tx.store.list([]string{}) {
+		if taggedKV.Deleted.IsZero() || !taggedKV.Deleted.Before(cutoff) {
+			continue
+		}
+
+		tx.purge(taggedKV)
+		count++
 	}
 
-	tx.untag(key, tag)
+	if count == 0 {
+		return 0, nil
+	}
 
-	return tx.commit()
+	logger.Infof("purging %d deleted record(s)", count)
+	return count, tx.commit()
+}
+
+// Permanently removes all records that expired before now.
+// Returns the number of removed records.
+func (s *storage) purgeExpiredBefore(now time.Time) (int, error) {
+	tx := s.newReadWriteTransaction()
+	defer tx.cancel()
+
+	var count int
+	for _, taggedKV := range tx.store.list([]string{}) {
+		if !isExpired(taggedKV, now) {
+			continue
+		}
+
+		tx.purge(taggedKV)
+		count++
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	logger.Infof("removing %d expired record(s)", count)
+	return count, tx.commit()
+}
+
+func (s *storage) tag(key, tag string) error {
+	return s.update(func(tx *updateTx) error {
+		return tx.tag(key, tag)
+	})
+}
+
+func (s *storage) untag(key, tag string) error {
+	return s.update(func(tx *updateTx) error {
+		return tx.untag(key, tag)
+	})
 }
 
 func (s *storage) maybeRoll(rollWalAfterBytes int64) {
-	if s.walManager.shouldRoll(rollWalAfterBytes) {
-		tx := newReadWriteTransaction(s.inMemStore, s.walManager.current(), &s.mu)
-		defer tx.commit()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	if s.walManager.shouldRoll(rollWalAfterBytes) {
 		logger.Info("rolling wal")
 		s.walManager.roll()
 	}
 }
+
+// Writes a snapshot of the store, then removes the wal files it covers.
+// Covered wal files are moved to the archive directory when archiveWal is set, otherwise deleted.
+func (s *storage) snapshot(archiveWal bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Start a new wal file, so the snapshot covers every earlier file.
+	previousId := s.walManager.currentId
+	s.walManager.roll()
+	if s.walManager.currentId == previousId {
+		return logger.Error("cannot snapshot because the wal could not be rolled")
+	}
+
+	walId := s.walManager.currentId
+	logger.Infof("writing snapshot %d", walId)
+	if err := writeSnapshot(s.fsys, s.keys, s.snapshotDir, s.inMemStore.snapshot(walId)); err != nil {
+		return logger.Errorf("cannot write snapshot because %s", err)
+	}
+	s.snapshotSequence = s.inMemStore.sequence
+	s.snapshotTime = time.Now()
+
+	// The snapshot is durable, so older files are no longer needed.
+	var archiveDir string
+	if archiveWal {
+		archiveDir = s.archiveDir
+		if err := createDirIfNotExists(s.fsys, archiveDir); err != nil {
+			return logger.Errorf("cannot create wal archive because %s", err)
+		}
+	}
+
+	if err := s.walManager.compact(walId, archiveDir); err != nil {
+		return logger.Errorf("cannot compact wal because %s", err)
+	}
+
+	if err := removeSnapshotsBefore(s.fsys, s.snapshotDir, walId); err != nil {
+		return logger.Errorf("cannot remove old snapshots because %s", err)
+	}
+
+	return nil
+}
+
+// Writes a snapshot when the interval has passed, and there are new commits.
+func (s *storage) maybeSnapshot(interval time.Duration, archiveWal bool) {
+	if interval <= 0 {
+		return
+	}
+
+	s.mu.RLock()
+	due := time.Since(s.snapshotTime) >= interval && s.inMemStore.sequence != s.snapshotSequence
+	s.mu.RUnlock()
+
+	if !due {
+		return
+	}
+
+	if err := s.snapshot(archiveWal); err != nil {
+		logger.Warnf("failed to snapshot because %s", err)
+	}
+}
+
+func (s *storage) maybePurgeExpired() {
+	if _, err := s.purgeExpiredBefore(time.Now()); err != nil {
+		logger.Warnf("failed to remove expired records because %s", err)
+	}
+}
+
+func (s *storage) maybePurgeDeleted(purgeDeletedAfter time.Duration) {
+	if purgeDeletedAfter <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-purgeDeletedAfter)
+	if _, err := s.purgeDeletedBefore(cutoff); err != nil {
+		logger.Warnf("failed to purge deleted records because %s", err)
+	}
+}
+
+func (s *storage) maybePruneHistory() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retention := s.inMemStore.historyRetention
+	if retention <= 0 {
+		return
+	}
+
+	s.inMemStore.pruneAllHistory(time.Now().Add(-retention))
+}
@@ -2,25 +2,27 @@ package tagdb
 
 import (
 	"cmp"
+	"errors"
 	"slices"
 	"testing"
+	"time"
 )
 
 func Test_storage_list_ReturnsItemsWithTag(t *testing.T) {
 	// Arrange.
-	store, err := openStorage(t.TempDir())
+	store, err := openStorage(osFS{}, nil, t.TempDir())
 	if err != nil {
 		t.Fatalf("Failed to connect to storage: %v", err)
 	}
 	defer store.close()
 
-	if err := store.set("key-1", "value-1"); err != nil {
+	if _, err := store.set("key-1", "value-1"); err != nil {
 		t.Fatalf("set returned error: %s", err)
 	}
-	if err := store.set("key-2", "value-2"); err != nil {
+	if _, err := store.set("key-2", "value-2"); err != nil {
 		t.Fatalf("set returned error: %s", err)
 	}
-	if err := store.set("key-3", "value-1"); err != nil {
+	if _, err := store.set("key-3", "value-1"); err != nil {
 		t.Fatalf("set returned error: %s", err)
 	}
 	if err := store.tag("key-1", "find"); err != nil {
@@ -45,13 +47,13 @@ func Test_storage_list_ReturnsItemsWithTag(t *testing.T) {
 
 func Test_storage_get_ReturnsItem(t *testing.T) {
 	// Arrange.
-	store, err := openStorage(t.TempDir())
+	store, err := openStorage(osFS{}, nil, t.TempDir())
 	if err != nil {
 		t.Fatalf("Failed to connect to storage: %v", err)
 	}
 	defer store.close()
 
-	if err := store.set("key-1", "value-1"); err != nil {
+	if _, err := store.set("key-1", "value-1"); err != nil {
 		t.Fatalf("Set returned error: %v", err)
 	}
 
@@ -74,13 +76,13 @@ func Test_storage_get_ReturnsItem(t *testing.T) {
 
 func Test_storage_delete_RemovesItem(t *testing.T) {
 	// Arrange.
-	store, err := openStorage(t.TempDir())
+	store, err := openStorage(osFS{}, nil, t.TempDir())
 	if err != nil {
 		t.Fatalf("Failed to connect to storage: %v", err)
 	}
 	defer store.close()
 
-	if err := store.set("key-1", "value-1"); err != nil {
+	if _, err := store.set("key-1", "value-1"); err != nil {
 		t.Fatalf("Set returned error: %v", err)
 	}
 	if err := store.delete("key-1"); err != nil {
@@ -102,19 +104,19 @@ func Test_storage_delete_RemovesItem(t *testing.T) {
 
 func Test_storage_list_DoesNotReturnsUntaggedItems(t *testing.T) {
 	// Arrange.
-	store, err := openStorage(t.TempDir())
+	store, err := openStorage(osFS{}, nil, t.TempDir())
 	if err != nil {
 		t.Fatalf("Failed to connect to storage: %v", err)
 	}
 	defer store.close()
 
-	if err := store.set("key-1", "value-1"); err != nil {
+	if _, err := store.set("key-1", "value-1"); err != nil {
 		t.Fatalf("set returned error: %s", err)
 	}
-	if err := store.set("key-2", "value-2"); err != nil {
+	if _, err := store.set("key-2", "value-2"); err != nil {
 		t.Fatalf("set returned error: %s", err)
 	}
-	if err := store.set("key-3", "value-1"); err != nil {
+	if _, err := store.set("key-3", "value-1"); err != nil {
 		t.Fatalf("set returned error: %s", err)
 	}
 	if err := store.tag("key-1", "find"); err != nil {
@@ -146,7 +148,7 @@ func Test_storage_list_DoesNotReturnsUntaggedItems(t *testing.T) {
 func Test_openStorage_RetainsDataAfterReopen(t *testing.T) {
 	// Arrange.
 	storeRoot := t.TempDir()
-	store, err := openStorage(storeRoot)
+	store, err := openStorage(osFS{}, nil, storeRoot)
 	if err != nil {
 		t.Fatalf("Failed to connect to storage: %v", err)
 	}
@@ -161,7 +163,7 @@ func Test_openStorage_RetainsDataAfterReopen(t *testing.T) {
 
 	store.close()
 
-	store, err = openStorage(storeRoot)
+	store, err = openStorage(osFS{}, nil, storeRoot)
 	if err != nil {
 		t.Fatalf("Failed to reconnect to storage: %v", err)
 	}
@@ -205,3 +207,647 @@ func Test_openStorage_RetainsDataAfterReopen(t *testing.T) {
 		t.Fatalf("Item 3 tags mismatch after reopen: %+v", items[0].Tags)
 	}
 }
+
+func Test_storage_set_StampsCreatedAndUpdated(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	if _, err := store.set("key-1", "value-1"); err != nil {
+		t.Fatalf("set returned error: %s", err)
+	}
+	created, _, _ := store.get("key-1")
+
+	time.Sleep(time.Millisecond)
+	if err := store.tag("key-1", "tag-1"); err != nil {
+		t.Fatalf("tag returned error: %s", err)
+	}
+	store.close()
+
+	// Act.
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	taggedKV, found, err := store.get("key-1")
+
+	// Assert.
+	if err != nil || !found {
+		t.Fatalf("Get failed after reopen, found=%v err=%v", found, err)
+	}
+
+	if created.Created.IsZero() || !created.Created.Equal(created.Updated) {
+		t.Fatalf("Expected new record to share created and updated timestamps: %+v", created)
+	}
+
+	if !taggedKV.Created.Equal(created.Created) {
+		t.Fatalf("Created timestamp changed from %s to %s", created.Created, taggedKV.Created)
+	}
+
+	if !taggedKV.Updated.After(taggedKV.Created) {
+		t.Fatalf("Expected updated %s to be after created %s", taggedKV.Updated, taggedKV.Created)
+	}
+
+	if len(taggedKV.Tags) != 1 || taggedKV.Tags[0] != "tag-1" {
+		t.Fatalf("Expected system tags to be excluded from user tags: %+v", taggedKV.Tags)
+	}
+}
+
+func Test_storage_delete_MovesItemToTrash(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	if _, err := store.set("key-1", "value-1"); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+	if _, err := store.set("key-2", "value-2"); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+
+	// Act.
+	if err := store.delete("key-1"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+
+	// Assert.
+	items, _ := store.list([]string{})
+	if len(items) != 1 || items[0].Key != "key-2" {
+		t.Fatalf("Expected deleted item to be hidden from list: %+v", items)
+	}
+
+	trash, _ := store.list([]string{}, OnlyDeleted())
+	if len(trash) != 1 || trash[0].Key != "key-1" || trash[0].Deleted.IsZero() {
+		t.Fatalf("Expected deleted item in trash: %+v", trash)
+	}
+
+	all, _ := store.list([]string{}, WithDeleted())
+	if len(all) != 2 {
+		t.Fatalf("Expected 2 items when including deleted, but found %d", len(all))
+	}
+
+	if _, found, _ := store.get("key-1", WithDeleted()); !found {
+		t.Fatalf("Expected to get deleted item when requested")
+	}
+}
+
+func Test_storage_restore_ReturnsItemFromTrash(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	store.delete("key-1")
+
+	// Act.
+	if err := store.restore("key-1"); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+	store.close()
+
+	// Assert.
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	taggedKV, found, _ := store.get("key-1")
+	if !found {
+		t.Fatalf("Expected restored item to be found")
+	}
+
+	if !taggedKV.Deleted.IsZero() || len(taggedKV.Tags) != 1 {
+		t.Fatalf("Restored item mismatch: %+v", taggedKV)
+	}
+
+	if err := store.restore("key-1"); err == nil {
+		t.Fatalf("Expected error restoring an item that is not deleted")
+	}
+}
+
+func Test_storage_purge_RemovesItemFromTrash(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+
+	if err := store.purge("key-1"); err == nil {
+		t.Fatalf("Expected error purging an item that is not deleted")
+	}
+	store.delete("key-1")
+
+	// Act.
+	if err := store.purge("key-1"); err != nil {
+		t.Fatalf("Purge returned error: %v", err)
+	}
+
+	// Assert.
+	if _, found, _ := store.get("key-1", WithDeleted()); found {
+		t.Fatalf("Expected purged item to be removed")
+	}
+
+	if items, _ := store.list([]string{"tag-1"}, WithDeleted()); len(items) != 0 {
+		t.Fatalf("Expected purged item to be removed from tag index: %+v", items)
+	}
+}
+
+func Test_storage_purgeDeletedBefore_RemovesExpiredItemsOnly(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.set("key-2", "value-2")
+	store.delete("key-1")
+	cutoff := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	store.delete("key-2")
+
+	// Act.
+	count, err := store.purgeDeletedBefore(cutoff)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("purgeDeletedBefore returned error: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("Expected 1 purged item, but found %d", count)
+	}
+
+	trash, _ := store.list([]string{}, OnlyDeleted())
+	if len(trash) != 1 || trash[0].Key != "key-2" {
+		t.Fatalf("Expected only key-2 to remain in trash: %+v", trash)
+	}
+}
+
+func Test_storage_setWithTTL_HidesExpiredItem(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+
+	// Act.
+	if _, err := store.setWithTTL("key-1", "value-2", time.Hour); err != nil {
+		t.Fatalf("setWithTTL returned error: %v", err)
+	}
+	if _, err := store.setWithTTL("key-2", "value-2", time.Millisecond); err != nil {
+		t.Fatalf("setWithTTL returned error: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	store.close()
+
+	// Assert.
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	taggedKV, found, _ := store.get("key-1")
+	if !found || taggedKV.Expires.IsZero() || len(taggedKV.Tags) != 1 {
+		t.Fatalf("Expected item with expiry to be found: %+v", taggedKV)
+	}
+
+	if _, found, _ := store.get("key-2", WithDeleted()); found {
+		t.Fatalf("Expected expired item to be hidden")
+	}
+
+	if err := store.tag("key-2", "tag-1"); err == nil {
+		t.Fatalf("Expected error tagging an expired item")
+	}
+
+	store.set("key-1", "value-3")
+	if taggedKV, _, _ := store.get("key-1"); !taggedKV.Expires.IsZero() {
+		t.Fatalf("Expected set to remove the expiry: %+v", taggedKV)
+	}
+}
+
+func Test_storage_purgeExpiredBefore_RemovesExpiredItemsOnly(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.setWithTTL("key-2", "value-2", time.Millisecond)
+	store.tag("key-2", "tag-1")
+	store.setWithTTL("key-3", "value-3", time.Hour)
+	time.Sleep(2 * time.Millisecond)
+
+	// Act.
+	count, err := store.purgeExpiredBefore(time.Now())
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("purgeExpiredBefore returned error: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("Expected 1 removed item, but found %d", count)
+	}
+
+	if _, found := store.inMemStore.get("key-2"); found {
+		t.Fatalf("Expected expired item to be removed")
+	}
+
+	if keys := store.inMemStore.index.GetKeys("tag-1"); len(keys) != 0 {
+		t.Fatalf("Expected expired item to be removed from tag index: %v", keys)
+	}
+
+	items, _ := store.list([]string{})
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items to remain: %+v", items)
+	}
+}
+
+func Test_storage_set_ReplacesDeletedItem(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	store.delete("key-1")
+
+	// Act.
+	if _, err := store.set("key-1", "value-2"); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+
+	// Assert.
+	taggedKV, found, _ := store.get("key-1")
+	if !found {
+		t.Fatalf("Expected replaced item to be found")
+	}
+
+	if taggedKV.Value != "value-2" || len(taggedKV.Tags) != 0 || !taggedKV.Deleted.IsZero() {
+		t.Fatalf("Expected a new record without old tags: %+v", taggedKV)
+	}
+}
+
+func Test_storage_update_CommitsAllOperations(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	// Act.
+	err = store.update(func(tx *updateTx) error {
+		if err := tx.Set("key-1", "value-1"); err != nil {
+			return err
+		}
+
+		for _, tag := range []string{"tag-1", "tag-2", "tag-3"} {
+			if err := tx.Tag("key-1", tag); err != nil {
+				return err
+			}
+		}
+
+		// Reads include pending writes.
+		items, err := tx.List([]string{"tag-1", "tag-3"})
+		if err != nil {
+			return err
+		}
+
+		if len(items) != 1 || items[0].Key != "key-1" {
+			t.Errorf("Expected pending record in transaction list: %+v", items)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update returned error: %v", err)
+	}
+	store.close()
+
+	// Assert.
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	taggedKV, found, _ := store.get("key-1")
+	if !found || len(taggedKV.Tags) != 3 {
+		t.Fatalf("Expected record with 3 tags after reopen: %+v", taggedKV)
+	}
+}
+
+func Test_storage_update_RollsBackOnError(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	// Act.
+	err = store.update(func(tx *updateTx) error {
+		if err := tx.Set("key-1", "value-1"); err != nil {
+			return err
+		}
+
+		if err := tx.Tag("key-1", "tag-1"); err != nil {
+			return err
+		}
+
+		return tx.Tag("key-1", "INVALID TAG")
+	})
+
+	// Assert.
+	if err == nil {
+		t.Fatalf("Expected update to return the error from fn")
+	}
+
+	if _, found, _ := store.get("key-1"); found {
+		t.Fatalf("Expected no writes after rollback")
+	}
+
+	// Storage remains usable after rollback.
+	if _, err := store.set("key-2", "value-2"); err != nil {
+		t.Fatalf("set returned error after rollback: %v", err)
+	}
+}
+
+func Test_storage_view_ReadsRecords(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+
+	// Act.
+	var actual TaggedKV
+	err = store.view(func(tx ReadTx) error {
+		taggedKV, _, err := tx.Get("key-1")
+		actual = taggedKV
+		return err
+	})
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("view returned error: %v", err)
+	}
+
+	if actual.Value != "value-1" {
+		t.Fatalf("view returned incorrect item: %+v", actual)
+	}
+}
+
+func Test_storage_list_WithQuery_ReturnsMatchingItems(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.set("key-2", "value-2")
+	store.set("key-3", "value-3")
+	store.tag("key-1", "work")
+	store.tag("key-2", "work")
+	store.tag("key-2", "archived")
+	store.tag("key-3", "work")
+	store.delete("key-3")
+
+	// Act.
+	items, err := store.list([]string{}, WithQuery("work AND NOT archived"))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("list returned error: %v", err)
+	}
+
+	if len(items) != 1 || items[0].Key != "key-1" {
+		t.Fatalf("Expected only key-1 to match query: %+v", items)
+	}
+
+	if _, err := store.list([]string{}, WithQuery("work AND")); err == nil {
+		t.Fatalf("Expected error for invalid query")
+	}
+}
+
+func Test_storage_scan_WithTags_CombinesWithQuery(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(newMemFS(), nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.set("key-2", "value-2")
+	store.set("key-3", "value-3")
+	store.tag("key-1", "work")
+	store.tag("key-1", "urgent")
+	store.tag("key-2", "work")
+	store.tag("key-3", "urgent")
+
+	// Act.
+	items, err := store.scan("key-", "", "", 0, WithTags("work"), WithQuery("urgent"))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("scan returned error: %v", err)
+	}
+
+	if len(items) != 1 || items[0].Key != "key-1" {
+		t.Fatalf("Expected only key-1 to match tags and query: %+v", items)
+	}
+
+	if _, err := store.scan("key-", "", "", 0, WithTags("work OR urgent")); err == nil {
+		t.Fatalf("Expected error for invalid tag")
+	}
+}
+
+func Test_storage_set_IncrementsVersionAfterReopen(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	store.set("key-2", "value-2")
+	first, _, _ := store.get("key-1")
+
+	// Act.
+	store.tag("key-1", "tag-1")
+	second, _, _ := store.get("key-1")
+	store.close()
+
+	// Assert.
+	if first.Version == 0 || second.Version <= first.Version {
+		t.Fatalf("Expected versions to increase: %d then %d", first.Version, second.Version)
+	}
+
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	replayed, _, _ := store.get("key-1")
+	if replayed.Version != second.Version {
+		t.Fatalf("Expected version %d after replay, but found %d", second.Version, replayed.Version)
+	}
+}
+
+func Test_storage_compareAndSet_RejectsStaleVersion(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	if _, err := store.compareAndSet("key-1", "value-1", 0); err != nil {
+		t.Fatalf("compareAndSet returned error creating a record: %v", err)
+	}
+	original, _, _ := store.get("key-1")
+
+	if _, err := store.compareAndSet("key-1", "value-2", original.Version); err != nil {
+		t.Fatalf("compareAndSet returned error updating a record: %v", err)
+	}
+
+	// Act.
+	_, err = store.compareAndSet("key-1", "value-3", original.Version)
+
+	// Assert.
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected a version conflict, but found %v", err)
+	}
+
+	if conflict.Expected != original.Version || conflict.Actual <= original.Version {
+		t.Fatalf("Unexpected conflict: %+v", conflict)
+	}
+
+	if taggedKV, _, _ := store.get("key-1"); taggedKV.Value != "value-2" {
+		t.Fatalf("Expected value to be unchanged: %+v", taggedKV)
+	}
+}
+
+func Test_storage_set_ReturnsCommittedVersion(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	first, err := store.set("key-1", "value-1")
+	if err != nil {
+		t.Fatalf("set returned error: %v", err)
+	}
+
+	// Act.
+	second, err := store.compareAndSet("key-1", "value-2", first)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("compareAndSet returned error: %v", err)
+	}
+
+	taggedKV, _, _ := store.get("key-1")
+	if second <= first || taggedKV.Version != second {
+		t.Fatalf("Expected committed versions %d then %d, but found %+v", first, second, taggedKV)
+	}
+}
+
+func Test_openStorage_RetainsDataAfterReopen_InMemory(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	store.close()
+
+	// Act.
+	reopened, err := openStorage(fsys, nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.close()
+
+	// Assert.
+	item, found, _ := reopened.get("key-1")
+	if !found || item.Value != "value-1" || !slices.Equal(item.Tags, []string{"tag-1"}) {
+		t.Errorf("Expected key-1 to be retained, found %+v", item)
+	}
+}
+
+func Test_storage_maybeRoll_CreatesNextWalFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+
+	// Act.
+	store.maybeRoll(1)
+	store.set("key-2", "value-2")
+	store.maybeRoll(1024 * 1024)
+
+	// Assert.
+	entries, _ := fsys.ReadDir("/db/wal")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	if !slices.Equal(names, []string{"0.wal", "1.wal"}) {
+		t.Errorf("Expected wal files 0 and 1, found %v", names)
+	}
+
+	if ops, _ := readWalFile(fsys, nil, "/db/wal/1.wal"); len(ops) == 0 {
+		t.Errorf("Expected writes after the roll to use the new wal file")
+	}
+}
@@ -0,0 +1,32 @@
+package tagdb
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	systemTagPrefix  = "."
+	systemTagCreated = ".created"
+	systemTagUpdated = ".updated"
+	systemTagDeleted = ".deleted"
+)
+
+// System tags are maintained by the database, and cannot be added or removed by users.
+func isSystemTag(tag string) bool {
+	return strings.HasPrefix(tag, systemTagPrefix)
+}
+
+// Timestamps are stored in UTC, using RFC3339 format.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// Tests if a record has expired at the given time.
+func isExpired(taggedKV TaggedKV, now time.Time) bool {
+	return !taggedKV.Expires.IsZero() && !now.Before(taggedKV.Expires)
+}
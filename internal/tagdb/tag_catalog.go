@@ -0,0 +1,297 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTagDescriptionLength = 200
+	tagColourPattern        = `^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`
+)
+
+var (
+	tagColourRegexp = regexp.MustCompile(tagColourPattern)
+)
+
+// Returned when renaming or merging a tag that no record has, and that has no metadata.
+var ErrTagNotFound = errors.New("tag not found")
+
+// Returned when renaming a tag to one that is already in use.  Merge the tags instead.
+var ErrTagExists = errors.New("tag already exists")
+
+// Optional metadata for a tag, set with db.SetTagMetadata.
+type TagMetadata struct {
+	// Free text description.  Must be <= 200 characters.
+	Description string `json:"description,omitempty"`
+
+	// A hex colour, such as `#1e90ff`, for display.
+	Colour string `json:"colour,omitempty"`
+}
+
+// A tag in the catalog, returned by db.Tags.
+type TagInfo struct {
+	Tag string `json:"tag"`
+
+	// The number of records with the tag.
+	Count int `json:"count"`
+
+	TagMetadata
+}
+
+// Validates tag metadata.  Empty metadata is valid, and removes any existing metadata.
+func validateTagMetadata(metadata TagMetadata) error {
+	if !utf8.ValidString(metadata.Description) {
+		return fmt.Errorf("tag description cannot contain non UTF8 characters")
+	}
+
+	if utf8.RuneCountInString(metadata.Description) > maxTagDescriptionLength {
+		return fmt.Errorf("tag description cannot exceed max length of %d", maxTagDescriptionLength)
+	}
+
+	if metadata.Colour != "" && !tagColourRegexp.MatchString(metadata.Colour) {
+		return fmt.Errorf("tag colour must match pattern '%s'", tagColourPattern)
+	}
+
+	return nil
+}
+
+// Lists the tags of records accepted by include, with their record counts, sorted by tag.
+// Tags with metadata are listed even when no records have them.
+func (db *inMemStore) tags(include func(TaggedKV) bool) []TagInfo {
+	counts := map[string]int{}
+	for tag := range db.index.Values() {
+		for _, key := range db.index.GetKeys(tag) {
+			if taggedKV, found := db.get(key); found && include(taggedKV) {
+				counts[tag]++
+			}
+		}
+	}
+
+	for tag := range db.tagMetadata {
+		if _, found := counts[tag]; !found {
+			counts[tag] = 0
+		}
+	}
+
+	result := []TagInfo{}
+	for _, tag := range slices.Sorted(maps.Keys(counts)) {
+		if counts[tag] == 0 && db.tagMetadata[tag] == (TagMetadata{}) {
+			continue
+		}
+
+		result = append(result, TagInfo{Tag: tag, Count: counts[tag], TagMetadata: db.tagMetadata[tag]})
+	}
+
+	return result
+}
+
+func (tx *readOnlyTransaction) tags(prefix string, config *readConfig) ([]TagInfo, error) {
+	if !tx.isOpen {
+		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
+		return []TagInfo{}, err
+	}
+
+	if config.err != nil {
+		return []TagInfo{}, config.err
+	}
+
+	result := []TagInfo{}
+	for _, info := range tx.store.tags(config.matches) {
+		if strings.HasPrefix(info.Tag, prefix) {
+			result = append(result, info)
+		}
+	}
+
+	return result, nil
+}
+
+// Sets the metadata of a tag.
+func (tx *readWriteTransaction) setTagMetadata(tag string, metadata TagMetadata) {
+	op := &tagMetadataOperation{
+		transactionId: tx.transactionId,
+		tag:           tag,
+		description:   metadata.Description,
+		colour:        metadata.Colour,
+	}
+
+	tx.operations = append(tx.operations, op)
+	tx.staged.apply([]operator{op})
+	tx.stagedTags[tag] = true
+}
+
+// Retrieves the metadata of a tag, including the effect of pending operations.
+func (tx *readWriteTransaction) tagMetadata(tag string) (metadata TagMetadata, found bool) {
+	if tx.stagedTags[tag] {
+		metadata, found = tx.staged.tagMetadata[tag]
+		return metadata, found
+	}
+
+	metadata, found = tx.store.tagMetadata[tag]
+	return metadata, found
+}
+
+// Sets the metadata of a tag.  Inputs must already be validated.
+func (u *updateTx) setTagMetadata(tag string, metadata TagMetadata) error {
+	if err := u.validateOpen(); err != nil {
+		return err
+	}
+
+	u.tx.setTagMetadata(tag, metadata)
+
+	return nil
+}
+
+// Tests if any record has a tag, or the tag has metadata, including pending operations.
+func (u *updateTx) tagExists(tag string) (bool, error) {
+	taggedKVs, err := u.tx.list([]string{tag})
+	if err != nil {
+		return false, err
+	}
+
+	_, hasMetadata := u.tx.tagMetadata(tag)
+	return len(taggedKVs) > 0 || hasMetadata, nil
+}
+
+// Replaces the source tags with the target tag on every record, including deleted records.
+// The target keeps its own metadata, else takes that of the first source with metadata.  Source
+// metadata is removed.  Returns the number of records changed.  Inputs must already be validated.
+func (u *updateTx) mergeTags(sources []string, target string) (int, error) {
+	if err := u.validateOpen(); err != nil {
+		return 0, err
+	}
+
+	keys := map[string]bool{}
+	for _, source := range sources {
+		if source == target {
+			continue
+		}
+
+		exists, err := u.tagExists(source)
+		if err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			return 0, fmt.Errorf("%w `%s`", ErrTagNotFound, source)
+		}
+
+		taggedKVs, err := u.tx.list([]string{source})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, taggedKV := range taggedKVs {
+			keys[taggedKV.Key] = true
+		}
+	}
+
+	// Rewrite records in key order, so the wal is deterministic.
+	count := 0
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		taggedKV, found, err := u.tx.get(key)
+		if err != nil {
+			return count, err
+		}
+
+		if !found {
+			continue
+		}
+
+		changed := false
+		for _, source := range sources {
+			if source != target && slices.Contains(taggedKV.Tags, source) {
+				u.tx.untag(key, source)
+				changed = true
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		if !slices.Contains(taggedKV.Tags, target) {
+			u.tx.tag(key, target)
+		}
+		count++
+	}
+
+	// Move metadata.
+	_, targetHasMetadata := u.tx.tagMetadata(target)
+	for _, source := range sources {
+		metadata, found := u.tx.tagMetadata(source)
+		if source == target || !found {
+			continue
+		}
+
+		if !targetHasMetadata {
+			u.tx.setTagMetadata(target, metadata)
+			targetHasMetadata = true
+		}
+		u.tx.setTagMetadata(source, TagMetadata{})
+	}
+
+	return count, nil
+}
+
+// Renames a tag on every record.  Inputs must already be validated.
+func (u *updateTx) renameTag(oldTag, newTag string) (int, error) {
+	if err := u.validateOpen(); err != nil {
+		return 0, err
+	}
+
+	exists, err := u.tagExists(newTag)
+	if err != nil {
+		return 0, err
+	}
+
+	if exists {
+		return 0, fmt.Errorf("%w `%s`", ErrTagExists, newTag)
+	}
+
+	return u.mergeTags([]string{oldTag}, newTag)
+}
+
+func (s *storage) tags(prefix string, options ...ReadConfigurer) ([]TagInfo, error) {
+	tx := newReadOnlyTransaction(s.inMemStore, &s.mu)
+	defer tx.close()
+
+	return tx.tags(prefix, newReadConfig(options...))
+}
+
+func (s *storage) setTagMetadata(tag string, metadata TagMetadata) error {
+	return s.update(func(tx *updateTx) error {
+		return tx.setTagMetadata(tag, metadata)
+	})
+}
+
+func (s *storage) renameTag(oldTag, newTag string) (count int, err error) {
+	err = s.update(func(tx *updateTx) error {
+		count, err = tx.renameTag(oldTag, newTag)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *storage) mergeTags(sources []string, target string) (count int, err error) {
+	err = s.update(func(tx *updateTx) error {
+		count, err = tx.mergeTags(sources, target)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
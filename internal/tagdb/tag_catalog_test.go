@@ -0,0 +1,329 @@
+package tagdb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// Opens storage with records tagged `red`, `red` and `blue`, and a deleted record tagged `green`.
+func openTaggedStorage(t *testing.T, fsys fileSystem, root string) *storage {
+	t.Helper()
+
+	store, err := openStorage(fsys, nil, root)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	for key, tag := range map[string]string{"key-1": "red", "key-2": "red", "key-3": "blue", "key-4": "green"} {
+		store.set(key, "value")
+		if err := store.tag(key, tag); err != nil {
+			t.Fatalf("Failed to tag %s: %v", key, err)
+		}
+	}
+	store.delete("key-4")
+
+	return store
+}
+
+func Test_storage_tags_ReturnsSortedTagsWithCounts(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	tags, err := store.tags("")
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to list tags: %v", err)
+	}
+
+	expected := []TagInfo{{Tag: "blue", Count: 1}, {Tag: "red", Count: 2}}
+	if len(tags) != len(expected) {
+		t.Fatalf("Expected %v, found %v", expected, tags)
+	}
+
+	for i := range expected {
+		if tags[i] != expected[i] {
+			t.Errorf("Expected %v, found %v", expected[i], tags[i])
+		}
+	}
+}
+
+func Test_storage_tags_FiltersByPrefix(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	tags, _ := store.tags("re")
+
+	// Assert.
+	if len(tags) != 1 || tags[0].Tag != "red" {
+		t.Errorf("Expected only `red`, found %v", tags)
+	}
+}
+
+func Test_storage_tags_CountsDeletedRecords_WithDeleted(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	tags, _ := store.tags("", OnlyDeleted())
+
+	// Assert.
+	if len(tags) != 1 || tags[0] != (TagInfo{Tag: "green", Count: 1}) {
+		t.Errorf("Expected only `green`, found %v", tags)
+	}
+}
+
+func Test_storage_setTagMetadata_RetainsMetadataAfterReopen(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store := openTaggedStorage(t, fsys, "/db")
+	metadata := TagMetadata{Description: "Urgent items", Colour: "#ff0000"}
+	store.setTagMetadata("red", metadata)
+	store.setTagMetadata("unused", TagMetadata{Colour: "#0f0"})
+	store.snapshot(false)
+	store.setTagMetadata("blue", TagMetadata{Description: "Calm items"})
+	store.close()
+
+	// Act.
+	reopened, err := openStorage(fsys, nil, "/db")
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.close()
+
+	tags, _ := reopened.tags("")
+	expected := []TagInfo{
+		{Tag: "blue", Count: 1, TagMetadata: TagMetadata{Description: "Calm items"}},
+		{Tag: "red", Count: 2, TagMetadata: metadata},
+		{Tag: "unused", Count: 0, TagMetadata: TagMetadata{Colour: "#0f0"}},
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("Expected %v, found %v", expected, tags)
+	}
+
+	for i := range expected {
+		if tags[i] != expected[i] {
+			t.Errorf("Expected %v, found %v", expected[i], tags[i])
+		}
+	}
+}
+
+func Test_storage_setTagMetadata_RemovesMetadata_WhenEmpty(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+	store.setTagMetadata("unused", TagMetadata{Description: "Soon gone"})
+
+	// Act.
+	store.setTagMetadata("unused", TagMetadata{})
+
+	// Assert.
+	if tags, _ := store.tags("unused"); len(tags) != 0 {
+		t.Errorf("Expected no tags, found %v", tags)
+	}
+}
+
+func Test_validateTagMetadata_ShouldError_OnInvalidMetadata(t *testing.T) {
+	cases := map[string]TagMetadata{
+		"long description": {Description: string(make([]rune, maxTagDescriptionLength+1))},
+		"invalid utf8":     {Description: "\xff"},
+		"named colour":     {Colour: "red"},
+		"short hex colour": {Colour: "#ff"},
+	}
+
+	for name, metadata := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Act.
+			err := validateTagMetadata(metadata)
+
+			// Assert.
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func Test_storage_renameTag_RenamesTagOnAllRecords_InOneTransaction(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store := openTaggedStorage(t, fsys, "/db")
+	store.setTagMetadata("green", TagMetadata{Colour: "#0f0"})
+
+	// Act.
+	count, err := store.renameTag("green", "lime")
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to rename tag: %v", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Expected 1 record to change, found %d", count)
+	}
+
+	store.renameTag("red", "crimson")
+	history1, history2 := store.history("key-1"), store.history("key-2")
+	if last1, last2 := history1[len(history1)-1], history2[len(history2)-1]; last1.TransactionId != last2.TransactionId {
+		t.Errorf("Expected records to be renamed in one transaction, found %s and %s", last1.TransactionId, last2.TransactionId)
+	}
+	store.close()
+
+	reopened, err := openStorage(fsys, nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.close()
+
+	tags, _ := reopened.tags("", WithDeleted())
+	expected := []TagInfo{
+		{Tag: "blue", Count: 1},
+		{Tag: "crimson", Count: 2},
+		{Tag: "lime", Count: 1, TagMetadata: TagMetadata{Colour: "#0f0"}},
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("Expected %v, found %v", expected, tags)
+	}
+
+	for i := range expected {
+		if tags[i] != expected[i] {
+			t.Errorf("Expected %v, found %v", expected[i], tags[i])
+		}
+	}
+}
+
+func Test_storage_renameTag_ShouldError_WhenNewTagExists(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	_, err := store.renameTag("red", "blue")
+
+	// Assert.
+	if !errors.Is(err, ErrTagExists) {
+		t.Fatalf("Expected ErrTagExists, found %v", err)
+	}
+
+	if tags, _ := store.tags("red"); len(tags) != 1 || tags[0].Count != 2 {
+		t.Errorf("Expected `red` to be unchanged, found %v", tags)
+	}
+}
+
+func Test_storage_mergeTags_MergesSourcesIntoTarget(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+	store.tag("key-1", "blue")
+	store.setTagMetadata("red", TagMetadata{Description: "Reds"})
+
+	// Act.
+	count, err := store.mergeTags([]string{"red", "green"}, "blue")
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to merge tags: %v", err)
+	}
+
+	if count != 3 {
+		t.Errorf("Expected 3 records to change, found %d", count)
+	}
+
+	tags, _ := store.tags("", WithDeleted())
+	expected := TagInfo{Tag: "blue", Count: 4, TagMetadata: TagMetadata{Description: "Reds"}}
+	if len(tags) != 1 || tags[0] != expected {
+		t.Errorf("Expected only %v, found %v", expected, tags)
+	}
+
+	if taggedKV, _, _ := store.get("key-1"); !slices.Equal(taggedKV.Tags, []string{"blue"}) {
+		t.Errorf("Expected key-1 to be tagged `blue` once, found %v", taggedKV.Tags)
+	}
+}
+
+func Test_storage_mergeTags_ShouldRollBack_OnUnknownSource(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	_, err := store.mergeTags([]string{"red", "missing"}, "blue")
+
+	// Assert.
+	if !errors.Is(err, ErrTagNotFound) {
+		t.Fatalf("Expected ErrTagNotFound, found %v", err)
+	}
+
+	if tags, _ := store.tags("red"); len(tags) != 1 || tags[0].Count != 2 {
+		t.Errorf("Expected `red` to be unchanged, found %v", tags)
+	}
+}
+
+func Test_storage_mergeTags_IncludesEarlierWrites_InSameTransaction(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	var count int
+	err := store.update(func(tx *updateTx) error {
+		if err := tx.set("key-5", "value"); err != nil {
+			return err
+		}
+
+		if err := tx.tag("key-5", "yellow"); err != nil {
+			return err
+		}
+
+		if err := tx.setTagMetadata("yellow", TagMetadata{Colour: "#ff0"}); err != nil {
+			return err
+		}
+
+		var err error
+		count, err = tx.mergeTags([]string{"yellow", "blue"}, "red")
+		return err
+	})
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to merge tags: %v", err)
+	}
+
+	if count != 2 {
+		t.Errorf("Expected 2 records to change, found %d", count)
+	}
+
+	tags, _ := store.tags("")
+	expected := TagInfo{Tag: "red", Count: 4, TagMetadata: TagMetadata{Colour: "#ff0"}}
+	if len(tags) != 1 || tags[0] != expected {
+		t.Errorf("Expected only %v, found %v", expected, tags)
+	}
+}
+
+func Test_storage_renameTag_ShouldError_WhenNewTagAddedInSameTransaction(t *testing.T) {
+	// Arrange.
+	store := openTaggedStorage(t, newMemFS(), "/db")
+	defer store.close()
+
+	// Act.
+	err := store.update(func(tx *updateTx) error {
+		if err := tx.setTagMetadata("yellow", TagMetadata{Description: "Yellows"}); err != nil {
+			return err
+		}
+
+		_, err := tx.renameTag("red", "yellow")
+		return err
+	})
+
+	// Assert.
+	if !errors.Is(err, ErrTagExists) {
+		t.Fatalf("Expected ErrTagExists, found %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package tagdb
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
 	"github.com/google/uuid"
@@ -33,23 +34,77 @@ func newReadOnlyTransaction(store *inMemStore, mu *sync.RWMutex) *readOnlyTransa
 	}
 }
 
-func (tx *readOnlyTransaction) list(tags []string) ([]TaggedKV, error) {
+func (tx *readOnlyTransaction) list(tags []string, config *readConfig) (Page, error) {
+	if !tx.isOpen {
+		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
+		return Page{Items: []TaggedKV{}}, err
+	}
+
+	if config.err != nil {
+		return Page{Items: []TaggedKV{}}, config.err
+	}
+
+	result := []TaggedKV{}
+	for _, taggedKV := range tx.store.find(tags, config.query) {
+		if config.matches(taggedKV) {
+			result = append(result, taggedKV)
+		}
+	}
+
+	return paginate(result, config)
+}
+
+func (tx *readOnlyTransaction) scan(prefix, start, end string, limit int, config *readConfig) ([]TaggedKV, error) {
 	if !tx.isOpen {
 		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
 		return []TaggedKV{}, err
 	}
 
-	return tx.store.list(tags), nil
+	if config.err != nil {
+		return []TaggedKV{}, config.err
+	}
+
+	return tx.store.scan(prefix, start, end, limit, config.matches), nil
 }
 
-func (tx *readOnlyTransaction) get(key string) (taggedKV TaggedKV, found bool, err error) {
+func (tx *readOnlyTransaction) search(text string, tags []string, config *readConfig) ([]TaggedKV, error) {
+	if !tx.isOpen {
+		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
+		return []TaggedKV{}, err
+	}
+
+	if config.err != nil {
+		return []TaggedKV{}, config.err
+	}
+
+	include := func(taggedKV TaggedKV) bool {
+		return isSubset(tags, taggedKV.Tags) && config.matches(taggedKV)
+	}
+
+	result := tx.store.search(text, include)
+	if config.limit > 0 && len(result) > config.limit {
+		result = result[:config.limit]
+	}
+
+	return result, nil
+}
+
+func (tx *readOnlyTransaction) get(key string, config *readConfig) (taggedKV TaggedKV, found bool, err error) {
 	if !tx.isOpen {
 		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
 		return TaggedKV{}, false, err
 	}
 
+	if config.err != nil {
+		return TaggedKV{}, false, config.err
+	}
+
 	taggedKV, found = tx.store.get(key)
-	return taggedKV, found, nil
+	if !found || !config.matches(taggedKV) {
+		return TaggedKV{}, false, nil
+	}
+
+	return taggedKV, true, nil
 }
 
 func (tx *readOnlyTransaction) close() error {
@@ -72,86 +127,213 @@ type readWriteTransaction struct {
 	operations []operator
 	store      *inMemStore
 	wal        *wal
+	committer  *groupCommitter
+	feed       *changeFeed
 	mu         *sync.RWMutex
+
+	// All system timestamps written by a transaction share the same value.
+	now       time.Time
+	timestamp string
+
+	// Pending operations are applied to copies of the records they touch.
+	// Allowing the transaction to read its own writes, without changing the store.
+	staged     *inMemStore
+	stagedKeys map[string]bool
+
+	// Tags with pending metadata operations.  Their staged metadata replaces the store's.
+	stagedTags map[string]bool
+
+	// Tracks keys that already have a pending `.updated` system tag.
+	stampedKeys map[string]bool
+
+	// The version assigned to every record changed by the transaction.  Zero until written.
+	version uint64
 }
 
-func newReadWriteTransaction(store *inMemStore, wal *wal, mu *sync.RWMutex) *readWriteTransaction {
+// Takes the storage lock, then resolves the current wal file.  The lock is held until the
+// transaction is written or cancelled, so the wal file cannot be rolled, compacted or compressed
+// while the transaction uses it.
+func newReadWriteTransaction(
+	store *inMemStore,
+	wals *walManager,
+	committer *groupCommitter,
+	feed *changeFeed,
+	mu *sync.RWMutex,
+) *readWriteTransaction {
 	mu.Lock()
+	wal := wals.current()
 
 	id := uuid.NewString()
-	logger.Infof("creating read-only transaction %s", id)
+	logger.Infof("creating read-write transaction %s", id)
 
+	now := time.Now()
 	return &readWriteTransaction{
 		transaction: transaction{transactionId: id},
 		isOpen:      true,
 		operations:  []operator{},
 		store:       store,
 		wal:         wal,
+		committer:   committer,
+		feed:        feed,
 		mu:          mu,
+		now:         now,
+		timestamp:   formatTimestamp(now),
+		staged:      newInMemStore(),
+		stagedKeys:  map[string]bool{},
+		stagedTags:  map[string]bool{},
+		stampedKeys: map[string]bool{},
 	}
 }
 
+// Retrieves a record by its key, including the effect of pending operations.
 func (tx *readWriteTransaction) get(key string) (taggedKV TaggedKV, found bool, err error) {
 	if !tx.isOpen {
 		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
 		return TaggedKV{}, false, err
 	}
 
+	if tx.stagedKeys[key] {
+		taggedKV, found = tx.staged.get(key)
+		return taggedKV, found, nil
+	}
+
 	taggedKV, found = tx.store.get(key)
 	return taggedKV, found, nil
 }
 
-func (tx *readWriteTransaction) set(key, value string) {
-	// Validation.
+// Lists records by tags, including the effect of pending operations.
+func (tx *readWriteTransaction) list(tags []string) ([]TaggedKV, error) {
 	if !tx.isOpen {
-		logger.Errorf("cannot update closed transaction %s", tx.transactionId)
+		err := fmt.Errorf("cannot read from closed transaction %s", tx.transactionId)
+		return []TaggedKV{}, err
 	}
 
-	tx.operations = append(tx.operations, &setOperation{
+	var result []TaggedKV
+	for _, taggedKV := range tx.store.list(tags) {
+		if !tx.stagedKeys[taggedKV.Key] {
+			result = append(result, taggedKV)
+		}
+	}
+
+	result = append(result, tx.staged.list(tags)...)
+
+	return result, nil
+}
+
+func (tx *readWriteTransaction) set(key, value string) {
+	// Replacing a deleted or expired record purges it.
+	old, exists, _ := tx.get(key)
+	if exists && (!old.Deleted.IsZero() || isExpired(old, tx.now)) {
+		tx.purge(old)
+		exists = false
+	}
+
+	tx.append(key, &setOperation{
 		transactionId: tx.transactionId,
 		key:           key,
 		value:         value,
 	})
-}
 
-func (tx *readWriteTransaction) delete(key string) {
-	// Validation.
-	if !tx.isOpen {
-		logger.Errorf("cannot update closed transaction %s", tx.transactionId)
+	if !exists {
+		tx.systemTag(key, systemTagCreated, tx.timestamp)
 	}
+	tx.stamp(key)
+}
 
-	tx.operations = append(tx.operations, &deleteOperation{
+func (tx *readWriteTransaction) delete(key string) {
+	tx.append(key, &deleteOperation{
 		transactionId: tx.transactionId,
 		key:           key,
 	})
+	delete(tx.stampedKeys, key)
 }
 
 func (tx *readWriteTransaction) tag(key string, tag string) {
-	// Validation.
-	if !tx.isOpen {
-		logger.Errorf("cannot update closed transaction %s", tx.transactionId)
-	}
-
-	tx.operations = append(tx.operations, &tagOperation{
+	tx.append(key, &tagOperation{
 		transactionId: tx.transactionId,
 		key:           key,
 		tag:           tag,
 	})
+	tx.stamp(key)
 }
 
 func (tx *readWriteTransaction) untag(key string, tag string) {
-	// Validation.
-	if !tx.isOpen {
-		logger.Errorf("cannot update closed transaction %s", tx.transactionId)
+	tx.append(key, &untagOperation{
+		transactionId: tx.transactionId,
+		key:           key,
+		tag:           tag,
+	})
+	tx.stamp(key)
+}
+
+// Sets when a record expires.  A zero time removes the expiry.
+func (tx *readWriteTransaction) expire(key string, expires time.Time) {
+	var value string
+	if !expires.IsZero() {
+		value = formatTimestamp(expires)
 	}
 
-	tx.operations = append(tx.operations, &untagOperation{
+	tx.append(key, &expireOperation{
+		transactionId: tx.transactionId,
+		key:           key,
+		expires:       value,
+	})
+}
+
+// Sets a read-only system tag.
+func (tx *readWriteTransaction) systemTag(key, tag, value string) {
+	tx.append(key, &systemTagOperation{
+		transactionId: tx.transactionId,
+		key:           key,
+		tag:           tag,
+		value:         value,
+	})
+}
+
+// Removes a read-only system tag.
+func (tx *readWriteTransaction) systemUntag(key, tag string) {
+	tx.append(key, &systemUntagOperation{
 		transactionId: tx.transactionId,
 		key:           key,
 		tag:           tag,
 	})
 }
 
+// Permanently removes a record, and all of its tags.
+func (tx *readWriteTransaction) purge(taggedKV TaggedKV) {
+	for _, tag := range taggedKV.Tags {
+		tx.untag(taggedKV.Key, tag)
+	}
+
+	tx.delete(taggedKV.Key)
+}
+
+// Records the `.updated` timestamp, once per key per transaction.
+func (tx *readWriteTransaction) stamp(key string) {
+	if tx.stampedKeys[key] {
+		return
+	}
+
+	tx.systemTag(key, systemTagUpdated, tx.timestamp)
+	tx.stampedKeys[key] = true
+}
+
+// Adds a pending operation, and applies it to the staged copy of the record.
+func (tx *readWriteTransaction) append(key string, op operator) {
+	// Validation.
+	if !tx.isOpen {
+		logger.Errorf("cannot update closed transaction %s", tx.transactionId)
+	}
+
+	if !tx.stagedKeys[key] {
+		tx.staged.copyRecord(tx.store, key)
+		tx.stagedKeys[key] = true
+	}
+
+	tx.operations = append(tx.operations, op)
+	tx.staged.apply([]operator{op})
+}
+
 func (tx *readWriteTransaction) cancel() {
 	// Validation.
 	if !tx.isOpen {
@@ -172,20 +354,46 @@ func (tx *readWriteTransaction) commit() error {
 	}
 
 	logger.Infof("committing transaction %s", tx.transactionId)
+	ticket, err := tx.write()
+	if err != nil {
+		return err
+	}
+
+	// Wait outside the lock, so concurrent commits can share a sync.
+	return tx.committer.wait(ticket)
+}
+
+// Writes the transaction to the wal, applies it to the store, then releases the lock.
+// Returns the ticket to wait on for the write to be synced.
+func (tx *readWriteTransaction) write() (uint64, error) {
 	defer tx.mu.Unlock()
 	defer func() { tx.isOpen = false }()
+
+	// A failed sync may have lost earlier commits, so nothing more is written.
+	if err := tx.committer.err(); err != nil {
+		logger.Errorf("cannot write transaction %s because %s", tx.transactionId, err)
+		return 0, err
+	}
+
 	tx.operations = append(tx.operations, &commitOperation{
 		transactionId: tx.transactionId,
+		timestamp:     formatTimestamp(time.Now()),
 	})
 
 	// Write to wal.
 	if err := tx.wal.write(tx.operations); err != nil {
 		logger.Errorf("failed to write transaction %s to wal because %s", tx.transactionId, err)
-		return err
+		return 0, err
 	}
+	ticket := tx.committer.write(tx.wal)
 
 	// Update in-memory store.
+	tagsBefore := tx.feed.tagsBefore(tx.store, tx.operations)
 	tx.store.apply(tx.operations)
+	tx.version = tx.store.sequence
 
-	return nil
+	// Notify watchers, while the lock preserves commit order.
+	tx.feed.publish(tx.store, tx.operations, tagsBefore)
+
+	return ticket, nil
 }
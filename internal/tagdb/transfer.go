@@ -0,0 +1,445 @@
+package tagdb
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+const (
+	defaultImportBatchSize = 500
+)
+
+// The file format used to export and import records.
+type DataFormat int
+
+const (
+	// One JSON encoded TaggedKV per line.
+	FormatJSONLines DataFormat = iota
+
+	// A header row, then one row per record.  Tags are separated by spaces.
+	FormatCSV
+)
+
+func (format DataFormat) String() string {
+	switch format {
+	case FormatJSONLines:
+		return "jsonl"
+	case FormatCSV:
+		return "csv"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(format))
+	}
+}
+
+// Parses a data format, such as `jsonl` or `csv`.
+func ParseDataFormat(value string) (DataFormat, error) {
+	for _, format := range []DataFormat{FormatJSONLines, FormatCSV} {
+		if value == format.String() {
+			return format, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported format `%s`, expected jsonl or csv", value)
+}
+
+/*
+CSV columns, in export order.  Imports match columns by name, in any order.  Only key and value are
+required.  The created, updated, deleted and version columns are ignored by imports.
+
+	| Column  | Comments                                  |
+	| ------- | ----------------------------------------- |
+	| key     | Primary key.                              |
+	| value   | Record value.                             |
+	| tags    | User tags, separated by spaces.           |
+	| created | RFC3339 timestamp.                        |
+	| updated | RFC3339 timestamp.                        |
+	| deleted | RFC3339 timestamp.  Empty unless deleted. |
+	| expires | RFC3339 timestamp.  Empty for no expiry.  |
+	| version | Record version.                           |
+*/
+var csvColumns = []string{
+	"key", "value", "tags", "created", "updated", "deleted", "expires", "version",
+}
+
+// Writes records in the given format.
+func exportRecords(w io.Writer, format DataFormat, items []TaggedKV) error {
+	switch format {
+	case FormatJSONLines:
+		encoder := json.NewEncoder(w)
+		for _, item := range items {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			row := []string{
+				item.Key,
+				item.Value,
+				strings.Join(item.Tags, " "),
+				formatOptionalTimestamp(item.Created),
+				formatOptionalTimestamp(item.Updated),
+				formatOptionalTimestamp(item.Deleted),
+				formatOptionalTimestamp(item.Expires),
+				fmt.Sprint(item.Version),
+			}
+
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+func formatOptionalTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return formatTimestamp(t)
+}
+
+// Configures an import.
+type importConfig struct {
+	// Rows are validated, but not committed.
+	dryRun bool
+
+	// Valid rows are committed in transactions of this size.
+	batchSize int
+}
+
+// Configures how records are imported.
+type ImportConfigurer func(importConfig *importConfig) *importConfig
+
+func newImportConfig(options ...ImportConfigurer) *importConfig {
+	config := &importConfig{batchSize: defaultImportBatchSize}
+	for _, option := range options {
+		config = option(config)
+	}
+
+	return config
+}
+
+// Validates every row, and reports what would be imported, without committing anything.
+func WithDryRun() ImportConfigurer {
+	return func(importConfig *importConfig) *importConfig {
+		importConfig.dryRun = true
+		return importConfig
+	}
+}
+
+// Sets the number of rows committed per transaction.
+func WithBatchSize(size int) ImportConfigurer {
+	return func(importConfig *importConfig) *importConfig {
+		if size <= 0 {
+			logger.Panic("cannot configure import, batch size must be greater than 0")
+		}
+
+		importConfig.batchSize = size
+		return importConfig
+	}
+}
+
+// The outcome of an import.
+type ImportResult struct {
+	// The number of rows read, excluding the CSV header.
+	Rows int `json:"rows"`
+
+	// The number of rows committed, or that would be committed by a dry run.
+	Imported int `json:"imported"`
+
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Rows that failed validation.  Invalid rows are skipped, and do not stop the import.
+	Errors []ImportRowError `json:"errors"`
+}
+
+// Describes why a row was not imported.
+type ImportRowError struct {
+	// One-based row number, excluding the CSV header.
+	Row     int    `json:"row"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ImportRowError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+	}
+
+	return fmt.Sprintf("row %d, key `%s`: %s", e.Row, e.Key, e.Message)
+}
+
+// A record read from an import.  Imported records replace the value, tags and expiry of existing
+// records with the same key.
+type importRecord struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Tags    []string  `json:"tags"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// Reads records from an import, one at a time.
+type importReader interface {
+	// Returns the next record.  Row errors are returned as *ImportRowError, and reading can
+	// continue.  Returns io.EOF after the last row.
+	next() (importRecord, error)
+}
+
+func newImportReader(r io.Reader, format DataFormat) (importReader, error) {
+	switch format {
+	case FormatJSONLines:
+		return &jsonLinesImportReader{reader: bufio.NewReader(r)}, nil
+	case FormatCSV:
+		return newCsvImportReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+type jsonLinesImportReader struct {
+	reader *bufio.Reader
+	row    int
+}
+
+func (r *jsonLinesImportReader) next() (importRecord, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return importRecord{}, err
+		}
+
+		// Blank lines are not rows.
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		r.row++
+		var record importRecord
+		if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+			message := fmt.Sprintf("invalid JSON: %s", jsonErr)
+			return importRecord{}, &ImportRowError{Row: r.row, Message: message}
+		}
+
+		return record, nil
+	}
+}
+
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	row     int
+}
+
+func newCsvImportReader(r io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header because %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"key", "value"} {
+		if _, found := columns[required]; !found {
+			return nil, fmt.Errorf("CSV header is missing the `%s` column", required)
+		}
+	}
+
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvImportReader) next() (importRecord, error) {
+	fields, err := r.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return importRecord{}, err
+	}
+
+	r.row++
+	if err != nil {
+		return importRecord{}, &ImportRowError{Row: r.row, Message: err.Error()}
+	}
+
+	column := func(name string) string {
+		if i, found := r.columns[name]; found && i < len(fields) {
+			return fields[i]
+		}
+
+		return ""
+	}
+
+	record := importRecord{
+		Key:   column("key"),
+		Value: column("value"),
+		Tags:  strings.Fields(column("tags")),
+	}
+
+	if expires := column("expires"); expires != "" {
+		record.Expires, err = parseTimestamp(expires)
+		if err != nil {
+			message := fmt.Sprintf("invalid expires `%s`", expires)
+			return importRecord{}, &ImportRowError{Row: r.row, Key: record.Key, Message: message}
+		}
+	}
+
+	return record, nil
+}
+
+// Validates an import record.
+func validateImportRecord(record importRecord, now time.Time) error {
+	var err error
+
+	if keyErr := validateKey(record.Key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if valueErr := validateValue(record.Value); valueErr != nil {
+		err = errors.Join(err, valueErr)
+	}
+
+	if tagErr := validateTags(record.Tags); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if !record.Expires.IsZero() && !record.Expires.After(now) {
+		err = errors.Join(err, fmt.Errorf("record has already expired"))
+	}
+
+	return err
+}
+
+// Reads, validates and commits records in batches.
+// Invalid rows are reported in the result.  Other errors stop the import, and rows from earlier
+// batches remain committed.
+func (s *storage) importRecords(r io.Reader, format DataFormat, config *importConfig) (ImportResult, error) {
+	result := ImportResult{DryRun: config.dryRun, Errors: []ImportRowError{}}
+
+	reader, err := newImportReader(r, format)
+	if err != nil {
+		return result, err
+	}
+
+	var batch []importRecord
+	commit := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if !config.dryRun {
+			err := s.update(func(tx *updateTx) error {
+				for _, record := range batch {
+					if err := tx.replace(record); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		result.Imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	now := time.Now()
+	for {
+		record, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *ImportRowError
+		if errors.As(err, &rowErr) {
+			result.Rows++
+			result.Errors = append(result.Errors, *rowErr)
+			continue
+		}
+
+		if err != nil {
+			return result, err
+		}
+
+		result.Rows++
+		if err := validateImportRecord(record, now); err != nil {
+			message := strings.ReplaceAll(err.Error(), "\n", "; ")
+			rowErr := ImportRowError{Row: result.Rows, Key: record.Key, Message: message}
+			result.Errors = append(result.Errors, rowErr)
+			continue
+		}
+
+		batch = append(batch, record)
+		if len(batch) >= config.batchSize {
+			if err := commit(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if err := commit(); err != nil {
+		return result, err
+	}
+
+	logger.Infof(
+		"imported %d of %d row(s), with %d error(s)",
+		result.Imported,
+		result.Rows,
+		len(result.Errors))
+	return result, nil
+}
+
+// Sets the value, tags and expiry of a record, replacing any existing tags.
+// Inputs must already be validated.
+func (u *updateTx) replace(record importRecord) error {
+	if err := u.setWithExpiry(record.Key, record.Value, record.Expires); err != nil {
+		return err
+	}
+
+	taggedKV, err := u.getLive(record.Key)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range taggedKV.Tags {
+		if !slices.Contains(record.Tags, tag) {
+			u.tx.untag(record.Key, tag)
+		}
+	}
+
+	for _, tag := range record.Tags {
+		if err := u.tag(record.Key, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package tagdb
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_storage_importRecords_RoundTripsExport(t *testing.T) {
+	for _, format := range []DataFormat{FormatJSONLines, FormatCSV} {
+		t.Run(format.String(), func(t *testing.T) {
+			// Arrange.
+			source, err := openStorage(osFS{}, nil, t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to connect to storage: %v", err)
+			}
+			defer source.close()
+
+			source.set("key-1", "value, with \"quotes\"")
+			source.tag("key-1", "tag-1")
+			source.tag("key-1", "tag-2")
+			source.setWithTTL("key-2", "value-2", time.Hour)
+			before, _ := source.list([]string{})
+
+			var exported bytes.Buffer
+			if err := exportRecords(&exported, format, before); err != nil {
+				t.Fatalf("exportRecords returned error: %v", err)
+			}
+
+			target, err := openStorage(osFS{}, nil, t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to connect to storage: %v", err)
+			}
+			defer target.close()
+
+			// Act.
+			result, err := target.importRecords(&exported, format, newImportConfig())
+
+			// Assert.
+			if err != nil {
+				t.Fatalf("importRecords returned error: %v", err)
+			}
+
+			if result.Rows != 2 || result.Imported != 2 || len(result.Errors) != 0 {
+				t.Fatalf("Unexpected result %+v", result)
+			}
+
+			after, _ := target.list([]string{})
+			if len(after) != len(before) {
+				t.Fatalf("Expected %d items after import, but found %+v", len(before), after)
+			}
+
+			for i, expected := range before {
+				actual := after[i]
+				if actual.Key != expected.Key || actual.Value != expected.Value ||
+					!slices.Equal(slices.Sorted(slices.Values(actual.Tags)), slices.Sorted(slices.Values(expected.Tags))) ||
+					!actual.Expires.Equal(expected.Expires) {
+					t.Errorf("Item mismatch after import:\nexpected %+v\nactual   %+v", expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func Test_storage_importRecords_ReportsInvalidRows(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	input := strings.Join([]string{
+		`{"key":"key-1","value":"value-1"}`,
+		`{"key":"","value":"value-2"}`,
+		`not json`,
+		``,
+		`{"key":"key-4","value":"value-4","tags":[".system"]}`,
+		`{"key":"key-5","value":"value-5","expires":"2000-01-01T00:00:00Z"}`,
+	}, "\n")
+
+	// Act.
+	result, err := store.importRecords(strings.NewReader(input), FormatJSONLines, newImportConfig())
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("importRecords returned error: %v", err)
+	}
+
+	if result.Rows != 5 || result.Imported != 1 {
+		t.Errorf("Expected 1 of 5 rows imported, found %+v", result)
+	}
+
+	rows := []int{}
+	for _, rowErr := range result.Errors {
+		rows = append(rows, rowErr.Row)
+	}
+
+	if !slices.Equal(rows, []int{2, 3, 4, 5}) {
+		t.Errorf("Expected errors for rows 2 to 5, found %+v", result.Errors)
+	}
+
+	if _, found, _ := store.get("key-1"); !found {
+		t.Errorf("Expected the valid row to be imported")
+	}
+}
+
+func Test_storage_importRecords_DoesNotCommit_OnDryRun(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	input := "key,value\nkey-1,value-1\nkey-2,value-2\n"
+
+	// Act.
+	result, err := store.importRecords(strings.NewReader(input), FormatCSV, newImportConfig(WithDryRun()))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("importRecords returned error: %v", err)
+	}
+
+	if !result.DryRun || result.Imported != 2 {
+		t.Errorf("Expected a dry run of 2 rows, found %+v", result)
+	}
+
+	if items, _ := store.list([]string{}); len(items) != 0 {
+		t.Errorf("Expected no items after a dry run, found %+v", items)
+	}
+}
+
+func Test_storage_importRecords_CommitsInBatches(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	input := "key,value\nkey-1,value-1\nkey-2,value-2\nkey-3,value-3\n"
+	commitsBefore := len(committedTransactionIds(t, store.root))
+
+	// Act.
+	result, err := store.importRecords(strings.NewReader(input), FormatCSV, newImportConfig(WithBatchSize(2)))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("importRecords returned error: %v", err)
+	}
+
+	if result.Imported != 3 {
+		t.Errorf("Expected 3 rows imported, found %+v", result)
+	}
+
+	if commits := len(committedTransactionIds(t, store.root)) - commitsBefore; commits != 2 {
+		t.Errorf("Expected 2 transactions, found %d", commits)
+	}
+}
+
+func Test_storage_importRecords_ReplacesTags(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "old")
+	store.tag("key-1", "kept")
+
+	input := "key,value,tags\nkey-1,value-2,kept new\n"
+
+	// Act.
+	_, err = store.importRecords(strings.NewReader(input), FormatCSV, newImportConfig())
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("importRecords returned error: %v", err)
+	}
+
+	item, _, _ := store.get("key-1")
+	if item.Value != "value-2" || !slices.Equal(slices.Sorted(slices.Values(item.Tags)), []string{"kept", "new"}) {
+		t.Errorf("Expected the value and tags to be replaced, found %+v", item)
+	}
+}
+
+func Test_storage_importRecords_ShouldError_OnMissingCsvColumn(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	// Act.
+	_, err = store.importRecords(strings.NewReader("key,tags\nkey-1,tag-1\n"), FormatCSV, newImportConfig())
+
+	// Assert.
+	if err == nil {
+		t.Errorf("Expected an error for a header without a value column")
+	}
+}
@@ -0,0 +1,311 @@
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+// Implements ReadTx over a read-only transaction.
+type viewTx struct {
+	tx *readOnlyTransaction
+}
+
+func (v *viewTx) List(tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	if err := validateTags(tags); err != nil {
+		return []TaggedKV{}, err
+	}
+
+	page, err := v.tx.list(tags, newReadConfig(options...))
+	return page.Items, err
+}
+
+func (v *viewTx) Get(key string, options ...ReadConfigurer) (taggedKV TaggedKV, found bool, err error) {
+	if err := validateKey(key); err != nil {
+		return TaggedKV{}, false, err
+	}
+
+	return v.tx.get(key, newReadConfig(options...))
+}
+
+// Implements Tx over a read-write transaction.
+type updateTx struct {
+	tx *readWriteTransaction
+}
+
+func (u *updateTx) List(tags []string, options ...ReadConfigurer) ([]TaggedKV, error) {
+	if err := validateTags(tags); err != nil {
+		return []TaggedKV{}, err
+	}
+
+	items, err := u.tx.list(tags)
+	if err != nil {
+		return []TaggedKV{}, err
+	}
+
+	config := newReadConfig(options...)
+	if config.err != nil {
+		return []TaggedKV{}, config.err
+	}
+
+	result := []TaggedKV{}
+	for _, taggedKV := range items {
+		if config.matches(taggedKV) {
+			result = append(result, taggedKV)
+		}
+	}
+
+	page, err := paginate(result, config)
+	return page.Items, err
+}
+
+func (u *updateTx) Get(key string, options ...ReadConfigurer) (taggedKV TaggedKV, found bool, err error) {
+	if err := validateKey(key); err != nil {
+		return TaggedKV{}, false, err
+	}
+
+	config := newReadConfig(options...)
+	if config.err != nil {
+		return TaggedKV{}, false, config.err
+	}
+
+	taggedKV, found, err = u.tx.get(key)
+	if err != nil || !found || !config.matches(taggedKV) {
+		return TaggedKV{}, false, err
+	}
+
+	return taggedKV, true, nil
+}
+
+func (u *updateTx) Set(key, value string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if err := validateValue(value); err != nil {
+		return err
+	}
+
+	return u.set(key, value)
+}
+
+func (u *updateTx) SetWithTTL(key, value string, ttl time.Duration) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if err := validateValue(value); err != nil {
+		return err
+	}
+
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+
+	return u.setWithTTL(key, value, ttl)
+}
+
+func (u *updateTx) CompareAndSet(key, value string, expectedVersion uint64) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if err := validateValue(value); err != nil {
+		return err
+	}
+
+	return u.compareAndSet(key, value, expectedVersion)
+}
+
+func (u *updateTx) Delete(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	return u.delete(key)
+}
+
+func (u *updateTx) Tag(key, tag string) error {
+	var err error
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if tagErr := validateTag(tag); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return u.tag(key, tag)
+}
+
+func (u *updateTx) Untag(key, tag string) error {
+	var err error
+
+	if keyErr := validateKey(key); keyErr != nil {
+		err = errors.Join(err, keyErr)
+	}
+
+	if tagErr := validateTag(tag); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return u.untag(key, tag)
+}
+
+// Creates or updates a record, removing any expiry.  Inputs must already be validated.
+func (u *updateTx) set(key, value string) error {
+	return u.setWithExpiry(key, value, time.Time{})
+}
+
+// Creates or updates a record, which expires after the ttl.  Inputs must already be validated.
+func (u *updateTx) setWithTTL(key, value string, ttl time.Duration) error {
+	return u.setWithExpiry(key, value, u.tx.now.Add(ttl))
+}
+
+func (u *updateTx) setWithExpiry(key, value string, expires time.Time) error {
+	if err := u.validateOpen(); err != nil {
+		return err
+	}
+
+	u.tx.set(key, value)
+
+	// Only record a change of expiry.
+	taggedKV, _, err := u.tx.get(key)
+	if err != nil {
+		return err
+	}
+
+	if !taggedKV.Expires.Equal(expires) {
+		u.tx.expire(key, expires)
+	}
+
+	return nil
+}
+
+// Creates or updates a record, if its version matches.  Inputs must already be validated.
+func (u *updateTx) compareAndSet(key, value string, expectedVersion uint64) error {
+	if err := u.checkVersion(key, expectedVersion); err != nil {
+		return err
+	}
+
+	return u.set(key, value)
+}
+
+// Moves a record to the trash, if its version matches.  Inputs must already be validated.
+func (u *updateTx) compareAndDelete(key string, expectedVersion uint64) error {
+	if err := u.checkVersion(key, expectedVersion); err != nil {
+		return err
+	}
+
+	return u.delete(key)
+}
+
+// Moves a record to the trash.  Inputs must already be validated.
+func (u *updateTx) delete(key string) error {
+	if err := u.validateOpen(); err != nil {
+		return err
+	}
+
+	if _, err := u.getLive(key); err != nil {
+		return err
+	}
+
+	u.tx.systemTag(key, systemTagDeleted, u.tx.timestamp)
+	u.tx.stamp(key)
+
+	return nil
+}
+
+// Adds a tag to a record.  Inputs must already be validated.
+func (u *updateTx) tag(key, tag string) error {
+	if err := u.validateOpen(); err != nil {
+		return err
+	}
+
+	taggedKV, err := u.getLive(key)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(taggedKV.Tags, tag) {
+		logger.Infof("tag `%s` already exists on key `%s`", tag, key)
+		return nil
+	}
+
+	u.tx.tag(key, tag)
+
+	return nil
+}
+
+// Removes a tag from a record.  Inputs must already be validated.
+func (u *updateTx) untag(key, tag string) error {
+	if err := u.validateOpen(); err != nil {
+		return err
+	}
+
+	taggedKV, err := u.getLive(key)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(taggedKV.Tags, tag) {
+		return fmt.Errorf("Tag `%s` not found on key `%s`", tag, key)
+	}
+
+	u.tx.untag(key, tag)
+
+	return nil
+}
+
+func (u *updateTx) validateOpen() error {
+	if !u.tx.isOpen {
+		return fmt.Errorf("cannot update closed transaction %s", u.tx.transactionId)
+	}
+
+	return nil
+}
+
+// Returns a *VersionConflictError when the live record version differs from the expected version.
+// Missing, deleted and expired records have version zero.
+func (u *updateTx) checkVersion(key string, expectedVersion uint64) error {
+	if err := u.validateOpen(); err != nil {
+		return err
+	}
+
+	var actual uint64
+	if taggedKV, err := u.getLive(key); err == nil {
+		actual = taggedKV.Version
+	}
+
+	if actual != expectedVersion {
+		return &VersionConflictError{Key: key, Expected: expectedVersion, Actual: actual}
+	}
+
+	return nil
+}
+
+// Retrieves a record that exists, and has not been deleted or expired.
+func (u *updateTx) getLive(key string) (TaggedKV, error) {
+	taggedKV, found, err := u.tx.get(key)
+	if err != nil {
+		return TaggedKV{}, err
+	}
+
+	if !found || !taggedKV.Deleted.IsZero() || isExpired(taggedKV, u.tx.now) {
+		return TaggedKV{}, fmt.Errorf("key not found `%s` ", key)
+	}
+
+	return taggedKV, nil
+}
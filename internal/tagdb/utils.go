@@ -6,8 +6,8 @@ import (
 	"os"
 )
 
-func fileExists(filePath string) (bool, error) {
-	if _, err := os.Stat(filePath); err != nil {
+func fileExists(fsys fileSystem, filePath string) (bool, error) {
+	if _, err := fsys.Stat(filePath); err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
@@ -18,10 +18,10 @@ func fileExists(filePath string) (bool, error) {
 	return true, nil
 }
 
-func createDirIfNotExists(dir string) error {
-	if _, err := os.Stat(dir); err != nil {
+func createDirIfNotExists(fsys fileSystem, dir string) error {
+	if _, err := fsys.Stat(dir); err != nil {
 		if os.IsNotExist(err) {
-			return os.MkdirAll(dir, 0755)
+			return fsys.MkdirAll(dir, 0755)
 		}
 
 		return err
@@ -30,10 +30,10 @@ func createDirIfNotExists(dir string) error {
 	return nil
 }
 
-func createFileIfNotExists(filePath string) error {
-	if _, err := os.Stat(filePath); err != nil {
+func createFileIfNotExists(fsys fileSystem, filePath string) error {
+	if _, err := fsys.Stat(filePath); err != nil {
 		if os.IsNotExist(err) {
-			file, createErr := os.Create(filePath)
+			file, createErr := fsys.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 			if createErr != nil {
 				return createErr
 			}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -54,6 +55,20 @@ func validateValue(value string) error {
 	return nil
 }
 
+// Validates a record time to live.
+func validateTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be greater than 0")
+	}
+
+	return nil
+}
+
+// Validates user tags, so callers can reject invalid tags before reading or writing.
+func ValidateTags(tags []string) error {
+	return validateTags(tags)
+}
+
 // Validates user tags.
 func validateTags(tags []string) error {
 	var errs error
@@ -69,6 +84,10 @@ func validateTags(tags []string) error {
 
 // Validates a user tag.
 func validateTag(tag string) error {
+	if isSystemTag(tag) {
+		return fmt.Errorf("tag `%s` is a read-only system tag", tag)
+	}
+
 	if !userTagRegexp.MatchString(tag) {
 		return fmt.Errorf("tags must match pattern '%s'", userTagPattern)
 	}
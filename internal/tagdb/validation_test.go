@@ -51,3 +51,11 @@ func Test_validateTags_ShouldRejectInvalidTags(t *testing.T) {
 	// 	t.Errorf("expected error `%s` but got `%s`", err, expectedError)
 	// }
 }
+
+func Test_validateTag_ShouldRejectSystemTags(t *testing.T) {
+	for _, tag := range []string{systemTagCreated, systemTagUpdated, ".custom"} {
+		if err := validateTag(tag); err == nil {
+			t.Errorf("expected error for system tag `%s`, but got none", tag)
+		}
+	}
+}
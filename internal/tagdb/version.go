@@ -0,0 +1,15 @@
+package tagdb
+
+import "fmt"
+
+// Returned when a compare-and-set finds a different record version than expected.
+// Actual is zero when the record does not exist.
+type VersionConflictError struct {
+	Key      string
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict on key `%s`: expected %d, found %d", e.Key, e.Expected, e.Actual)
+}
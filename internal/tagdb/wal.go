@@ -2,55 +2,260 @@ package tagdb
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"path"
+	"slices"
+	"sync"
 
 	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
 )
 
+/*
+Wal files start with a header, followed by framed records.
+
+	| Field    | Size     | Comments                                  |
+	| -------- | -------- | ----------------------------------------- |
+	| Magic    | 8 bytes  | `TAGDBWAL`                                |
+	| Format   | 1 byte   | Record format version.  Always 1.         |
+	| Flags    | 1 byte   | Bit 0 is set when payloads are encrypted. |
+
+	| Field    | Size     | Comments                                  |
+	| -------- | -------- | ----------------------------------------- |
+	| Length   | 4 bytes  | Payload length.  Little endian.           |
+	| Checksum | 4 bytes  | CRC-32C of the length and payload.        |
+	| Payload  | Length   | An encoded operation.                     |
+
+Payloads use the binary operation encoding.  Encrypted files are followed by an encryption header,
+which holds the key id, see encryptionCipher.appendHeader.  Each encrypted payload is a random nonce
+followed by the AES-256-GCM ciphertext, and authenticates the file header and the frame offset as
+additional data.  So frames cannot be moved, reordered or replayed within a file without detection.
+
+Files without a header use the legacy format, text records terminated by a record separator.
+Legacy files are upgraded in place when opened.
+
+Sealed files may also be gzip compressed.  See wal_compression.go.
+*/
+const (
+	walMagic           = "TAGDBWAL"
+	walHeaderSize      = len(walMagic) + 2
+	walFrameHeaderSize = 8
+	walMaxPayloadSize  = 64 * 1024 * 1024
+	walFormatLegacy    = 0
+	walFormatFramed    = 1
+	walFlagEncrypted   = 1
+	walMaxHeaderSize   = walHeaderSize + 1 + encryptionMaxKeyIdSize + encryptionCheckSize
+	walUpgradeSuffix   = ".upgrade"
+)
+
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Returned when a wal file is corrupt before its final record.
+// Unlike a torn final record, this cannot be repaired automatically.
+type WalCorruptionError struct {
+	Path   string
+	Offset int64
+	Reason string
+}
+
+func (e *WalCorruptionError) Error() string {
+	return fmt.Sprintf("wal file `%s` is corrupt at offset %d: %s", e.Path, e.Offset, e.Reason)
+}
+
 // Write-ahead log.
-// TODO: Add mock fs support for testing.
 type wal struct {
-	id   int64
-	file *os.File
-	rw   *bufio.ReadWriter
+	id     int64
+	path   string
+	header walHeader
+	file   fsFile
+	rw     *bufio.ReadWriter
+
+	// The size of the file, including buffered writes.  The offset of the next frame.
+	size int64
+
+	// Guards syncing against closing, as syncs run outside the storage lock.
+	syncMu sync.Mutex
+	closed bool
 }
 
-func openWal(id int64, path string) (*wal, error) {
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+func openWal(fsys fileSystem, keys *keyring, id int64, walPath string) (*wal, error) {
+	file, err := fsys.OpenFile(walPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		logger.Errorf("failed to open wal file `%s` because `%s`", walPath, err)
+		return nil, err
+	}
+	logger.Infof("opened wal file `%s`", walPath)
+
+	header, created, err := readWalHeader(file, keys)
+	if err != nil {
+		file.Close()
+		logger.Errorf("failed to read wal file header `%s` because `%s`", walPath, err)
+		return nil, err
+	}
+
+	// A new file is only durable once its directory entry is synced.
+	if created {
+		if err := fsys.SyncDir(path.Dir(walPath)); err != nil {
+			file.Close()
+			logger.Errorf("failed to sync wal directory of `%s` because `%s`", walPath, err)
+			return nil, err
+		}
+	}
+
+	info, err := file.Stat()
 	if err != nil {
-		logger.Errorf("failed to open wal file `%s` because `%s`", path, err)
+		file.Close()
+		logger.Errorf("failed to stat wal file `%s` because `%s`", walPath, err)
 		return nil, err
 	}
-	logger.Infof("opened wal file `%s`", path)
 
 	reader := bufio.NewReader(file)
 	writer := bufio.NewWriter(file)
 	readWriter := bufio.NewReadWriter(reader, writer)
 
 	wal := &wal{
-		id:   id,
-		file: file,
-		rw:   readWriter,
+		id:     id,
+		path:   walPath,
+		header: header,
+		file:   file,
+		rw:     readWriter,
+		size:   info.Size(),
 	}
 
 	return wal, nil
 }
 
-func (w *wal) flush() {
+// The parsed header of a wal file.
+type walHeader struct {
+	format int
+
+	// Encrypts and decrypts payloads.  Nil unless the file is encrypted.
+	cipher *encryptionCipher
+
+	// The header, authenticated with every encrypted payload.  Empty for the legacy format.
+	data []byte
+
+	// Set when the file is gzip compressed.  Compressed files are sealed, so cannot be written.
+	compressed bool
+}
+
+// Creates the header for a new wal file.  The file is encrypted when the cipher is set.
+func newWalHeader(c *encryptionCipher) walHeader {
+	data := append([]byte(walMagic), walFormatFramed)
+	if c == nil {
+		return walHeader{format: walFormatFramed, data: append(data, 0)}
+	}
+
+	data = c.appendHeader(append(data, walFlagEncrypted))
+	return walHeader{format: walFormatFramed, cipher: c, data: data}
+}
+
+// Tests if operations can be written in the header format.  Legacy files must be upgraded first.
+func (h walHeader) isWritable() bool {
+	return h.format == walFormatFramed
+}
+
+// Reads the file header.  Empty files are given a framed header, encrypted with the active key, and
+// reported as created.
+func readWalHeader(file fsFile, keys *keyring) (header walHeader, created bool, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return walHeader{}, false, err
+	}
+
+	if info.Size() == 0 {
+		header := newWalHeader(keys.activeCipher())
+		if _, err := file.Write(header.data); err != nil {
+			return walHeader{}, false, err
+		}
+
+		return header, true, file.Sync()
+	}
+
+	data := make([]byte, walMaxHeaderSize)
+	n, err := file.ReadAt(data, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return walHeader{}, false, err
+	}
+
+	if isCompressedWal(data[:n]) {
+		header, err = readCompressedWalHeader(file, info.Size(), keys)
+		return header, false, err
+	}
+
+	header, err = parseWalHeader(data[:n], keys)
+	return header, false, err
+}
+
+// Parses the header from the start of a wal file.  Files without a header use the legacy format.
+// Encrypted files return ErrMissingEncryptionKey or ErrWrongEncryptionKey when the key is not
+// configured.
+func parseWalHeader(data []byte, keys *keyring) (walHeader, error) {
+	if len(data) < walHeaderSize || string(data[:len(walMagic)]) != walMagic {
+		return walHeader{format: walFormatLegacy}, nil
+	}
+
+	if format := int(data[len(walMagic)]); format != walFormatFramed {
+		return walHeader{}, fmt.Errorf("unsupported wal format %d", format)
+	}
+
+	switch flags := data[len(walMagic)+1]; flags {
+	case 0:
+		return walHeader{format: walFormatFramed, data: slices.Clone(data[:walHeaderSize])}, nil
+
+	case walFlagEncrypted:
+		c, size, err := parseEncryptionHeader(data[walHeaderSize:], keys)
+		if err != nil {
+			return walHeader{}, err
+		}
+
+		data = data[:walHeaderSize+size]
+		return walHeader{format: walFormatFramed, cipher: c, data: slices.Clone(data)}, nil
+
+	default:
+		return walHeader{}, fmt.Errorf("unsupported wal flags %d", flags)
+	}
+}
+
+func (w *wal) flush() error {
 	logger.Info("flushing wal")
 	if err := w.rw.Flush(); err != nil {
 		logger.Warnf("failed to flush wal: %s", err)
+		return err
 	}
+
+	return nil
+}
+
+// Syncs written operations to disk.  Safe to call without the storage lock.
+// Closed files were synced when they were closed.
+func (w *wal) sync() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	return w.file.Sync()
 }
 
 func (w *wal) close() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
 	logger.Info("closing wal connection")
 	flushErr := w.rw.Flush()
+	syncErr := w.file.Sync()
 	closeErr := w.file.Close()
+	w.closed = true
 
-	err := errors.Join(flushErr, closeErr)
+	err := errors.Join(flushErr, syncErr, closeErr)
 	if err != nil {
 		logger.Errorf("could not cleanly close wal because %s", err)
 	}
@@ -58,6 +263,9 @@ func (w *wal) close() error {
 	return err
 }
 
+// Reads the operations of committed transactions.
+// A torn final record, left by a crash during a write, is truncated.  Corruption before the final
+// record returns a *WalCorruptionError.
 func (w *wal) read() ([]operator, error) {
 	// Move to start of file.
 	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
@@ -72,51 +280,297 @@ func (w *wal) read() ([]operator, error) {
 	}()
 
 	// Read contents.
-	committedTx := map[string]bool{}
-	buf := []operator{}
-	scanner := bufio.NewScanner(w.rw)
-	scanner.Split(opSplit)
-	for scanner.Scan() {
-		data := scanner.Bytes()
-		op, err := deserialize(data)
-		if err != nil {
-			logger.Errorf("failed to deserialize wal record: %s", err)
+	data, err := io.ReadAll(w.file)
+	if err != nil {
+		logger.Errorf("failed to read wal file: %s", err)
+		return nil, err
+	}
+
+	if w.header.compressed {
+		if data, err = decompressWal(data); err != nil {
+			logger.Errorf("failed to decompress wal file: %s", err)
+			return nil, err
+		}
+	}
+
+	buf, validSize, err := parseWal(w.path, w.header, data)
+	if err != nil {
+		logger.Errorf("failed to read wal file: %s", err)
+		return nil, err
+	}
+
+	// Repair a torn tail.  Compressed files are written whole, so cannot be torn.
+	if validSize < int64(len(data)) && !w.header.compressed {
+		logger.Warnf("truncating torn record at offset %d of wal file `%s`", validSize, w.path)
+		if err := w.file.Truncate(validSize); err != nil {
 			return nil, err
 		}
 
-		if _, isCommit := op.(*commitOperation); isCommit {
-			committedTx[op.(*commitOperation).transactionId] = true
+		if err := w.file.Sync(); err != nil {
+			return nil, err
 		}
+		w.size = validSize
+	}
+
+	return committedOperations(buf), nil
+}
+
+// Reads the operations of committed transactions from a wal file, without opening it for writes.
+// A torn final record is ignored rather than truncated, so the file can be in use elsewhere.
+func readWalFile(fsys fileSystem, keys *keyring, path string) ([]operator, error) {
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = decompressWal(data); err != nil {
+		return nil, err
+	}
 
-		buf = append(buf, op)
+	header, err := parseWalHeader(data, keys)
+	if err != nil {
+		return nil, err
 	}
 
-	if scanner.Err() != nil {
-		logger.Errorf("failed to scan wal file: %s", scanner.Err())
-		return nil, scanner.Err()
+	buf, _, err := parseWal(path, header, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return committedOperations(buf), nil
+}
+
+// Filters for the operations of committed transactions only.
+func committedOperations(ops []operator) []operator {
+	committedTx := map[string]bool{}
+	for _, op := range ops {
+		if commit, isCommit := op.(*commitOperation); isCommit {
+			committedTx[commit.transactionId] = true
+		}
 	}
 
-	// Filter for committed transactions only.
 	var result []operator
-	for _, op := range buf {
+	for _, op := range ops {
 		if committedTx[op.getTransactionId()] {
 			result = append(result, op)
 		}
 	}
 
-	return result, nil
+	return result
+}
+
+// Parses the contents of a wal file.  Returns the operations, and the size of the valid data.
+func parseWal(path string, header walHeader, data []byte) ([]operator, int64, error) {
+	if header.format == walFormatLegacy {
+		return readLegacy(path, data)
+	}
+
+	return readFramed(path, header, data)
+}
+
+// Reads framed records.  Returns the operations, and the size of the valid data.
+// An invalid frame is torn, when no valid frame follows it.  Otherwise the file is corrupt.
+func readFramed(path string, header walHeader, data []byte) ([]operator, int64, error) {
+	var result []operator
+
+	offset := len(header.data)
+	for offset < len(data) {
+		payload, end, reason := readFrame(data, offset)
+		if reason != "" {
+			if hasFrameAfter(data, offset) {
+				return nil, 0, &WalCorruptionError{Path: path, Offset: int64(offset), Reason: reason}
+			}
+
+			return result, int64(offset), nil
+		}
+
+		op, err := header.decodePayload(payload, offset)
+		if err != nil {
+			return nil, 0, &WalCorruptionError{Path: path, Offset: int64(offset), Reason: err.Error()}
+		}
+
+		result = append(result, op)
+		offset = end
+	}
+
+	return result, int64(offset), nil
+}
+
+// Reads the frame at offset.  Returns its payload and end, or the reason it is invalid.
+func readFrame(data []byte, offset int) (payload []byte, end int, reason string) {
+	if len(data)-offset < walFrameHeaderSize {
+		return nil, 0, "incomplete frame header"
+	}
+
+	length := int(binary.LittleEndian.Uint32(data[offset:]))
+	checksum := binary.LittleEndian.Uint32(data[offset+4:])
+	if length == 0 || length > walMaxPayloadSize {
+		return nil, 0, fmt.Sprintf("invalid payload length %d", length)
+	}
+
+	end = offset + walFrameHeaderSize + length
+	if end > len(data) {
+		return nil, 0, "payload extends past end of file"
+	}
+
+	payload = data[offset+walFrameHeaderSize : end]
+	if frameChecksum(data[offset:offset+4], payload) != checksum {
+		return nil, 0, "checksum mismatch"
+	}
+
+	return payload, end, ""
+}
+
+// Tests if a valid frame starts anywhere after offset.  A torn write can only damage the final
+// frame, so an invalid frame followed by a valid one is corruption.
+func hasFrameAfter(data []byte, offset int) bool {
+	for i := offset + 1; len(data)-i >= walFrameHeaderSize; i++ {
+		if _, _, reason := readFrame(data, i); reason == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the checksum of a frame, from its encoded length and payload.
+func frameChecksum(length []byte, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(length, walChecksumTable), walChecksumTable, payload)
+}
+
+// Reads records terminated by a record separator.  An unterminated final record is torn.
+func readLegacy(path string, data []byte) ([]operator, int64, error) {
+	var result []operator
+
+	offset := 0
+	for offset < len(data) {
+		i := bytes.IndexByte(data[offset:], opRecordSeparatorByte)
+		if i < 0 {
+			return result, int64(offset), nil
+		}
+
+		end := offset + i + 1
+		op, err := deserialize(data[offset:end])
+		if err != nil {
+			return nil, 0, &WalCorruptionError{Path: path, Offset: int64(offset), Reason: err.Error()}
+		}
+
+		result = append(result, op)
+		offset = end
+	}
+
+	return result, int64(offset), nil
 }
 
+// Decodes the payload of the frame at offset, decrypting it when the file is encrypted.
+func (h walHeader) decodePayload(payload []byte, offset int) (operator, error) {
+	if h.cipher == nil {
+		return decode(payload)
+	}
+
+	plaintext, err := h.cipher.open(payload, h.additionalData(offset))
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(plaintext)
+}
+
+// Encodes an operation as the payload of the frame at offset, encrypting it when the file is
+// encrypted.
+func (h walHeader) encodePayload(op operator, offset int) []byte {
+	if h.cipher == nil {
+		return encode(op)
+	}
+
+	return h.cipher.seal(encode(op), h.additionalData(offset))
+}
+
+// Returns the data authenticated with the payload of the frame at offset.
+func (h walHeader) additionalData(offset int) []byte {
+	return binary.LittleEndian.AppendUint64(slices.Clone(h.data), uint64(offset))
+}
+
+// Writes and flushes operations.  A failed write may leave a torn record at the end of the file,
+// and the buffered writer keeps the error, so later writes fail until the wal is reopened.
 func (w *wal) write(ops []operator) error {
-	defer w.flush()
+	if !w.header.isWritable() {
+		return fmt.Errorf("cannot write to legacy wal file `%s`", w.path)
+	}
+
+	if w.header.compressed {
+		return fmt.Errorf("cannot write to compressed wal file `%s`", w.path)
+	}
 
 	logger.Infof("writing %d operation(s) to wal", len(ops))
 	for _, op := range ops {
-		data := op.serialize()
-		if _, err := w.rw.Write(data); err != nil {
+		frame := appendFrame(nil, w.header.encodePayload(op, int(w.size)))
+		if _, err := w.rw.Write(frame); err != nil {
 			return err
 		}
+		w.size += int64(len(frame))
 	}
 
-	return nil
+	return w.flush()
+}
+
+// Appends a framed payload to the buffer.
+func appendFrame(buf []byte, payload []byte) []byte {
+	length := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+
+	buf = append(buf, length...)
+	buf = binary.LittleEndian.AppendUint32(buf, frameChecksum(length, payload))
+	return append(buf, payload...)
+}
+
+// Rewrites a legacy wal file in the framed format, and returns the reopened file.
+// Only committed transactions are kept.  See writeWalFile.
+func upgradeWal(fsys fileSystem, keys *keyring, w *wal) (*wal, error) {
+	logger.Infof("upgrading legacy wal file `%s`", w.path)
+
+	ops, err := w.read()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.close(); err != nil {
+		return nil, err
+	}
+
+	if err := writeWalFile(fsys, keys, w.path, ops); err != nil {
+		return nil, err
+	}
+
+	return openWal(fsys, keys, w.id, w.path)
+}
+
+// Writes operations to a framed wal file, encrypted with the active key.
+// The new file replaces any existing file atomically, so a failed write leaves the old file intact.
+func writeWalFile(fsys fileSystem, keys *keyring, walPath string, ops []operator) error {
+	header := newWalHeader(keys.activeCipher())
+	data := slices.Clone(header.data)
+	for _, op := range ops {
+		data = appendFrame(data, header.encodePayload(op, len(data)))
+	}
+
+	tempPath := walPath + walUpgradeSuffix
+	file, err := fsys.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, writeErr := file.Write(data)
+	syncErr := file.Sync()
+	closeErr := file.Close()
+	if err := errors.Join(writeErr, syncErr, closeErr); err != nil {
+		fsys.Remove(tempPath)
+		return err
+	}
+
+	if err := fsys.Rename(tempPath, walPath); err != nil {
+		fsys.Remove(tempPath)
+		return err
+	}
+
+	return fsys.SyncDir(path.Dir(walPath))
 }
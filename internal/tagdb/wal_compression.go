@@ -0,0 +1,262 @@
+package tagdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+/*
+Sealed wal files, which are never written again once the wal has rolled, are gzip compressed in
+place.  A compressed file is the gzip of the whole wal file, under the same name, so listing,
+archiving and recovery work unchanged.  Readers detect compression from the gzip magic.
+
+Encrypted files are not compressed, as ciphertext does not compress.
+*/
+const walCompressSuffix = ".gz.tmp"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Tests if wal file data is gzip compressed.
+func isCompressedWal(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// Decompresses wal file data.  Uncompressed data is returned unchanged.
+func decompressWal(data []byte) ([]byte, error) {
+	if !isCompressedWal(data) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+func compressWal(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Reads the header of a compressed wal file, decompressing only the start of the file.
+func readCompressedWalHeader(file io.ReaderAt, size int64, keys *keyring) (walHeader, error) {
+	reader, err := gzip.NewReader(io.NewSectionReader(file, 0, size))
+	if err != nil {
+		return walHeader{}, err
+	}
+
+	data := make([]byte, walMaxHeaderSize)
+	n, err := io.ReadFull(reader, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return walHeader{}, err
+	}
+
+	header, err := parseWalHeader(data[:n], keys)
+	header.compressed = true
+	return header, err
+}
+
+// Returns the size of a wal file on disk, and its size once decompressed.
+// The decompressed size is read from the gzip trailer, which holds the size modulo 4 GiB.  Wal
+// files roll well before then.
+func (w *wal) sizes() (size, uncompressedSize int64, err error) {
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size = info.Size()
+	if !w.header.compressed || size < 4 {
+		return size, size, nil
+	}
+
+	trailer := make([]byte, 4)
+	if _, err := w.file.ReadAt(trailer, size-4); err != nil {
+		return 0, 0, err
+	}
+
+	return size, int64(binary.LittleEndian.Uint32(trailer)), nil
+}
+
+// The size and modification time of a wal file, used to detect writes while it is compressed.
+type walFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func (w *wal) state() (walFileState, error) {
+	info, err := w.file.Stat()
+	if err != nil {
+		return walFileState{}, err
+	}
+
+	return walFileState{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// Tests if a wal file is sealed, and can be compressed.  Only the current file is written, and
+// transactions resolve it under the storage lock, so no writer holds a sealed file.
+func (wm *walManager) isCompressible(id int64) bool {
+	w, found := wm.walFiles[id]
+	return found && id < wm.currentId && !w.header.compressed && w.header.cipher == nil
+}
+
+// Returns the ids of sealed wal files that can be compressed, in ascending order.
+func (wm *walManager) compressible() []int64 {
+	var result []int64
+	for _, id := range slices.Sorted(maps.Keys(wm.walFiles)) {
+		if wm.isCompressible(id) {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+// Replaces a sealed wal file with its compressed copy, then reopens it.
+// Does nothing when the file was compacted, compressed or changed since the copy was written, so
+// the copy may be missing writes.  Returns false when the file was not replaced.
+func (wm *walManager) replaceCompressed(id int64, tempPath string, copied walFileState) (bool, error) {
+	if !wm.isCompressible(id) {
+		return false, wm.fsys.Remove(tempPath)
+	}
+
+	w := wm.walFiles[id]
+	state, err := w.state()
+	if err != nil {
+		wm.fsys.Remove(tempPath)
+		return false, err
+	}
+
+	if state != copied {
+		logger.Warnf("not replacing wal file `%s`, because it changed while being compressed", w.path)
+		return false, wm.fsys.Remove(tempPath)
+	}
+
+	if err := w.close(); err != nil {
+		wm.fsys.Remove(tempPath)
+		return false, err
+	}
+
+	renameErr := wm.fsys.Rename(tempPath, w.path)
+	if renameErr != nil {
+		wm.fsys.Remove(tempPath)
+	} else {
+		renameErr = wm.fsys.SyncDir(path.Dir(w.path))
+	}
+
+	// Reopen even when the rename failed, as the original file is still in place.
+	reopened, err := openWal(wm.fsys, wm.keys, id, w.path)
+	if err != nil {
+		return false, errors.Join(renameErr, err)
+	}
+	wm.walFiles[id] = reopened
+
+	return renameErr == nil, renameErr
+}
+
+// Compresses sealed wal files.  Returns the number of bytes saved.
+// Files are read and compressed under the read lock, so only the final swap blocks writes.  The
+// swap is skipped when the file changed in between.
+func (s *storage) compressWal() (int64, error) {
+	s.mu.RLock()
+	candidates := s.walManager.compressible()
+	s.mu.RUnlock()
+
+	var saved int64
+	for _, id := range candidates {
+		s.mu.RLock()
+		walPath, copied, size, err := s.writeCompressedWal(id)
+		s.mu.RUnlock()
+		if err != nil {
+			return saved, logger.Errorf("cannot compress wal file %d because %s", id, err)
+		}
+
+		if walPath == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		replaced, err := s.walManager.replaceCompressed(id, walPath+walCompressSuffix, copied)
+		s.mu.Unlock()
+		if err != nil {
+			return saved, logger.Errorf("cannot replace wal file `%s` because %s", walPath, err)
+		}
+
+		if !replaced {
+			continue
+		}
+
+		saved += size
+		logger.Infof("compressed wal file `%s`, saving %d byte(s)", walPath, size)
+	}
+
+	return saved, nil
+}
+
+// Writes a compressed copy of a sealed wal file.  Returns the path of the file, its state when
+// copied, and the number of bytes saved.  The path is empty when the file can no longer be
+// compressed.  The caller must hold the storage lock.
+func (s *storage) writeCompressedWal(id int64) (string, walFileState, int64, error) {
+	if !s.walManager.isCompressible(id) {
+		return "", walFileState{}, 0, nil
+	}
+
+	w := s.walManager.walFiles[id]
+	copied, err := w.state()
+	if err != nil {
+		return "", walFileState{}, 0, err
+	}
+
+	data, err := s.fsys.ReadFile(w.path)
+	if err != nil {
+		return "", walFileState{}, 0, err
+	}
+
+	compressed, err := compressWal(data)
+	if err != nil {
+		return "", walFileState{}, 0, err
+	}
+
+	tempPath := w.path + walCompressSuffix
+	file, err := s.fsys.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", walFileState{}, 0, err
+	}
+
+	_, writeErr := file.Write(compressed)
+	syncErr := file.Sync()
+	closeErr := file.Close()
+	if err := errors.Join(writeErr, syncErr, closeErr); err != nil {
+		s.fsys.Remove(tempPath)
+		return "", walFileState{}, 0, err
+	}
+
+	return w.path, copied, int64(len(data) - len(compressed)), nil
+}
+
+func (s *storage) maybeCompressWal() {
+	if _, err := s.compressWal(); err != nil {
+		logger.Warnf("failed to compress wal because %s", err)
+	}
+}
@@ -0,0 +1,299 @@
+package tagdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+const compressionTestRoot = "/db"
+
+// Writes text-heavy records to a wal file, then rolls past it.
+func writeSealedWal(t *testing.T, store *storage) {
+	t.Helper()
+
+	value := string(bytes.Repeat([]byte("compressible text "), 20))
+	for i := range 50 {
+		if _, err := store.set(fmt.Sprintf("key-%d", i), value); err != nil {
+			t.Fatalf("Failed to set record: %v", err)
+		}
+	}
+
+	store.maybeRoll(1)
+}
+
+func Test_storage_compressWal_ShouldCompressSealedFiles(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, compressionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+	writeSealedWal(t, store)
+
+	// Act.
+	saved, err := store.compressWal()
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to compress wal: %v", err)
+	}
+
+	if saved <= 0 {
+		t.Errorf("Expected compression to save space, found %d byte(s)", saved)
+	}
+
+	sealed, _ := fsys.ReadFile(path.Join(compressionTestRoot, "wal", "0"+walFileExtension))
+	if !isCompressedWal(sealed) {
+		t.Errorf("Expected the sealed wal file to be compressed")
+	}
+
+	current, _ := fsys.ReadFile(path.Join(compressionTestRoot, "wal", "1"+walFileExtension))
+	if isCompressedWal(current) {
+		t.Errorf("Expected the current wal file to not be compressed")
+	}
+
+	stats, err := store.stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+
+	if stats.CompressedWalFiles != 1 || stats.WalBytesSaved != saved {
+		t.Errorf("Expected stats to report 1 compressed file saving %d byte(s), found %+v", saved, stats)
+	}
+}
+
+func Test_openStorage_ShouldReadCompressedWal(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, compressionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	writeSealedWal(t, store)
+	store.compressWal()
+	store.set("after", "value")
+	store.close()
+
+	// Act.
+	reopened, err := openStorage(fsys, nil, compressionTestRoot)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.close()
+
+	if stats, _ := reopened.stats(); stats.Records != 51 {
+		t.Errorf("Expected 51 records after reopen, found %d", stats.Records)
+	}
+
+	if _, err := reopened.set("reopened", "value"); err != nil {
+		t.Errorf("Expected writes after reopen to succeed, found %v", err)
+	}
+}
+
+func Test_readWalFile_ShouldReadCompressedFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, compressionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	writeSealedWal(t, store)
+	store.compressWal()
+	store.close()
+
+	// Act.
+	ops, err := readWalFile(fsys, nil, path.Join(compressionTestRoot, "wal", "0"+walFileExtension))
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to read compressed wal file: %v", err)
+	}
+
+	if len(ops) == 0 {
+		t.Errorf("Expected operations from the compressed wal file")
+	}
+}
+
+func Test_storage_compressWal_ShouldSkipEncryptedFiles(t *testing.T) {
+	// Arrange.
+	keys := testKeyring(t, "", testEncryptionKey("key-a", 1))
+	store, err := openStorage(newMemFS(), keys, compressionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+	writeSealedWal(t, store)
+
+	// Act.
+	saved, err := store.compressWal()
+
+	// Assert.
+	if err != nil || saved != 0 {
+		t.Errorf("Expected encrypted files to be skipped, found %d byte(s) saved and error %v", saved, err)
+	}
+}
+
+func Test_walManager_replaceCompressed_ShouldDiscardCopy_OfCompactedFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, compressionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+	writeSealedWal(t, store)
+
+	walPath, copied, _, err := store.writeCompressedWal(0)
+	if err != nil {
+		t.Fatalf("Failed to write compressed copy: %v", err)
+	}
+	tempPath := walPath + walCompressSuffix
+	store.snapshot(false)
+
+	// Act.
+	replaced, err := store.walManager.replaceCompressed(0, tempPath, copied)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to replace compressed file: %v", err)
+	}
+
+	if replaced {
+		t.Errorf("Expected the compacted wal file not to be replaced")
+	}
+
+	if exists, _ := fileExists(fsys, tempPath); exists {
+		t.Errorf("Expected the compressed copy to be removed")
+	}
+
+	if exists, _ := fileExists(fsys, walPath); exists {
+		t.Errorf("Expected the compacted wal file to stay removed")
+	}
+}
+
+func Test_walManager_replaceCompressed_ShouldDiscardCopy_OfChangedFile(t *testing.T) {
+	// Arrange.
+	fsys := newMemFS()
+	store, err := openStorage(fsys, nil, compressionTestRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+	writeSealedWal(t, store)
+
+	walPath, copied, _, err := store.writeCompressedWal(0)
+	if err != nil {
+		t.Fatalf("Failed to write compressed copy: %v", err)
+	}
+	tempPath := walPath + walCompressSuffix
+
+	file, err := fsys.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open wal file: %v", err)
+	}
+	file.Write([]byte{0})
+	file.Close()
+
+	// Act.
+	replaced, err := store.walManager.replaceCompressed(0, tempPath, copied)
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to replace compressed file: %v", err)
+	}
+
+	if replaced {
+		t.Errorf("Expected the changed wal file not to be replaced")
+	}
+
+	if exists, _ := fileExists(fsys, tempPath); exists {
+		t.Errorf("Expected the compressed copy to be removed")
+	}
+
+	if store.walManager.walFiles[0].header.compressed {
+		t.Errorf("Expected the wal file to stay uncompressed")
+	}
+}
+
+func Test_storage_compressWal_RetainsConcurrentCommitsAfterReopen(t *testing.T) {
+	// Arrange.
+	storeRoot := t.TempDir()
+	store, err := openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+
+	const writers, writes = 8, 50
+
+	// Act.
+	var wg sync.WaitGroup
+	acknowledged := make(chan string, writers*writes)
+	errs := make(chan error, writers*writes)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if _, err := store.set(key, "value"); err != nil {
+					errs <- err
+					continue
+				}
+				acknowledged <- key
+			}
+		}()
+	}
+
+	// Roll and compress until the writers finish.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+		default:
+			store.maybeRoll(0)
+			if _, err := store.compressWal(); err != nil {
+				t.Errorf("compressWal returned error: %v", err)
+			}
+			continue
+		}
+		break
+	}
+	close(acknowledged)
+	close(errs)
+	store.close()
+
+	// Assert.
+	for err := range errs {
+		t.Errorf("set returned error: %v", err)
+	}
+
+	store, err = openStorage(osFS{}, nil, storeRoot)
+	if err != nil {
+		t.Fatalf("Failed to reconnect to storage: %v", err)
+	}
+	defer store.close()
+
+	count := 0
+	for key := range acknowledged {
+		count++
+		if _, found, _ := store.get(key); !found {
+			t.Errorf("expected acknowledged key `%s` after reopen", key)
+		}
+	}
+
+	if count != writers*writes {
+		t.Errorf("expected %d acknowledged writes, found %d", writers*writes, count)
+	}
+}
@@ -2,8 +2,10 @@ package tagdb
 
 import (
 	"errors"
-	"os"
+	"fmt"
+	"maps"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -15,19 +17,22 @@ const (
 )
 
 type walManager struct {
+	fsys      fileSystem
+	keys      *keyring
 	walRoot   string
 	currentId int64
 	walFiles  map[int64]*wal
 }
 
-func newWalManager(walRoot string) (*walManager, error) {
-	err := createDirIfNotExists(walRoot)
+// Opens the wal files in the root.  When there are none, the first file is created with firstId.
+func newWalManager(fsys fileSystem, keys *keyring, walRoot string, firstId int64) (*walManager, error) {
+	err := createDirIfNotExists(fsys, walRoot)
 	if err != nil {
 		innerErr := logger.Error("failed to get or create wal directory")
 		return nil, errors.Join(err, innerErr)
 	}
 
-	wals, currentId, err := openWals(walRoot)
+	wals, currentId, err := openWals(fsys, keys, walRoot)
 	if err != nil {
 		innerErr := logger.Error("failed to list wal files")
 		return nil, errors.Join(err, innerErr)
@@ -35,22 +40,26 @@ func newWalManager(walRoot string) (*walManager, error) {
 
 	// Ensure there is at least one wal file.
 	if len(wals) == 0 {
-		walPath := path.Join(walRoot, "0"+walFileExtension)
-		wal, err := openWal(0, walPath)
+		walPath := path.Join(walRoot, strconv.FormatInt(firstId, 10)+walFileExtension)
+		wal, err := openWal(fsys, keys, firstId, walPath)
 		if err != nil {
 			innerErr := logger.Error("failed to create initial wal file")
 			return nil, errors.Join(err, innerErr)
 		}
 
-		wals[0] = wal
-		currentId = 0
+		wals[firstId] = wal
+		currentId = firstId
 	}
 
-	return &walManager{
+	wm := &walManager{
+		fsys:      fsys,
+		keys:      keys,
 		walRoot:   walRoot,
 		currentId: currentId,
 		walFiles:  wals,
-	}, nil
+	}
+
+	return wm, nil
 }
 
 func (wm *walManager) close() error {
@@ -88,10 +97,16 @@ func (wm *walManager) shouldRoll(rollWalAfterBytes int64) bool {
 }
 
 func (wm *walManager) roll() {
+	// Later commits are synced in the new file, so the old file must be synced first.
+	if err := wm.current().sync(); err != nil {
+		logger.Warnf("failed to sync wal file %d because `%s`", wm.currentId, err)
+		return
+	}
+
 	nextId := wm.currentId + 1
 	nextIdStr := strconv.FormatInt(nextId, 10)
 	walPath := path.Join(wm.walRoot, nextIdStr+walFileExtension)
-	wal, err := openWal(nextId, walPath)
+	wal, err := openWal(wm.fsys, wm.keys, nextId, walPath)
 	if err != nil {
 		logger.Warnf("failed to create new wal file `%s` because `%s`", walPath, err)
 		return
@@ -102,11 +117,57 @@ func (wm *walManager) roll() {
 	logger.Infof("rolled wal file to %d", wm.currentId)
 }
 
-func openWals(walRoot string) (files map[int64]*wal, currentId int64, err error) {
+// Returns the ids of the wal files from firstId onwards, in ascending order.
+func (wm *walManager) idsFrom(firstId int64) []int64 {
+	var result []int64
+	for _, id := range slices.Sorted(maps.Keys(wm.walFiles)) {
+		if id >= firstId {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+// Closes and removes wal files before the given id.
+// Files are moved to the archive directory, or deleted when the archive directory is empty.
+func (wm *walManager) compact(beforeId int64, archiveDir string) error {
+	if beforeId > wm.currentId {
+		return fmt.Errorf("cannot compact the current wal file %d", wm.currentId)
+	}
+
+	var err error
+	for _, id := range slices.Sorted(maps.Keys(wm.walFiles)) {
+		if id >= beforeId {
+			break
+		}
+
+		if closeErr := wm.walFiles[id].close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+			continue
+		}
+		delete(wm.walFiles, id)
+
+		name := strconv.FormatInt(id, 10) + walFileExtension
+		walPath := path.Join(wm.walRoot, name)
+		if archiveDir == "" {
+			err = errors.Join(err, wm.fsys.Remove(walPath))
+			logger.Infof("deleted wal file `%s`", walPath)
+			continue
+		}
+
+		err = errors.Join(err, wm.fsys.Rename(walPath, path.Join(archiveDir, name)))
+		logger.Infof("archived wal file `%s`", walPath)
+	}
+
+	return err
+}
+
+func openWals(fsys fileSystem, keys *keyring, walRoot string) (files map[int64]*wal, currentId int64, err error) {
 	result := map[int64]*wal{}
 	maxId := int64(-1)
 
-	dirEntries, err := os.ReadDir(walRoot)
+	dirEntries, err := fsys.ReadDir(walRoot)
 	if err != nil {
 		logger.Errorf("failed to read wal directory `%s` because `%s`", walRoot, err)
 		return result, maxId, err
@@ -125,12 +186,20 @@ func openWals(walRoot string) (files map[int64]*wal, currentId int64, err error)
 		}
 
 		walPath := path.Join(walRoot, entry.Name())
-		wal, err := openWal(id, walPath)
+		wal, err := openWal(fsys, keys, id, walPath)
 		if err != nil {
 			logger.Errorf("failed to open wal file `%s` because `%s`", walPath, err)
 			return result, maxId, err
 		}
 
+		if !wal.header.isWritable() {
+			wal, err = upgradeWal(fsys, keys, wal)
+			if err != nil {
+				logger.Errorf("failed to upgrade wal file `%s` because `%s`", walPath, err)
+				return result, maxId, err
+			}
+		}
+
 		result[id] = wal
 		if id > maxId {
 			maxId = id
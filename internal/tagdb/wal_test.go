@@ -1,6 +1,8 @@
 package tagdb
 
 import (
+	"errors"
+	"os"
 	"path"
 	"reflect"
 	"testing"
@@ -28,7 +30,7 @@ func Test_RoundTrips(t *testing.T) {
 	}
 
 	path := path.Join(t.TempDir(), "test.wal")
-	wal, err := openWal(testWalId, path)
+	wal, err := openWal(osFS{}, nil, testWalId, path)
 	if err != nil {
 		t.Fatalf("unexpected error connecting to wal: %v", err)
 	}
@@ -70,7 +72,7 @@ func Test_read_ReturnsCommittedTransactionsOnly(t *testing.T) {
 	expected := committedTx
 
 	path := path.Join(t.TempDir(), "test.wal")
-	wal, err := openWal(testWalId, path)
+	wal, err := openWal(osFS{}, nil, testWalId, path)
 	if err != nil {
 		t.Fatalf("unexpected error connecting to wal: %v", err)
 	}
@@ -91,3 +93,247 @@ func Test_read_ReturnsCommittedTransactionsOnly(t *testing.T) {
 			actual)
 	}
 }
+
+func Test_read_TruncatesTornTail(t *testing.T) {
+	// Arrange
+	txId := uuid.NewString()
+	expected := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "value-1"},
+		&commitOperation{transactionId: txId},
+	}
+
+	path := path.Join(t.TempDir(), "test.wal")
+	wal, err := openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	wal.write(expected)
+	info, _ := wal.file.Stat()
+	validSize := info.Size()
+
+	// Simulate a crash part way through writing the next record.
+	torn := []operator{&setOperation{transactionId: uuid.NewString(), key: "key-2", value: "value-2"}}
+	wal.write(torn)
+	wal.close()
+	os.Truncate(path, info.Size()+10)
+
+	// Act
+	wal, err = openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error reconnecting to wal: %v", err)
+	}
+	defer wal.close()
+	actual, err := wal.read()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error reading wal: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected operations:\n\texpected: %+v\n\tactual:   %+v", expected, actual)
+	}
+
+	if info, _ := os.Stat(path); info.Size() != validSize {
+		t.Errorf("expected torn tail to be truncated to %d bytes, found %d", validSize, info.Size())
+	}
+}
+
+func Test_read_ReturnsCorruptionError_WhenCorruptBeforeTail(t *testing.T) {
+	// Arrange
+	txId := uuid.NewString()
+	operations := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "value-1"},
+		&setOperation{transactionId: txId, key: "key-2", value: "value-2"},
+		&commitOperation{transactionId: txId},
+	}
+
+	path := path.Join(t.TempDir(), "test.wal")
+	wal, err := openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	wal.write(operations)
+	wal.close()
+
+	// Flip a byte in the first payload.
+	data, _ := os.ReadFile(path)
+	data[walHeaderSize+walFrameHeaderSize] ^= 0xFF
+	os.WriteFile(path, data, 0644)
+
+	// Act
+	wal, err = openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error reconnecting to wal: %v", err)
+	}
+	defer wal.close()
+	_, err = wal.read()
+
+	// Assert
+	var corruption *WalCorruptionError
+	if !errors.As(err, &corruption) {
+		t.Fatalf("expected a corruption error, found %v", err)
+	}
+
+	if corruption.Offset != int64(walHeaderSize) {
+		t.Errorf("expected corruption at offset %d, found %d", walHeaderSize, corruption.Offset)
+	}
+}
+
+func Test_read_ReturnsCorruptionError_WhenLengthCorruptBeforeTail(t *testing.T) {
+	// Arrange
+	txId := uuid.NewString()
+	operations := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "value-1"},
+		&setOperation{transactionId: txId, key: "key-2", value: "value-2"},
+		&commitOperation{transactionId: txId},
+	}
+
+	path := path.Join(t.TempDir(), "test.wal")
+	wal, err := openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	wal.write(operations)
+	wal.close()
+
+	// Point the first length past the end of the file.
+	data, _ := os.ReadFile(path)
+	data[walHeaderSize+2] = 0x01
+	os.WriteFile(path, data, 0644)
+
+	// Act
+	wal, err = openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error reconnecting to wal: %v", err)
+	}
+	defer wal.close()
+	_, err = wal.read()
+
+	// Assert
+	var corruption *WalCorruptionError
+	if !errors.As(err, &corruption) {
+		t.Fatalf("expected a corruption error, found %v", err)
+	}
+
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Errorf("expected later frames to be kept, found %d of %d bytes", info.Size(), len(data))
+	}
+}
+
+func Test_read_TruncatesTornTail_WhenFinalLengthCorrupt(t *testing.T) {
+	// Arrange
+	txId := uuid.NewString()
+	expected := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "value-1"},
+		&commitOperation{transactionId: txId},
+	}
+
+	path := path.Join(t.TempDir(), "test.wal")
+	wal, err := openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	wal.write(expected)
+	info, _ := wal.file.Stat()
+	validSize := info.Size()
+	wal.write([]operator{&setOperation{transactionId: uuid.NewString(), key: "key-2", value: "value-2"}})
+	wal.close()
+
+	// Damage the final length, which is only caught by the checksum.
+	data, _ := os.ReadFile(path)
+	data[validSize] ^= 0x01
+	os.WriteFile(path, data, 0644)
+
+	// Act
+	wal, err = openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error reconnecting to wal: %v", err)
+	}
+	defer wal.close()
+	actual, err := wal.read()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error reading wal: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected operations:\n\texpected: %+v\n\tactual:   %+v", expected, actual)
+	}
+
+	if info, _ := os.Stat(path); info.Size() != validSize {
+		t.Errorf("expected torn tail to be truncated to %d bytes, found %d", validSize, info.Size())
+	}
+}
+
+func Test_newWalManager_UpgradesLegacyFormat(t *testing.T) {
+	// Arrange
+	txId := uuid.NewString()
+	expected := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "value-1"},
+		&commitOperation{transactionId: txId},
+	}
+
+	var data []byte
+	for _, op := range expected {
+		data = append(data, serialize(op)...)
+	}
+
+	walRoot := t.TempDir()
+	os.WriteFile(path.Join(walRoot, "0.wal"), data, 0644)
+
+	// Act
+	wm, err := newWalManager(osFS{}, nil, walRoot, 0)
+	if err != nil {
+		t.Fatalf("unexpected error opening wal files: %v", err)
+	}
+	defer wm.close()
+	actual, err := wm.walFiles[0].read()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error reading wal: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected operations:\n\texpected: %+v\n\tactual:   %+v", expected, actual)
+	}
+
+	if wm.currentId != 0 || wm.current().header.format != walFormatFramed {
+		t.Errorf("expected file 0 to be upgraded in place, found file %d with format %d", wm.currentId, wm.current().header.format)
+	}
+
+	if data, _ := os.ReadFile(path.Join(walRoot, "0.wal")); string(data[:len(walMagic)]) != walMagic {
+		t.Errorf("expected upgraded file to start with a header")
+	}
+}
+
+func Test_write_RoundTripsValuesContainingSeparators(t *testing.T) {
+	// Arrange
+	txId := uuid.NewString()
+	expected := []operator{
+		&setOperation{transactionId: txId, key: "key-1", value: "a\x1Fb\x1Ec\x00\nd"},
+		&commitOperation{transactionId: txId},
+	}
+
+	path := path.Join(t.TempDir(), "test.wal")
+	wal, err := openWal(osFS{}, nil, testWalId, path)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to wal: %v", err)
+	}
+	defer wal.close()
+
+	// Act
+	wal.write(expected)
+	actual, err := wal.read()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error reading wal: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected operations:\n\texpected: %+v\n\tactual:   %+v", expected, actual)
+	}
+}
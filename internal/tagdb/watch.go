@@ -0,0 +1,262 @@
+package tagdb
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"dev.azure.com/trayport/Hackathon/_git/Q/internal/logger"
+)
+
+const (
+	defaultWatchBufferSize = 256
+)
+
+// Returned by Subscription.Err when changes were published faster than they were received.
+// The subscription is closed, and the consumer should reload its data before watching again.
+var ErrSlowConsumer = errors.New("watch subscription closed because the consumer fell behind")
+
+// A committed change to a record.
+type Change struct {
+	TransactionId string `json:"transactionId"`
+	Key           string `json:"key"`
+
+	// The WAL operation type, such as SET, DELETE, TAG or UNTAG.
+	Op string `json:"op"`
+
+	// The tag added or removed.  Empty for operations without a tag.
+	Tag string `json:"tag,omitempty"`
+
+	// The record version after the commit.  Zero when the record no longer exists.
+	Version uint64 `json:"version,omitempty"`
+}
+
+// Configures a watch subscription.
+type watchConfig struct {
+	// Only changes to keys with this prefix are delivered.
+	prefix string
+
+	// Only changes to records with this tag, or adding or removing it, are delivered.
+	tag string
+
+	// The number of changes buffered before the consumer is considered slow.
+	bufferSize int
+}
+
+// Configures which changes a subscription receives.
+type WatchConfigurer func(watchConfig *watchConfig) *watchConfig
+
+func newWatchConfig(options ...WatchConfigurer) *watchConfig {
+	config := &watchConfig{bufferSize: defaultWatchBufferSize}
+	for _, option := range options {
+		config = option(config)
+	}
+
+	return config
+}
+
+// Delivers changes to keys starting with the prefix.
+func WithKeyPrefix(prefix string) WatchConfigurer {
+	return func(watchConfig *watchConfig) *watchConfig {
+		watchConfig.prefix = prefix
+		return watchConfig
+	}
+}
+
+// Delivers changes to records with the tag, including the tag being added or removed.
+func WithTag(tag string) WatchConfigurer {
+	return func(watchConfig *watchConfig) *watchConfig {
+		watchConfig.tag = tag
+		return watchConfig
+	}
+}
+
+// Sets the number of changes buffered for a subscription.
+func WithBufferSize(size int) WatchConfigurer {
+	return func(watchConfig *watchConfig) *watchConfig {
+		if size <= 0 {
+			logger.Panic("cannot configure watch, buffer size must be greater than 0")
+		}
+
+		watchConfig.bufferSize = size
+		return watchConfig
+	}
+}
+
+// Receives committed changes.  Close the subscription when done.
+type Subscription struct {
+	feed    *changeFeed
+	config  *watchConfig
+	changes chan Change
+
+	// Guarded by the feed lock.
+	closed bool
+	err    error
+}
+
+// Returns the channel of changes.  The channel is closed when the subscription ends.
+func (s *Subscription) Changes() <-chan Change {
+	return s.changes
+}
+
+// Returns why the subscription ended.  ErrSlowConsumer when the consumer fell behind, otherwise nil.
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	return s.err
+}
+
+// Ends the subscription.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	s.feed.remove(s, nil)
+}
+
+// Tests if a change should be delivered to the subscription.
+// Tag filters match records with the tag either before or after the transaction.
+func (s *Subscription) matches(change Change, tagsBefore, tagsAfter func(key string) []string) bool {
+	if !strings.HasPrefix(change.Key, s.config.prefix) {
+		return false
+	}
+
+	if s.config.tag == "" || change.Tag == s.config.tag {
+		return true
+	}
+
+	return slices.Contains(tagsAfter(change.Key), s.config.tag) ||
+		slices.Contains(tagsBefore(change.Key), s.config.tag)
+}
+
+// Publishes committed operations to subscribers.
+type changeFeed struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]bool
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subscribers: map[*Subscription]bool{}}
+}
+
+func (f *changeFeed) subscribe(config *watchConfig) *Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription := &Subscription{
+		feed:    f,
+		config:  config,
+		changes: make(chan Change, config.bufferSize),
+	}
+	f.subscribers[subscription] = true
+
+	return subscription
+}
+
+// Returns the tags of the records changed by the operations, before they are applied.
+// A purge untags a record before deleting it, so its delete only matches these tags.
+// Returns nil when there are no subscribers.
+func (f *changeFeed) tagsBefore(store *inMemStore, operations []operator) map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.subscribers) == 0 {
+		return nil
+	}
+
+	result := map[string][]string{}
+	for _, operation := range operations {
+		change, isChange := toChange(operation)
+		if _, found := result[change.Key]; isChange && !found {
+			result[change.Key] = slices.Clone(store.index.GetValues(change.Key))
+		}
+	}
+
+	return result
+}
+
+// Delivers committed operations to matching subscribers, without blocking.
+// Subscribers with a full buffer are closed with ErrSlowConsumer.
+// The store must already include the operations.  Before holds the tags from tagsBefore.
+func (f *changeFeed) publish(store *inMemStore, operations []operator, before map[string][]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.subscribers) == 0 {
+		return
+	}
+
+	tagsBefore := func(key string) []string {
+		return before[key]
+	}
+
+	tagsAfter := func(key string) []string {
+		return store.index.GetValues(key)
+	}
+
+	for _, operation := range operations {
+		change, isChange := toChange(operation)
+		if !isChange {
+			continue
+		}
+		change.Version = store.versions[change.Key]
+
+		for subscription := range f.subscribers {
+			if !subscription.matches(change, tagsBefore, tagsAfter) {
+				continue
+			}
+
+			select {
+			case subscription.changes <- change:
+			default:
+				logger.Warnf("closing slow watch subscription after %d buffered changes", cap(subscription.changes))
+				f.remove(subscription, ErrSlowConsumer)
+			}
+		}
+	}
+}
+
+// Ends all subscriptions.
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for subscription := range f.subscribers {
+		f.remove(subscription, nil)
+	}
+}
+
+// Closes a subscription.  The feed lock must be held.
+func (f *changeFeed) remove(subscription *Subscription, err error) {
+	if subscription.closed {
+		return
+	}
+
+	subscription.closed = true
+	subscription.err = err
+	close(subscription.changes)
+	delete(f.subscribers, subscription)
+}
+
+func toChange(operation operator) (Change, bool) {
+	switch o := operation.(type) {
+	case *setOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeSet.String()}, true
+	case *deleteOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeDelete.String()}, true
+	case *expireOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeExpire.String()}, true
+	case *tagOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeTag.String(), Tag: o.tag}, true
+	case *untagOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeUntag.String(), Tag: o.tag}, true
+	case *systemTagOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeSystemTag.String(), Tag: o.tag}, true
+	case *systemUntagOperation:
+		return Change{TransactionId: o.transactionId, Key: o.key, Op: opCodeSystemUntag.String(), Tag: o.tag}, true
+	default:
+		return Change{}, false
+	}
+}
@@ -0,0 +1,126 @@
+package tagdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_changeFeed_publish_DeliversMatchingChanges(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	byPrefix := store.feed.subscribe(newWatchConfig(WithKeyPrefix("proj/")))
+	byTag := store.feed.subscribe(newWatchConfig(WithTag("tag-1")))
+
+	// Act.
+	store.set("proj/key-1", "value-1")
+	store.set("other", "value-2")
+	store.tag("other", "tag-1")
+	store.set("other", "value-3")
+
+	// Assert.
+	changes := drain(byPrefix)
+	if len(changes) == 0 {
+		t.Fatalf("Expected changes for prefix")
+	}
+
+	for _, change := range changes {
+		if change.Key != "proj/key-1" {
+			t.Fatalf("Unexpected change for prefix: %+v", change)
+		}
+	}
+
+	if changes[0].Op != "SET" || changes[0].Version == 0 {
+		t.Fatalf("Expected a versioned SET change first: %+v", changes[0])
+	}
+
+	changes = drain(byTag)
+	if len(changes) == 0 || changes[0].Op != "TAG" || changes[0].Tag != "tag-1" {
+		t.Fatalf("Expected the TAG change first: %+v", changes)
+	}
+
+	if last := changes[len(changes)-1]; last.Key != "other" || last.Op == "TAG" {
+		t.Fatalf("Expected later changes to the tagged record: %+v", changes)
+	}
+}
+
+func Test_changeFeed_publish_DeliversPurge_ToTagSubscribers(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(newMemFS(), nil, "/db")
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	store.set("key-1", "value-1")
+	store.tag("key-1", "tag-1")
+	store.delete("key-1")
+	byTag := store.feed.subscribe(newWatchConfig(WithTag("tag-1")))
+
+	// Act.
+	err = store.purge("key-1")
+
+	// Assert.
+	if err != nil {
+		t.Fatalf("Failed to purge key-1: %v", err)
+	}
+
+	changes := drain(byTag)
+	if len(changes) == 0 || changes[len(changes)-1].Op != "DELETE" {
+		t.Fatalf("Expected the purge to end with a DELETE change: %+v", changes)
+	}
+}
+
+func Test_changeFeed_publish_ClosesSlowConsumer(t *testing.T) {
+	// Arrange.
+	store, err := openStorage(osFS{}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to connect to storage: %v", err)
+	}
+	defer store.close()
+
+	slow := store.feed.subscribe(newWatchConfig(WithBufferSize(1)))
+	other := store.feed.subscribe(newWatchConfig())
+
+	// Act.
+	store.set("key-1", "value-1")
+
+	// Assert.
+	if changes := drain(slow); len(changes) != 1 {
+		t.Fatalf("Expected the buffered change only, but found %+v", changes)
+	}
+
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Fatalf("Expected ErrSlowConsumer, but found %v", slow.Err())
+	}
+
+	store.set("key-2", "value-2")
+	if len(drain(slow)) != 0 {
+		t.Fatalf("Expected no changes after the subscription closed")
+	}
+
+	other.Close()
+	if changes := drain(other); len(changes) == 0 || other.Err() != nil {
+		t.Fatalf("Expected other subscriber to receive all changes: %+v, %v", changes, other.Err())
+	}
+}
+
+// Reads buffered changes, until the buffer is empty or the subscription is closed.
+func drain(subscription *Subscription) []Change {
+	var changes []Change
+	for {
+		select {
+		case change, open := <-subscription.Changes():
+			if !open {
+				return changes
+			}
+			changes = append(changes, change)
+		default:
+			return changes
+		}
+	}
+}
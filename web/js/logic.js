@@ -12,6 +12,8 @@
     const newKeyInput = document.getElementById("new-key-input");
     const newValueInput = document.getElementById("new-value-input");
     const createItemBtn = document.getElementById("create-item-btn");
+    const tagList = document.getElementById("tag-list");
+    const tagOptions = document.getElementById("tag-options");
 
     // State
     let lastSearchTags = [];
@@ -104,6 +106,62 @@
         return response.json();
     }
 
+    // API: List tags with their record counts and metadata
+    async function listTags() {
+        const response = await fetch(`${API_BASE}/tags`, {
+            method: 'GET',
+            headers: {
+                'Content-Type': 'application/json'
+            }
+        });
+        await handleResponse(response);
+        return response.json();
+    }
+
+    // Render the tag sidebar, and the tag autocomplete options
+    function renderTags(tags) {
+        tagList.innerHTML = '';
+        tagOptions.innerHTML = '';
+
+        tags.forEach((info) => {
+            const item = document.createElement('li');
+            item.className = 'tag-list-item';
+            item.title = info.description || '';
+
+            const swatch = document.createElement('span');
+            swatch.className = 'tag-swatch';
+            swatch.style.backgroundColor = info.colour || 'var(--primary-colour)';
+
+            const name = document.createElement('span');
+            name.className = 'tag-name';
+            name.textContent = info.tag;
+
+            const count = document.createElement('span');
+            count.className = 'tag-count';
+            count.textContent = info.count;
+
+            item.append(swatch, name, count);
+            item.addEventListener('click', () => {
+                searchInput.value = info.tag;
+                handleSearch();
+            });
+            tagList.appendChild(item);
+
+            const option = document.createElement('option');
+            option.value = info.tag;
+            tagOptions.appendChild(option);
+        });
+    }
+
+    // Refresh the tag sidebar
+    async function refreshTags() {
+        try {
+            renderTags(await listTags());
+        } catch (error) {
+            console.error('Error listing tags:', error);
+        }
+    }
+
     // Create a tag badge with remove button
     function createTagBadge(key, tag) {
         const tagBadge = document.createElement('span');
@@ -122,6 +180,7 @@
             try {
                 await removeTag(key, tag);
                 tagBadge.remove();
+                refreshTags();
                 console.log(`Removed tag "${tag}" from key "${key}"`);
             } catch (error) {
                 console.error('Error removing tag:', error);
@@ -195,6 +254,7 @@
                 tagsContainer.appendChild(createTagBadge(item.key, newTag));
                 existingTags.push(newTag);
                 tagInput.value = '';
+                refreshTags();
                 console.log(`Added tag "${newTag}" to key "${item.key}"`);
             } catch (error) {
                 console.error('Error adding tag:', error);
@@ -232,7 +292,7 @@
         const tags = searchValue === "*" || searchValue === "" ? [] : searchValue.split(" ").filter(t => t);
 
         // Avoid duplicate searches
-        if (tags.toString() === lastSearchTags.toString()) {
+        if (lastSearchTags !== null && tags.toString() === lastSearchTags.toString()) {
             return;
         }
 
@@ -327,7 +387,28 @@
         }
     });
 
+    // Refresh results when another client changes data
+    function watchChanges() {
+        const refresh = debounce(() => {
+            lastSearchTags = null;
+            handleSearch();
+            refreshTags();
+        }, 250);
+
+        const source = new EventSource(`${API_BASE}/watch`);
+        source.addEventListener('change', refresh);
+
+        // The server ends the stream when this client falls behind
+        source.addEventListener('error', () => {
+            source.close();
+            refresh();
+            setTimeout(watchChanges, 1000);
+        });
+    }
+
     // Initial load - show all items
     handleSearch();
+    refreshTags();
+    watchChanges();
 
 })();